@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"remote-ctrl-robot/internal/handlers"
+	"remote-ctrl-robot/internal/models"
 	"remote-ctrl-robot/internal/services"
 
 	"github.com/rs/zerolog"
@@ -26,22 +27,82 @@ func main() {
 	// 设置日志
 	setupLogging()
 
+	// 创建事件中心
+	eventHub := services.NewEventHub(viper.GetInt("events.subscriber_buffer"))
+
 	// 创建服务
-	janusService := services.NewJanusService(
-		viper.GetString("janus.http_url"),
-		viper.GetString("janus.websocket_url"),
-		viper.GetInt("janus.stream_id"),
-	)
+	var janusConfig models.JanusConfig
+	if err := viper.UnmarshalKey("janus", &janusConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse janus configuration")
+	}
+	janusService := services.NewJanusService(janusConfig)
+	janusService.Start()
+
+	var robotConfig models.RobotConfig
+	if err := viper.UnmarshalKey("robot", &robotConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse robot configuration")
+	}
+	robotService := services.NewRobotService(robotConfig, eventHub)
+
+	gameService := services.NewGameService(eventHub)
+	estopService := services.NewEmergencyStopService(eventHub)
+
+	var actionConfig models.ActionRegistryConfig
+	if err := viper.UnmarshalKey("control_actions", &actionConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse control action configuration")
+	}
+	actionRegistry := services.NewActionRegistry(actionConfig)
+
+	var safetyConfig models.SafetyConfig
+	if err := viper.UnmarshalKey("safety", &safetyConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse safety configuration")
+	}
+	safetyService := services.NewSafetyService(safetyConfig)
 
-	robotService := services.NewRobotService(
-		viper.GetString("robot.websocket_url"),
-	)
+	var geofenceConfig models.GeofenceConfig
+	if err := viper.UnmarshalKey("geofence", &geofenceConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse geofence configuration")
+	}
+	geofenceService := services.NewGeofenceService(geofenceConfig)
+
+	protocolNegotiator := services.NewProtocolNegotiator(viper.GetString("protocol.min_version"))
 
-	gameService := services.NewGameService()
+	var policyConfig models.MessagePolicyConfig
+	if err := viper.UnmarshalKey("message_policy", &policyConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse message policy configuration")
+	}
+	messagePolicy := services.NewMessagePolicy(policyConfig)
+
+	var queueConfig models.CommandQueueConfig
+	if err := viper.UnmarshalKey("command_queue", &queueConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse command queue configuration")
+	}
+	commandQueue := services.NewCommandQueueService(queueConfig)
+
+	var latencyConfig models.LatencyConfig
+	if err := viper.UnmarshalKey("latency", &latencyConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse latency configuration")
+	}
+	latencyMonitor := services.NewLatencyMonitor(latencyConfig)
+	missionService := services.NewMissionService(eventHub)
+	signalingService := services.NewSignalingService(janusService, eventHub)
+	mediaStatsService := services.NewMediaStatsService(janusConfig, janusService, signalingService, eventHub)
+
+	var webhookConfig models.WebhookConfig
+	if err := viper.UnmarshalKey("webhooks", &webhookConfig); err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse webhook configuration")
+	}
+	webhookService := services.NewWebhookService(webhookConfig, eventHub)
+	webhookService.Start()
 
 	// 创建处理器
-	wsHandlers := handlers.NewWebSocketHandlers(robotService, gameService)
-	apiHandlers := handlers.NewAPIHandlers(janusService, robotService, wsHandlers)
+	wsHandlers := handlers.NewWebSocketHandlers(robotService, gameService, janusService, estopService, actionRegistry, safetyService, geofenceService, protocolNegotiator, messagePolicy, commandQueue, missionService, signalingService, latencyMonitor, eventHub)
+	apiHandlers := handlers.NewAPIHandlers(janusService, robotService, mediaStatsService, wsHandlers)
+	mediaStatsService.SetBindings(wsHandlers)
+	mediaStatsService.Start()
+	robotService.SetGateway(wsHandlers)
+	robotService.Start()
+	sseHandlers := handlers.NewSSEHandlers(eventHub)
 
 	// 创建HTTP服务器
 	mux := http.NewServeMux()
@@ -53,13 +114,30 @@ func main() {
 	mux.HandleFunc("/api/v1/webrtc/stats", apiHandlers.GetWebRTCStats)
 	mux.HandleFunc("/api/v1/webrtc/cleanup", apiHandlers.CleanupWebRTCStreams)
 	mux.HandleFunc("/api/v1/webrtc/all-play-urls", apiHandlers.GetAllWebRTCPlayURLs)
+	mux.HandleFunc("/api/v1/webrtc/stream", apiHandlers.DeleteWebRTCStream)
+	mux.HandleFunc("/api/v1/webrtc/mountpoints", apiHandlers.GetJanusMountpoints)
+	mux.HandleFunc("/api/v1/webrtc/reconcile", apiHandlers.ReconcileWebRTCStreams)
 	mux.HandleFunc("/api/v1/control/command", apiHandlers.SendControlCommand)
 	mux.HandleFunc("/api/v1/control/status", apiHandlers.GetRobotStatus)
 	mux.HandleFunc("/api/v1/control/connection", apiHandlers.GetConnectionStatus)
+	mux.HandleFunc("/api/v1/control/actions", apiHandlers.GetControlActions)
+	mux.HandleFunc("/api/v1/control/geofence", apiHandlers.GetGeofences)
+	mux.HandleFunc("/api/v1/control/queue", apiHandlers.GetCommandQueue)
+	mux.HandleFunc("/api/v1/missions/start", apiHandlers.StartMission)
+	mux.HandleFunc("/api/v1/missions/pause", apiHandlers.PauseMission)
+	mux.HandleFunc("/api/v1/missions/resume", apiHandlers.ResumeMission)
+	mux.HandleFunc("/api/v1/missions/abort", apiHandlers.AbortMission)
+	mux.HandleFunc("/api/v1/missions/status", apiHandlers.GetMissionStatus)
+	mux.HandleFunc("/api/v1/control/estop", apiHandlers.TriggerEmergencyStop)
+	mux.HandleFunc("/api/v1/control/estop/clear", apiHandlers.ClearEmergencyStop)
+	mux.HandleFunc("/api/v1/control/estop/status", apiHandlers.GetEmergencyStops)
 	mux.HandleFunc("/api/v1/system/status", apiHandlers.GetSystemStatus)
+	mux.HandleFunc("/api/v1/system/violations", apiHandlers.GetViolationMetrics)
+	mux.HandleFunc("/api/v1/errors", apiHandlers.GetErrorCodes)
 	mux.HandleFunc("/api/v1/clients", apiHandlers.GetClients)
 	mux.HandleFunc("/api/v1/clients/info", apiHandlers.GetClientByUCode)
 	mux.HandleFunc("/api/v1/clients/online", apiHandlers.CheckUCodeOnline)
+	mux.HandleFunc("/api/v1/events", sseHandlers.StreamEvents)
 	mux.HandleFunc("/health", apiHandlers.HealthCheck)
 
 	// WebSocket路由
@@ -89,6 +167,13 @@ func main() {
 		}
 	}()
 
+	// 与Janus中已有的挂载点对账
+	go func() {
+		if _, err := janusService.Reconcile(); err != nil {
+			log.Warn().Err(err).Msg("Failed to reconcile Janus mountpoints")
+		}
+	}()
+
 	// 启动清理协程
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -97,9 +182,8 @@ func main() {
 		for {
 			select {
 			case <-ticker.C:
-				robotService.CleanupDisconnectedClients()
 				// 清理无效的WebRTC流
-				cleaned := janusService.CleanupInactiveStreams()
+				cleaned := janusService.CleanupInactiveStreams(janusConfig.InactiveGrace)
 				if cleaned > 0 {
 					log.Info().Int("cleaned_streams", cleaned).Msg("Cleaned up inactive WebRTC streams")
 				}
@@ -122,6 +206,11 @@ func main() {
 		log.Error().Err(err).Msg("Error during server shutdown")
 	}
 
+	robotService.Shutdown()
+	webhookService.Shutdown()
+	mediaStatsService.Shutdown()
+	janusService.Shutdown()
+
 	log.Info().Msg("Server stopped")
 }
 
@@ -141,7 +230,45 @@ func loadConfig() error {
 	viper.SetDefault("janus.websocket_url", "ws://localhost:8188")
 	viper.SetDefault("janus.http_url", "http://localhost:8088")
 	viper.SetDefault("janus.stream_id", 1)
+	viper.SetDefault("janus.auto_register", true)
+	viper.SetDefault("janus.transport", services.JanusTransportHTTP)
+	viper.SetDefault("janus.base_path", services.DefaultJanusBasePath)
+	viper.SetDefault("janus.rtp_ports.min", services.DefaultJanusRTPPortMin)
+	viper.SetDefault("janus.rtp_ports.max", services.DefaultJanusRTPPortMax)
+	viper.SetDefault("janus.video_codec", services.DefaultJanusVideoCodec)
+	viper.SetDefault("janus.keepalive_interval", services.DefaultJanusKeepaliveInterval)
+	viper.SetDefault("janus.inactive_grace", services.DefaultJanusInactiveGrace)
+	viper.SetDefault("janus.batch_concurrency", services.DefaultJanusBatchConcurrency)
+	viper.SetDefault("janus.health_interval", services.DefaultJanusHealthInterval)
+	viper.SetDefault("janus.failure_threshold", services.DefaultJanusFailureThreshold)
+	viper.SetDefault("janus.stats_interval", services.DefaultJanusStatsInterval)
+	viper.SetDefault("janus.stats_history", services.DefaultJanusStatsHistory)
 	viper.SetDefault("robot.websocket_url", "ws://localhost:9090")
+	viper.SetDefault("robot.control_timeout", services.DefaultRobotControlTimeout)
+	viper.SetDefault("robot.max_retries", services.DefaultRobotMaxRetries)
+	viper.SetDefault("robot.heartbeat_interval", services.DefaultRobotHeartbeatInterval)
+	viper.SetDefault("robot.initial_backoff", services.DefaultRobotInitialBackoff)
+	viper.SetDefault("robot.max_backoff", services.DefaultRobotMaxBackoff)
+	viper.SetDefault("protocol.min_version", models.ProtocolVersionMin)
+	viper.SetDefault("message_policy.on_violation", models.ViolationActionReply)
+	viper.SetDefault("message_policy.max_violations", services.DefaultMaxViolations)
+	viper.SetDefault("message_policy.violation_window", services.DefaultViolationWindow)
+	viper.SetDefault("command_queue.max_size", services.DefaultCommandQueueSize)
+	viper.SetDefault("command_queue.ttl", services.DefaultCommandTTL)
+	viper.SetDefault("command_queue.move_ttl", services.DefaultMoveCommandTTL)
+	viper.SetDefault("command_queue.write_timeout", services.DefaultCommandWriteTimeout)
+	viper.SetDefault("events.subscriber_buffer", services.DefaultSubscriberBuffer)
+	viper.SetDefault("latency.ping_interval", services.DefaultLatencyPingInterval)
+	viper.SetDefault("latency.smoothing", services.DefaultLatencySmoothing)
+	viper.SetDefault("latency.laggy_rtt", services.DefaultLatencyLaggyRTT)
+	viper.SetDefault("latency.max_control_rate", services.DefaultLatencyMaxControlRate)
+	viper.SetDefault("latency.min_control_rate", services.DefaultLatencyMinControlRate)
+	viper.SetDefault("webhooks.outbox_path", "data/webhook_outbox.json")
+	viper.SetDefault("webhooks.battery_threshold", services.DefaultWebhookBatteryThreshold)
+	viper.SetDefault("webhooks.max_retries", services.DefaultWebhookMaxRetries)
+	viper.SetDefault("webhooks.initial_backoff", services.DefaultWebhookInitialBackoff)
+	viper.SetDefault("webhooks.max_backoff", services.DefaultWebhookMaxBackoff)
+	viper.SetDefault("webhooks.timeout", services.DefaultWebhookTimeout)
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.format", "json")
 	viper.SetDefault("security.enable_cors", true)
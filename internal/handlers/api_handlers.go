@@ -3,26 +3,32 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"remote-ctrl-robot/internal/models"
 	"remote-ctrl-robot/internal/services"
 
 	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
 )
 
 type APIHandlers struct {
 	janusService *services.JanusService
 	robotService *services.RobotService
+	mediaStats   *services.MediaStatsService
 	wsHandlers   *WebSocketHandlers
 	startTime    time.Time
 }
 
-func NewAPIHandlers(janusService *services.JanusService, robotService *services.RobotService, wsHandlers *WebSocketHandlers) *APIHandlers {
+func NewAPIHandlers(janusService *services.JanusService, robotService *services.RobotService, mediaStats *services.MediaStatsService, wsHandlers *WebSocketHandlers) *APIHandlers {
 	return &APIHandlers{
 		robotService: robotService,
 		janusService: janusService,
+		mediaStats:   mediaStats,
 		wsHandlers:   wsHandlers,
 		startTime:    time.Now(),
 	}
@@ -31,7 +37,7 @@ func NewAPIHandlers(janusService *services.JanusService, robotService *services.
 // 获取WebRTC播放地址
 func (h *APIHandlers) GetWebRTCPlayURL(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
@@ -40,6 +46,7 @@ func (h *APIHandlers) GetWebRTCPlayURL(w http.ResponseWriter, r *http.Request) {
 	if ucode == "" {
 		h.sendJSONResponse(w, http.StatusBadRequest, models.WebRTCPlayURLResponse{
 			Success: false,
+			Code:    models.ErrorCodeBadRequest,
 			Message: "UCODE parameter is required",
 		})
 		return
@@ -47,19 +54,46 @@ func (h *APIHandlers) GetWebRTCPlayURL(w http.ResponseWriter, r *http.Request) {
 
 	log.Info().Str("ucode", ucode).Msg("Request for WebRTC play URLs")
 
-	urls, err := h.janusService.GetWebRTCPlayURLs(ucode)
+	sources, err := h.janusService.GetWebRTCSources(ucode)
+	if err == nil {
+		// 按视频源筛选
+		if name := r.URL.Query().Get("source"); name != "" {
+			filtered := sources[:0]
+			for _, source := range sources {
+				if source.Name == name {
+					filtered = append(filtered, source)
+				}
+			}
+			sources = filtered
+			if len(sources) == 0 {
+				h.sendJSONResponse(w, http.StatusNotFound, models.WebRTCPlayURLResponse{
+					Success: false,
+					Code:    models.ErrorCodeNotFound,
+					Message: fmt.Sprintf("No video source %s for robot %s", name, ucode),
+				})
+				return
+			}
+		}
+	}
 	if err != nil {
 		log.Error().Err(err).Str("ucode", ucode).Msg("Failed to get WebRTC play URLs")
-		h.sendJSONResponse(w, http.StatusInternalServerError, models.WebRTCPlayURLResponse{
+		h.sendJSONResponse(w, http.StatusServiceUnavailable, models.WebRTCPlayURLResponse{
 			Success: false,
+			Code:    models.ErrorCodeUnavailable,
 			Message: "Failed to get WebRTC play URLs: " + err.Error(),
 		})
 		return
 	}
 
+	urls := make([]string, 0, len(sources))
+	for _, source := range sources {
+		urls = append(urls, source.PlayURL)
+	}
+
 	h.sendJSONResponse(w, http.StatusOK, models.WebRTCPlayURLResponse{
 		Success: true,
 		URLs:    urls,
+		Sources: sources,
 		Message: fmt.Sprintf("WebRTC play URLs retrieved successfully for robot %s", ucode),
 	})
 }
@@ -67,7 +101,7 @@ func (h *APIHandlers) GetWebRTCPlayURL(w http.ResponseWriter, r *http.Request) {
 // 注册WebRTC流
 func (h *APIHandlers) RegisterWebRTC(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
@@ -76,6 +110,7 @@ func (h *APIHandlers) RegisterWebRTC(w http.ResponseWriter, r *http.Request) {
 		log.Error().Err(err).Msg("Failed to parse WebRTC register request")
 		h.sendJSONResponse(w, http.StatusBadRequest, models.WebRTCRegisterResponse{
 			Success: false,
+			Code:    models.ErrorCodeBadRequest,
 			Message: "Invalid request format: " + err.Error(),
 		})
 		return
@@ -85,19 +120,24 @@ func (h *APIHandlers) RegisterWebRTC(w http.ResponseWriter, r *http.Request) {
 	if request.UCode == "" {
 		h.sendJSONResponse(w, http.StatusBadRequest, models.WebRTCRegisterResponse{
 			Success: false,
+			Code:    models.ErrorCodeBadRequest,
 			Message: "UCODE is required",
 		})
 		return
 	}
 
-	log.Info().Str("ucode", request.UCode).Msg("Registering WebRTC stream")
+	log.Info().Str("ucode", request.UCode).Str("source", request.Source).Msg("Registering WebRTC stream")
 
 	// 注册WebRTC流
-	stream, err := h.janusService.RegisterWebRTCStream(request.UCode)
+	stream, err := h.janusService.RegisterWebRTCSource(request.UCode, models.VideoSource{
+		Name:  request.Source,
+		Codec: request.Codec,
+	})
 	if err != nil {
 		log.Error().Err(err).Str("ucode", request.UCode).Msg("Failed to register WebRTC stream")
-		h.sendJSONResponse(w, http.StatusInternalServerError, models.WebRTCRegisterResponse{
+		h.sendJSONResponse(w, http.StatusServiceUnavailable, models.WebRTCRegisterResponse{
 			Success: false,
+			Code:    models.ErrorCodeUnavailable,
 			Message: "Failed to register WebRTC stream: " + err.Error(),
 		})
 		return
@@ -113,72 +153,45 @@ func (h *APIHandlers) RegisterWebRTC(w http.ResponseWriter, r *http.Request) {
 // 发送控制命令
 func (h *APIHandlers) SendControlCommand(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
 	var command models.CMD_CONTROL_ROBOT
 	if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
 		log.Error().Err(err).Msg("Failed to parse control command")
-		response := models.CMD_RESPONSE{
-			Success: false,
-			Message: "Invalid command format: " + err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "Invalid command format: "+err.Error(), nil)
 		return
 	}
 
 	// 从查询参数获取UCODE
 	ucode := r.URL.Query().Get("ucode")
 	if ucode == "" {
-		response := models.CMD_RESPONSE{
-			Success: false,
-			Message: "UCODE parameter is required",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCODE parameter is required", nil)
 		return
 	}
 
 	// 检查机器人是否在线
 	if !h.isRobotOnline(ucode) {
-		response := models.CMD_RESPONSE{
-			Success: false,
-			Message: fmt.Sprintf("Robot with UCODE %s is not online", ucode),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(response)
+		sendErrorResponse(w, models.ErrorCodeRobotOffline, fmt.Sprintf("Robot with UCODE %s is not online", ucode), nil)
 		return
 	}
 
-	// 验证命令
-	if err := h.validateCommand(&command); err != nil {
-		log.Error().Err(err).Str("ucode", ucode).Msg("Command validation failed")
-		response := models.CMD_RESPONSE{
-			Success: false,
-			Message: "Command validation failed: " + err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+	// 任务执行中只接受停止命令
+	if err := h.wsHandlers.CheckMissionOverride(ucode, &command); err != nil {
+		sendErrorResponse(w, models.ErrorCodeOf(err), err.Error(), nil)
 		return
 	}
 
-	// 发送命令到指定机器人
-	err := h.sendCommandToRobot(ucode, command)
+	// 与websocket控制命令走同一流程：急停、校验、电子围栏、安全包络、入队
+	limits, err := h.wsHandlers.ExecuteControl(ucode, nil, command)
 	if err != nil {
-		log.Error().Err(err).Str("ucode", ucode).Msg("Failed to send command to robot")
-		response := models.CMD_RESPONSE{
-			Success: false,
-			Message: "Failed to send command: " + err.Error(),
+		log.Warn().Err(err).Str("ucode", ucode).Msg("Control command rejected")
+		var data interface{}
+		if limits != nil {
+			data = limits
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		sendErrorResponse(w, models.ErrorCodeOf(err), "Failed to send command: "+err.Error(), data)
 		return
 	}
 
@@ -189,7 +202,10 @@ func (h *APIHandlers) SendControlCommand(w http.ResponseWriter, r *http.Request)
 
 	response := models.CMD_RESPONSE{
 		Success: true,
-		Message: fmt.Sprintf("Command sent successfully to robot %s", ucode),
+		Message: fmt.Sprintf("Command queued for robot %s", ucode),
+	}
+	if limits != nil {
+		response.Data = limits
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -199,34 +215,20 @@ func (h *APIHandlers) SendControlCommand(w http.ResponseWriter, r *http.Request)
 // 获取机器人状态
 func (h *APIHandlers) GetRobotStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
 	// 从查询参数获取UCODE
 	ucode := r.URL.Query().Get("ucode")
 	if ucode == "" {
-		response := models.RobotState{
-			Status:       "error",
-			ErrorCode:    400,
-			ErrorMessage: "UCODE parameter is required",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCODE parameter is required", nil)
 		return
 	}
 
 	// 检查机器人是否在线
 	if !h.isRobotOnline(ucode) {
-		response := models.RobotState{
-			Status:       "offline",
-			ErrorCode:    404,
-			ErrorMessage: fmt.Sprintf("Robot with UCODE %s is not online", ucode),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(response)
+		sendErrorResponse(w, models.ErrorCodeRobotOffline, fmt.Sprintf("Robot with UCODE %s is not online", ucode), nil)
 		return
 	}
 
@@ -248,66 +250,322 @@ func (h *APIHandlers) GetRobotStatus(w http.ResponseWriter, r *http.Request) {
 
 // 获取系统状态
 func (h *APIHandlers) GetSystemStatus(w http.ResponseWriter, r *http.Request) {
-	// if r.Method != "GET" {
-	// 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	// 	return
-	// }
-
-	// // 检查Janus状态
-	// janusStatus := "healthy"
-	// if err := h.janusService.CheckStatus(); err != nil {
-	// 	janusStatus = "error"
-	// 	log.Error().Err(err).Msg("Janus status check failed")
-	// }
-
-	// // 获取机器人连接状态
-	// robotStatus := "connected"
-	// connStatus := h.wsHandlers.GetConnectionStatus()
-	// if !connStatus.Connected {
-	// 	robotStatus = "disconnected"
-	// }
-
-	// // 获取在线机器人列表
-	// onlineRobots := h.getOnlineRobots()
-
-	// status := models.SystemStatus{
-	// 	ServerTime:    time.Now(),
-	// 	Uptime:        int64(time.Since(h.startTime).Seconds()),
-	// 	ActiveClients: h.wsHandlers.GetActiveClientsCount(),
-	// 	RobotStatus:   robotStatus,
-	// 	JanusStatus:   janusStatus,
-	// }
-
-	// // 添加在线机器人信息
-	// statusData := map[string]interface{}{
-	// 	"server_time":    status.ServerTime,
-	// 	"uptime_seconds": status.Uptime,
-	// 	"active_clients": status.ActiveClients,
-	// 	"robot_status":   status.RobotStatus,
-	// 	"janus_status":   status.JanusStatus,
-	// 	"online_robots":  onlineRobots,
-	// 	"total_robots":   len(onlineRobots),
-	// }
-
-	// w.Header().Set("Content-Type", "application/json")
-	// json.NewEncoder(w).Encode(statusData)
-}
-
-// 获取连接状态
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	// Janus状态取自后台健康检查，不在请求中访问Janus
+	janusHealth := h.janusService.GetHealth()
+	healthy := 0
+	for _, instance := range janusHealth {
+		if instance.Healthy {
+			healthy++
+		}
+	}
+	janusStatus := "healthy"
+	switch {
+	case healthy == 0:
+		janusStatus = "error"
+	case healthy < len(janusHealth):
+		janusStatus = "degraded"
+	}
+
+	// 获取在线机器人列表
+	onlineRobots := h.getOnlineRobots()
+	robotStatus := "connected"
+	if len(onlineRobots) == 0 {
+		robotStatus = "disconnected"
+	}
+
+	status := models.SystemStatus{
+		ServerTime:    time.Now(),
+		Uptime:        int64(time.Since(h.startTime).Seconds()),
+		ActiveClients: len(h.wsHandlers.GetAllRobotConnections()) + len(h.wsHandlers.GetAllOperatorConnections()),
+		RobotStatus:   robotStatus,
+		JanusStatus:   janusStatus,
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"server_time":     status.ServerTime,
+		"uptime_seconds":  status.Uptime,
+		"active_clients":  status.ActiveClients,
+		"robot_status":    status.RobotStatus,
+		"janus_status":    status.JanusStatus,
+		"janus_instances": janusHealth,
+		"online_robots":   onlineRobots,
+		"total_robots":    len(onlineRobots),
+	})
+}
+
+// 获取上游机器人连接状态，POST在重连次数用尽后重新启动桥接
 func (h *APIHandlers) GetConnectionStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if err := h.robotService.Reconnect(); err != nil {
+			sendErrorResponse(w, models.ErrorCodeOf(err), err.Error(), nil)
+			return
+		}
+	default:
+		methodNotAllowed(w)
+		return
+	}
 
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"connection": h.robotService.GetConnectionStatus(),
+	})
 }
 
-// 验证控制命令
-func (h *APIHandlers) validateCommand(command *models.CMD_CONTROL_ROBOT) error {
-	// 检查命令类型
-	return nil
+// 获取支持的控制动作
+func (h *APIHandlers) GetControlActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	registry := h.wsHandlers.actionRegistry
+
+	// 按机器人查询
+	if ucode := r.URL.Query().Get("ucode"); ucode != "" {
+		model, actions := registry.GetActions(h.wsHandlers.robotModel(ucode))
+		h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"ucode":   ucode,
+			"model":   model,
+			"actions": actions,
+		})
+		return
+	}
+
+	// 按型号查询
+	if model := r.URL.Query().Get("model"); model != "" {
+		resolved, actions := registry.GetActions(model)
+		h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"model":   resolved,
+			"actions": actions,
+		})
+		return
+	}
+
+	// 所有型号
+	allModels := make(map[string][]models.ActionSchema)
+	for _, model := range registry.GetModels() {
+		_, actions := registry.GetActions(model)
+		allModels[model] = actions
+	}
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"models":  allModels,
+	})
+}
+
+// 获取机器人控制命令下发队列
+func (h *APIHandlers) GetCommandQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	commandQueue := h.wsHandlers.commandQueue
+
+	if ucode := r.URL.Query().Get("ucode"); ucode != "" {
+		status, exists := commandQueue.GetStatus(ucode)
+		if !exists {
+			sendErrorResponse(w, models.ErrorCodeRobotOffline, fmt.Sprintf("Robot with UCODE %s is not online", ucode), nil)
+			return
+		}
+		h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"queue":   status,
+		})
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"queues":  commandQueue.GetStatuses(),
+	})
+}
+
+// 开始任务，请求体为JSON或YAML格式的任务定义
+func (h *APIHandlers) StartMission(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w)
+		return
+	}
+
+	ucode := r.URL.Query().Get("ucode")
+	if ucode == "" {
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCODE parameter is required", nil)
+		return
+	}
+	if !h.isRobotOnline(ucode) {
+		sendErrorResponse(w, models.ErrorCodeRobotOffline, fmt.Sprintf("Robot with UCODE %s is not online", ucode), nil)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "Failed to read request body: "+err.Error(), nil)
+		return
+	}
+
+	var mission models.Mission
+	if strings.Contains(r.Header.Get("Content-Type"), "yaml") || r.URL.Query().Get("format") == "yaml" {
+		err = yaml.Unmarshal(body, &mission)
+	} else {
+		err = json.Unmarshal(body, &mission)
+	}
+	if err != nil {
+		sendErrorResponse(w, models.ErrorCodeInvalidMission, "Invalid mission format: "+err.Error(), nil)
+		return
+	}
+
+	startedBy := "api:" + r.RemoteAddr
+	if operator := r.URL.Query().Get("operator"); operator != "" {
+		startedBy = "api:" + operator
+	}
+
+	status, err := h.wsHandlers.missionService.Start(ucode, mission, startedBy)
+	if err != nil {
+		sendErrorResponse(w, models.ErrorCodeOf(err), err.Error(), nil)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"status":  status,
+		"message": fmt.Sprintf("Mission started for robot %s", ucode),
+	})
+}
+
+// 暂停任务
+func (h *APIHandlers) PauseMission(w http.ResponseWriter, r *http.Request) {
+	h.controlMission(w, r, func(ucode string) (models.MissionStatus, error) {
+		return h.wsHandlers.missionService.Pause(ucode)
+	})
+}
+
+// 继续任务
+func (h *APIHandlers) ResumeMission(w http.ResponseWriter, r *http.Request) {
+	h.controlMission(w, r, func(ucode string) (models.MissionStatus, error) {
+		return h.wsHandlers.missionService.Resume(ucode)
+	})
+}
+
+// 中止任务
+func (h *APIHandlers) AbortMission(w http.ResponseWriter, r *http.Request) {
+	h.controlMission(w, r, func(ucode string) (models.MissionStatus, error) {
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "aborted by api:" + r.RemoteAddr
+		}
+		return h.wsHandlers.missionService.Abort(ucode, reason)
+	})
+}
+
+// 暂停/继续/中止任务的公共处理
+func (h *APIHandlers) controlMission(w http.ResponseWriter, r *http.Request, action func(ucode string) (models.MissionStatus, error)) {
+	if r.Method != "POST" {
+		methodNotAllowed(w)
+		return
+	}
+
+	ucode := r.URL.Query().Get("ucode")
+	if ucode == "" {
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCODE parameter is required", nil)
+		return
+	}
+
+	status, err := action(ucode)
+	if err != nil {
+		sendErrorResponse(w, models.ErrorCodeOf(err), err.Error(), nil)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"status":  status,
+	})
+}
+
+// 获取任务状态
+func (h *APIHandlers) GetMissionStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	missionService := h.wsHandlers.missionService
+
+	if ucode := r.URL.Query().Get("ucode"); ucode != "" {
+		status, exists := missionService.GetStatus(ucode)
+		if !exists {
+			sendErrorResponse(w, models.ErrorCodeMissionNotFound, fmt.Sprintf("No mission for robot %s", ucode), nil)
+			return
+		}
+		h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"status":  status,
+		})
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"missions": missionService.GetStatuses(),
+	})
+}
+
+// 获取电子围栏及机器人围栏状态
+func (h *APIHandlers) GetGeofences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	geofence := h.wsHandlers.geofence
+
+	if ucode := r.URL.Query().Get("ucode"); ucode != "" {
+		status, exists := geofence.GetStatus(ucode)
+		if !exists {
+			sendErrorResponse(w, models.ErrorCodeNotFound, fmt.Sprintf("No geofence status for robot %s", ucode), nil)
+			return
+		}
+		h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"status":  status,
+		})
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"fences":   geofence.GetFences(),
+		"statuses": geofence.GetStatuses(),
+	})
+}
+
+// 获取WebSocket消息违规统计
+func (h *APIHandlers) GetViolationMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"metrics": h.wsHandlers.policy.Metrics(),
+	})
 }
 
 // 健康检查
 func (h *APIHandlers) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
@@ -330,27 +588,73 @@ func (h *APIHandlers) isRobotOnline(ucode string) bool {
 	return exists
 }
 
-// 发送命令到指定机器人
-func (h *APIHandlers) sendCommandToRobot(ucode string, command models.CMD_CONTROL_ROBOT) error {
-	h.wsHandlers.mutex.RLock()
-	conn, exists := h.wsHandlers.Ucode2Conn[ucode]
-	h.wsHandlers.mutex.RUnlock()
+// 急停
+func (h *APIHandlers) TriggerEmergencyStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w)
+		return
+	}
+
+	var request models.EmergencyStopRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "Invalid request format: "+err.Error(), nil)
+		return
+	}
+
+	triggeredBy := "api:" + r.RemoteAddr
+	if request.Operator != "" {
+		triggeredBy = "api:" + request.Operator
+	}
+
+	result := h.wsHandlers.EmergencyStop(request.UCodes, triggeredBy, request.Reason)
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": len(result.Failed) == 0,
+		"result":  result,
+		"message": fmt.Sprintf("Emergency stop: %d stopped, %d offline, %d failed",
+			len(result.Stopped), len(result.Offline), len(result.Failed)),
+	})
+}
+
+// 解除急停
+func (h *APIHandlers) ClearEmergencyStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w)
+		return
+	}
+
+	var request models.EmergencyStopRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "Invalid request format: "+err.Error(), nil)
+		return
+	}
 
-	if !exists {
-		return fmt.Errorf("robot with UCODE %s is not online", ucode)
+	clearedBy := "api:" + r.RemoteAddr
+	if request.Operator != "" {
+		clearedBy = "api:" + request.Operator
 	}
 
-	message := models.WebSocketMessage{
-		Type:       models.WSMessageTypeRequest,
-		Command:    models.CMD_TYPE_CONTROL_ROBOT,
-		Sequence:   0,
-		UCode:      ucode,
-		ClientType: models.ClientTypeOperator,
-		Version:    "1.0.0",
-		Data:       command,
+	records := h.wsHandlers.ClearEmergencyStop(request.UCodes, clearedBy)
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"records": records,
+		"message": fmt.Sprintf("Cleared emergency stop for %d robot(s)", len(records)),
+	})
+}
+
+// 获取急停状态
+func (h *APIHandlers) GetEmergencyStops(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
 	}
 
-	return conn.WriteJSON(message)
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"active":  h.wsHandlers.estopService.GetActive(),
+		"history": h.wsHandlers.estopService.GetHistory(),
+	})
 }
 
 // 获取在线机器人列表
@@ -375,7 +679,7 @@ func (h *APIHandlers) sendJSONResponse(w http.ResponseWriter, statusCode int, da
 // 批量注册WebRTC流
 func (h *APIHandlers) BatchRegisterWebRTC(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
@@ -385,18 +689,12 @@ func (h *APIHandlers) BatchRegisterWebRTC(w http.ResponseWriter, r *http.Request
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		log.Error().Err(err).Msg("Failed to parse batch register request")
-		h.sendJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
-			"success": false,
-			"message": "Invalid request format: " + err.Error(),
-		})
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "Invalid request format: "+err.Error(), nil)
 		return
 	}
 
 	if len(request.UCodes) == 0 {
-		h.sendJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
-			"success": false,
-			"message": "UCodes list cannot be empty",
-		})
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCodes list cannot be empty", nil)
 		return
 	}
 
@@ -404,12 +702,20 @@ func (h *APIHandlers) BatchRegisterWebRTC(w http.ResponseWriter, r *http.Request
 
 	results := h.janusService.BatchRegisterWebRTCStreams(request.UCodes)
 
-	successCount := len(results)
-	failedCount := len(request.UCodes) - successCount
+	streams := make(map[string]*models.WebRTCStream)
+	successCount := 0
+	for _, result := range results {
+		if result.Success {
+			streams[result.UCode] = result.Stream
+			successCount++
+		}
+	}
+	failedCount := len(results) - successCount
 
 	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
 		"success":       true,
-		"streams":       results,
+		"streams":       streams,
+		"results":       results,
 		"success_count": successCount,
 		"failed_count":  failedCount,
 		"message":       fmt.Sprintf("Batch registration completed: %d success, %d failed", successCount, failedCount),
@@ -419,17 +725,20 @@ func (h *APIHandlers) BatchRegisterWebRTC(w http.ResponseWriter, r *http.Request
 // 获取WebRTC流统计
 func (h *APIHandlers) GetWebRTCStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
 	stats := h.janusService.GetStreamStats()
 	allStreams := h.janusService.GetAllStreams()
+	// 媒体统计可按UCODE过滤
+	media := h.mediaStats.GetStats(r.URL.Query().Get("ucode"))
 
 	response := map[string]interface{}{
 		"success": true,
 		"stats":   stats,
 		"streams": allStreams,
+		"media":   media,
 		"message": "WebRTC statistics retrieved successfully",
 	}
 
@@ -439,11 +748,12 @@ func (h *APIHandlers) GetWebRTCStats(w http.ResponseWriter, r *http.Request) {
 // 清理无效WebRTC流
 func (h *APIHandlers) CleanupWebRTCStreams(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
-	cleaned := h.janusService.CleanupInactiveStreams()
+	// 手动清理不等待宽限期
+	cleaned := h.janusService.CleanupInactiveStreams(0)
 
 	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
 		"success":       true,
@@ -452,10 +762,106 @@ func (h *APIHandlers) CleanupWebRTCStreams(w http.ResponseWriter, r *http.Reques
 	})
 }
 
+// 删除WebRTC流并销毁Janus挂载点，未指定source时删除机器人全部视频源
+func (h *APIHandlers) DeleteWebRTCStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		methodNotAllowed(w)
+		return
+	}
+
+	ucode := r.URL.Query().Get("ucode")
+	if ucode == "" {
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCODE parameter is required", nil)
+		return
+	}
+
+	if err := h.janusService.DeleteStream(ucode, r.URL.Query().Get("source")); err != nil {
+		log.Error().Err(err).Str("ucode", ucode).Msg("Failed to delete WebRTC stream")
+		code := models.ErrorCodeOf(err)
+		if code == models.ErrorCodeInternal {
+			code = models.ErrorCodeUnavailable
+		}
+		sendErrorResponse(w, code, "Failed to delete WebRTC stream: "+err.Error(), nil)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("WebRTC stream deleted for robot %s", ucode),
+	})
+}
+
+// 获取Janus中的挂载点，instance指定实例，未指定时查询所有健康实例（按id查询时为主实例）
+func (h *APIHandlers) GetJanusMountpoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	instance := r.URL.Query().Get("instance")
+	if idParam := r.URL.Query().Get("id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			sendErrorResponse(w, models.ErrorCodeBadRequest, "Invalid mountpoint id", nil)
+			return
+		}
+		mountpoint, err := h.janusService.MountpointInfo(instance, id)
+		if err != nil {
+			sendErrorResponse(w, mountpointErrorCode(err), "Failed to get mountpoint info: "+err.Error(), nil)
+			return
+		}
+		h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"mountpoint": mountpoint,
+		})
+		return
+	}
+
+	mountpoints, err := h.janusService.ListMountpoints(instance)
+	if err != nil {
+		sendErrorResponse(w, mountpointErrorCode(err), "Failed to list mountpoints: "+err.Error(), nil)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"mountpoints": mountpoints,
+	})
+}
+
+// 未知实例返回NOT_FOUND，其余视为Janus不可用
+func mountpointErrorCode(err error) models.ErrorCode {
+	if code := models.ErrorCodeOf(err); code == models.ErrorCodeNotFound {
+		return code
+	}
+	return models.ErrorCodeUnavailable
+}
+
+// 与Janus挂载点对账
+func (h *APIHandlers) ReconcileWebRTCStreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w)
+		return
+	}
+
+	result, err := h.janusService.Reconcile()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reconcile Janus mountpoints")
+		sendErrorResponse(w, models.ErrorCodeUnavailable, "Failed to reconcile mountpoints: "+err.Error(), nil)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"result":  result,
+		"message": "Janus mountpoints reconciled",
+	})
+}
+
 // 获取所有播放地址
 func (h *APIHandlers) GetAllWebRTCPlayURLs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w)
 		return
 	}
 
@@ -488,18 +894,13 @@ func (h *APIHandlers) GetClients(w http.ResponseWriter, r *http.Request) {
 func (h *APIHandlers) GetClientByUCode(w http.ResponseWriter, r *http.Request) {
 	ucode := r.URL.Query().Get("ucode")
 	if ucode == "" {
-		http.Error(w, "UCode parameter is required", http.StatusBadRequest)
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCode parameter is required", nil)
 		return
 	}
 
 	client := h.wsHandlers.GetClientByUcode(ucode)
 	if client == nil {
-		response := map[string]interface{}{
-			"success": false,
-			"message": "Client not found",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		sendErrorResponse(w, models.ErrorCodeNotFound, "Client not found", nil)
 		return
 	}
 
@@ -508,6 +909,19 @@ func (h *APIHandlers) GetClientByUCode(w http.ResponseWriter, r *http.Request) {
 		"client":  client,
 	}
 
+	// 机器人附带实际可用的控制动作
+	if client.ClientType == models.ClientTypeRobot {
+		model, schemas := h.wsHandlers.actionRegistry.GetActions(h.wsHandlers.robotModel(ucode))
+		actions := make([]models.ActionSchema, 0, len(schemas))
+		for _, schema := range schemas {
+			if schema.Name == models.ControlActionStop || client.Capabilities.SupportsAction(schema.Name) {
+				actions = append(actions, schema)
+			}
+		}
+		response["model"] = model
+		response["actions"] = actions
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -516,7 +930,7 @@ func (h *APIHandlers) GetClientByUCode(w http.ResponseWriter, r *http.Request) {
 func (h *APIHandlers) CheckUCodeOnline(w http.ResponseWriter, r *http.Request) {
 	ucode := r.URL.Query().Get("ucode")
 	if ucode == "" {
-		http.Error(w, "UCode parameter is required", http.StatusBadRequest)
+		sendErrorResponse(w, models.ErrorCodeBadRequest, "UCode parameter is required", nil)
 		return
 	}
 
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"remote-ctrl-robot/internal/models"
+)
+
+// 错误码对应的HTTP状态码
+func httpStatusForError(code models.ErrorCode) int {
+	switch code {
+	case models.ErrorCodeBadRequest,
+		models.ErrorCodeInvalidMessage,
+		models.ErrorCodeInvalidPayload,
+		models.ErrorCodeUnknownCommand,
+		models.ErrorCodeInvalidCommand,
+		models.ErrorCodeUnsupportedAction,
+		models.ErrorCodeInvalidMission,
+		models.ErrorCodeInvalidProtocolVersion,
+		models.ErrorCodeUnsupportedProtocolVersion:
+		return http.StatusBadRequest
+	case models.ErrorCodeUnauthorized:
+		return http.StatusForbidden
+	case models.ErrorCodeNotRegistered:
+		return http.StatusUnauthorized
+	case models.ErrorCodeNotFound,
+		models.ErrorCodeRobotOffline,
+		models.ErrorCodeGameNotFound,
+		models.ErrorCodeNotInGame,
+		models.ErrorCodeMissionNotFound:
+		return http.StatusNotFound
+	case models.ErrorCodeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case models.ErrorCodeUCodeInUse,
+		models.ErrorCodeNotBound,
+		models.ErrorCodeAlreadyBound,
+		models.ErrorCodeEmergencyStop,
+		models.ErrorCodeGeofence,
+		models.ErrorCodeGameState,
+		models.ErrorCodeAlreadyInGame,
+		models.ErrorCodeNotEnoughPlayers,
+		models.ErrorCodeRobotNotAlive,
+		models.ErrorCodeMissionState:
+		return http.StatusConflict
+	case models.ErrorCodeSafetyLimit,
+		models.ErrorCodeOutOfBounds:
+		return http.StatusUnprocessableEntity
+	case models.ErrorCodeCooldown,
+		models.ErrorCodeRateLimited:
+		return http.StatusTooManyRequests
+	case models.ErrorCodeSendFailed:
+		return http.StatusBadGateway
+	case models.ErrorCodeUnavailable,
+		models.ErrorCodeQueueFull:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// 发送REST错误响应，HTTP状态码由错误码决定
+func sendErrorResponse(w http.ResponseWriter, code models.ErrorCode, message string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusForError(code))
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Success: false,
+		Code:    code,
+		Message: message,
+		Data:    data,
+	})
+}
+
+// 请求方法不支持
+func methodNotAllowed(w http.ResponseWriter) {
+	sendErrorResponse(w, models.ErrorCodeMethodNotAllowed, "Method not allowed", nil)
+}
+
+// 获取错误码说明
+func (h *APIHandlers) GetErrorCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"codes":   models.ErrorCatalog(httpStatusForError),
+	})
+}
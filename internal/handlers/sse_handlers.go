@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+	"remote-ctrl-robot/internal/services"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SSE保活间隔
+const sseKeepAliveInterval = 15 * time.Second
+
+type SSEHandlers struct {
+	hub *services.EventHub
+}
+
+func NewSSEHandlers(hub *services.EventHub) *SSEHandlers {
+	return &SSEHandlers{
+		hub: hub,
+	}
+}
+
+// 事件过滤条件
+type eventFilter struct {
+	ucodes map[string]bool
+	types  map[models.EventType]bool
+}
+
+// 解析逗号分隔的查询参数
+func parseListParam(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func newEventFilter(r *http.Request) *eventFilter {
+	filter := &eventFilter{
+		ucodes: make(map[string]bool),
+		types:  make(map[models.EventType]bool),
+	}
+
+	query := r.URL.Query()
+	for _, ucode := range parseListParam(query.Get("ucode")) {
+		filter.ucodes[ucode] = true
+	}
+	for _, eventType := range parseListParam(query.Get("type")) {
+		filter.types[models.EventType(eventType)] = true
+	}
+	return filter
+}
+
+// 检查事件是否符合过滤条件
+func (f *eventFilter) match(event models.Event) bool {
+	if len(f.types) > 0 && !f.types[event.Type] {
+		return false
+	}
+	if len(f.ucodes) == 0 {
+		return true
+	}
+	if f.ucodes[event.UCode] {
+		return true
+	}
+
+	// 事件涉及多个UCode时逐一匹配
+	switch data := event.Data.(type) {
+	case models.BindingChange:
+		return f.ucodes[data.OperatorUCode] || f.ucodes[data.RobotUCode]
+	case models.CMD_GAME_EVENT:
+		if data.Event != nil {
+			return f.ucodes[data.Event.ShooterUCode] || f.ucodes[data.Event.TargetUCode]
+		}
+	}
+	return false
+}
+
+// 事件流 (Server-Sent Events)
+func (h *SSEHandlers) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendErrorResponse(w, models.ErrorCodeInternal, "Streaming not supported", nil)
+		return
+	}
+
+	// 事件流为长连接，取消服务器写超时
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Warn().Err(err).Msg("Failed to clear write deadline for event stream")
+	}
+
+	filter := newEventFilter(r)
+	sub := h.hub.Subscribe(services.TopicFleet, services.TopicGame)
+	defer h.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	log.Info().
+		Str("remote_addr", r.RemoteAddr).
+		Str("ucode", r.URL.Query().Get("ucode")).
+		Str("type", r.URL.Query().Get("type")).
+		Msg("Event stream opened")
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	var eventID int64
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Info().Str("remote_addr", r.RemoteAddr).Msg("Event stream closed")
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			if !filter.match(event) {
+				continue
+			}
+
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Error().Err(err).Str("type", string(event.Type)).Msg("Failed to serialize event")
+				continue
+			}
+
+			eventID++
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", eventID, event.Type, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket子协议，优先使用二进制编码
+var wsSubprotocols = []string{models.EncodingMsgpack, models.EncodingJSON}
+
+// 消息编解码器
+type wsCodec interface {
+	Name() string
+	FrameType() int
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// 按协商的子协议选择编解码器，未协商时使用JSON
+func codecFor(subprotocol string) wsCodec {
+	if subprotocol == models.EncodingMsgpack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return models.EncodingJSON }
+func (jsonCodec) FrameType() int                             { return websocket.TextMessage }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// MessagePack编码，字段名沿用json标签
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string   { return models.EncodingMsgpack }
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// 原始数据，两种编码都只截取不解析
+type rawData []byte
+
+func (r *rawData) UnmarshalJSON(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
+}
+
+func (r *rawData) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	*r = rawData(raw)
+	return nil
+}
+
+// 入站消息外层，data延迟解码
+type wsEnvelope struct {
+	Type       models.WSMessageType `json:"type"`
+	Command    models.CommandType   `json:"command"`
+	Sequence   int64                `json:"sequence"`
+	UCode      string               `json:"ucode"`
+	ClientType models.ClientType    `json:"client_type"`
+	Version    string               `json:"version"`
+	Data       rawData              `json:"data"`
+}
+
+// 延迟解码的消息数据
+type rawPayload struct {
+	codec wsCodec
+	raw   []byte
+}
+
+func (p rawPayload) Decode(v interface{}) error {
+	return p.codec.Unmarshal(p.raw, v)
+}
+
+// 空数据：缺失或为null/nil
+func (p rawPayload) Empty() bool {
+	switch p.codec.Name() {
+	case models.EncodingMsgpack:
+		return len(p.raw) == 0 || (len(p.raw) == 1 && p.raw[0] == 0xc0)
+	default:
+		trimmed := bytes.TrimSpace(p.raw)
+		return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+	}
+}
+
+// 日志输出，二进制数据只输出长度
+func (p rawPayload) String() string {
+	if p.codec.Name() == models.EncodingJSON {
+		return string(p.raw)
+	}
+	return fmt.Sprintf("<%s %d bytes>", p.codec.Name(), len(p.raw))
+}
+
+// 解码入站消息，data保留为 models.Payload
+func decodeMessage(codec wsCodec, data []byte) (models.WebSocketMessage, error) {
+	var envelope wsEnvelope
+	if err := codec.Unmarshal(data, &envelope); err != nil {
+		return models.WebSocketMessage{}, err
+	}
+	return models.WebSocketMessage{
+		Type:       envelope.Type,
+		Command:    envelope.Command,
+		Sequence:   envelope.Sequence,
+		UCode:      envelope.UCode,
+		ClientType: envelope.ClientType,
+		Version:    envelope.Version,
+		Data:       rawPayload{codec: codec, raw: envelope.Data},
+	}, nil
+}
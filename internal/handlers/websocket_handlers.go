@@ -2,12 +2,12 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"encoding/binary"
 	"fmt"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"remote-ctrl-robot/internal/models"
@@ -31,19 +31,49 @@ type WebSocketHandlers struct {
 	Operator2Robot map[string]string
 	Robot2Operator map[string]string
 
+	// 事件订阅
+	subscriptions map[*websocket.Conn]*services.Subscription
+
 	// 连接管理
 	ctx    context.Context
 	cancel context.CancelFunc
 	mutex  sync.RWMutex
 
-	robotService *services.RobotService
-	gameService  *services.GameService
+	// 连接写入器，gorilla/websocket 不支持并发写
+	writers     map[*websocket.Conn]*connWriter
+	writerMutex sync.Mutex
+
+	// 下发命令序列号
+	sequence int64
+
+	robotService   *services.RobotService
+	gameService    *services.GameService
+	janusService   *services.JanusService
+	estopService   *services.EmergencyStopService
+	actionRegistry *services.ActionRegistry
+	safetyService  *services.SafetyService
+	geofence       *services.GeofenceService
+	policy         *services.MessagePolicy
+	commandQueue   *services.CommandQueueService
+	missionService *services.MissionService
+	signaling      *services.SignalingService
+	latency        *services.LatencyMonitor
+	hub            *services.EventHub
+	protocol       *services.ProtocolNegotiator
+}
+
+// 连接写入器
+type connWriter struct {
+	mutex   sync.Mutex
+	codec   wsCodec                 // 子协议协商的编解码器
+	adapter services.MessageAdapter // 协商版本的消息适配器，注册前为空
 }
 
-func NewWebSocketHandlers(robotService *services.RobotService, gameService *services.GameService) *WebSocketHandlers {
+func NewWebSocketHandlers(robotService *services.RobotService, gameService *services.GameService, janusService *services.JanusService, estopService *services.EmergencyStopService, actionRegistry *services.ActionRegistry, safetyService *services.SafetyService, geofence *services.GeofenceService, protocol *services.ProtocolNegotiator, policy *services.MessagePolicy, commandQueue *services.CommandQueueService, missionService *services.MissionService, signaling *services.SignalingService, latency *services.LatencyMonitor, hub *services.EventHub) *WebSocketHandlers {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &WebSocketHandlers{
+	h := &WebSocketHandlers{
 		upgrader: websocket.Upgrader{
+			Subprotocols: wsSubprotocols,
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
@@ -53,18 +83,167 @@ func NewWebSocketHandlers(robotService *services.RobotService, gameService *serv
 		RobotStatus:    make(map[string]models.RobotState),
 		Operator2Robot: make(map[string]string),
 		Robot2Operator: make(map[string]string),
+		subscriptions:  make(map[*websocket.Conn]*services.Subscription),
+		writers:        make(map[*websocket.Conn]*connWriter),
 		ctx:            ctx,
 		cancel:         cancel,
 		robotService:   robotService,
 		gameService:    gameService,
+		janusService:   janusService,
+		estopService:   estopService,
+		actionRegistry: actionRegistry,
+		safetyService:  safetyService,
+		geofence:       geofence,
+		policy:         policy,
+		commandQueue:   commandQueue,
+		missionService: missionService,
+		signaling:      signaling,
+		latency:        latency,
+		hub:            hub,
+		protocol:       protocol,
+	}
+	missionService.SetExecutor(h)
+	return h
+}
+
+// 获取连接写入器
+func (h *WebSocketHandlers) writer(conn *websocket.Conn) *connWriter {
+	h.writerMutex.Lock()
+	defer h.writerMutex.Unlock()
+	writer, exists := h.writers[conn]
+	if !exists {
+		writer = &connWriter{}
+		h.writers[conn] = writer
+	}
+	return writer
+}
+
+// 串行写入WebSocket消息，按连接协商的协议版本和编码转换
+func (h *WebSocketHandlers) writeMessage(conn *websocket.Conn, v interface{}) error {
+	return h.writeMessageTimeout(conn, v, 0)
+}
+
+// 带写超时写入消息，timeout为0表示不限制。超时后连接不可再写，需关闭由对端重连
+func (h *WebSocketHandlers) writeMessageTimeout(conn *websocket.Conn, v interface{}, timeout time.Duration) error {
+	writer := h.writer(conn)
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if msg, ok := v.(models.WebSocketMessage); ok && writer.adapter != nil {
+		if !writer.adapter.Outbound(&msg) {
+			log.Debug().
+				Str("command", string(msg.Command)).
+				Str("remote_addr", conn.RemoteAddr().String()).
+				Msg("Message not supported by client protocol version, dropped")
+			return nil
+		}
+		v = msg
+	}
+
+	codec := writer.codec
+	if codec == nil {
+		codec = jsonCodec{}
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return conn.WriteMessage(codec.FrameType(), data)
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	err = conn.WriteMessage(codec.FrameType(), data)
+	conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+	}
+	return err
+}
+
+// 读取并解码一条消息，data保留为延迟解码的 models.Payload
+func (h *WebSocketHandlers) readMessage(conn *websocket.Conn) (models.WebSocketMessage, error) {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return models.WebSocketMessage{}, err
+	}
+	msg, err := decodeMessage(h.codec(conn), data)
+	if err != nil {
+		return msg, models.Errorf(models.ErrorCodeInvalidMessage, "malformed message: %v", err)
 	}
+	return msg, nil
+}
+
+// 获取连接的编解码器
+func (h *WebSocketHandlers) codec(conn *websocket.Conn) wsCodec {
+	writer := h.writer(conn)
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.codec == nil {
+		return jsonCodec{}
+	}
+	return writer.codec
+}
+
+// 设置连接的消息适配器
+func (h *WebSocketHandlers) setAdapter(conn *websocket.Conn, adapter services.MessageAdapter) {
+	writer := h.writer(conn)
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.adapter = adapter
+}
+
+// 获取连接的消息适配器
+func (h *WebSocketHandlers) adapter(conn *websocket.Conn) services.MessageAdapter {
+	writer := h.writer(conn)
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.adapter
 }
 
-func (h *WebSocketHandlers) sendResponseError(conn *websocket.Conn, msg *models.WebSocketMessage, message string) {
+// 释放连接写入器
+func (h *WebSocketHandlers) releaseWriter(conn *websocket.Conn) {
+	h.writerMutex.Lock()
+	defer h.writerMutex.Unlock()
+	delete(h.writers, conn)
+}
+
+// 投递事件中心中发往该客户端的消息
+func (h *WebSocketHandlers) deliverEvents(conn *websocket.Conn, sub *services.Subscription) {
+	for event := range sub.C() {
+		if event.Type != models.EventTypeMessage {
+			continue
+		}
+		if err := h.writeMessage(conn, event.Data); err != nil {
+			log.Error().Err(err).Str("ucode", event.UCode).Msg("Failed to deliver event")
+		}
+	}
+}
+
+// 发布客户端相关事件
+func (h *WebSocketHandlers) publish(eventType models.EventType, ucode string, data interface{}) {
+	if h.hub == nil {
+		return
+	}
+	h.hub.Publish(services.TopicFleet, models.Event{
+		Type:  eventType,
+		UCode: ucode,
+		Data:  data,
+	})
+}
+
+func (h *WebSocketHandlers) sendResponseError(conn *websocket.Conn, msg *models.WebSocketMessage, code models.ErrorCode, message string) {
+	h.sendResponseErrorData(conn, msg, code, message, nil)
+}
+
+func (h *WebSocketHandlers) sendResponseErrorData(conn *websocket.Conn, msg *models.WebSocketMessage, code models.ErrorCode, message string, data interface{}) {
 	cmdResponse := models.CMD_RESPONSE{
 		Success:   false,
+		Code:      code,
 		Message:   message,
 		Timestamp: time.Now().UnixMilli(),
+		Data:      data,
 	}
 
 	response := models.WebSocketMessage{
@@ -74,17 +253,18 @@ func (h *WebSocketHandlers) sendResponseError(conn *websocket.Conn, msg *models.
 		Data:     cmdResponse,
 	}
 
-	if err := conn.WriteJSON(response); err != nil {
+	if err := h.writeMessage(conn, response); err != nil {
 		log.Error().Err(err).Msg("Failed to send register error")
 	}
 }
 
-func (h *WebSocketHandlers) sendResponse(conn *websocket.Conn, msg *models.WebSocketMessage, message string) {
+func (h *WebSocketHandlers) sendResponse(conn *websocket.Conn, msg *models.WebSocketMessage, message string, data interface{}) {
 
 	cmdResponse := models.CMD_RESPONSE{
 		Success:   true,
 		Message:   message,
 		Timestamp: time.Now().UnixMilli(),
+		Data:      data,
 	}
 
 	response := models.WebSocketMessage{
@@ -94,7 +274,7 @@ func (h *WebSocketHandlers) sendResponse(conn *websocket.Conn, msg *models.WebSo
 		Data:     cmdResponse,
 	}
 
-	if err := conn.WriteJSON(response); err != nil {
+	if err := h.writeMessage(conn, response); err != nil {
 		log.Error().Err(err).Msg("Failed to send success message")
 	}
 }
@@ -103,38 +283,51 @@ func (h *WebSocketHandlers) GetClientByUcode(ucode string) *models.Client {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 	if conn, exists := h.Ucode2Conn[ucode]; exists {
-		return h.Conn2Client[conn]
+		if client, exists := h.Conn2Client[conn]; exists {
+			// 返回副本，LastSeen、Latency 等字段在锁内更新
+			copied := *client
+			return &copied
+		}
 	}
 	return nil
 }
 
+// BoundOperator 获取绑定该机器人的操作者UCode，未绑定返回空
+func (h *WebSocketHandlers) BoundOperator(robotUcode string) string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.Robot2Operator[robotUcode]
+}
+
 func (h *WebSocketHandlers) checkWSMessage(msg models.WebSocketMessage) error {
 	// 检查消息类型
 	if msg.Type != models.WSMessageTypeRequest {
-		return errors.New("invalid message type")
+		return models.NewError(models.ErrorCodeInvalidMessage, "invalid message type")
 	}
 
 	// 获取UCode
 	if msg.UCode == "" {
-		return errors.New("invalid ucode")
+		return models.NewError(models.ErrorCodeInvalidMessage, "invalid ucode")
 	}
 
 	// 获取客户端类型
 	if msg.ClientType == "" {
-		return errors.New("invalid client type")
+		return models.NewError(models.ErrorCodeInvalidMessage, "invalid client type")
 	}
 
 	// 验证客户端类型
-	if msg.ClientType != models.ClientTypeRobot && msg.ClientType != models.ClientTypeOperator {
-		return errors.New("invalid client type")
+	switch msg.ClientType {
+	case models.ClientTypeRobot, models.ClientTypeOperator, models.ClientTypeAdmin, models.ClientTypeReferee:
+	default:
+		return models.NewError(models.ErrorCodeInvalidMessage, "invalid client type")
 	}
 
 	if msg.Sequence == 0 {
-		return errors.New("invalid sequence")
+		return models.NewError(models.ErrorCodeInvalidMessage, "invalid sequence")
 	}
 
 	if msg.Version == "" {
-		return errors.New("invalid version")
+		return models.NewError(models.ErrorCodeInvalidMessage, "invalid version")
 	}
 	return nil
 }
@@ -149,102 +342,226 @@ func (h *WebSocketHandlers) HandleWebSocket(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// 按子协议选择编码
+	h.writer(conn).codec = codecFor(conn.Subprotocol())
+
 	// 设置连接参数
 	conn.SetReadLimit(512 * 1024)                          // 512KB 读取限制
 	conn.SetReadDeadline(time.Now().Add(30 * time.Second)) // 30秒读取超时
-	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		return nil
+	conn.SetPongHandler(func(appData string) error {
+		return h.handlePong(conn, appData)
 	})
 
 	// 注册：第一条消息必须为register
-	var msg models.WebSocketMessage
-	if err := conn.ReadJSON(&msg); err != nil {
-		h.sendResponseError(conn, &msg, "Failed to parse registration message")
+	msg, err := h.readMessage(conn)
+	if err != nil {
+		h.sendResponseError(conn, &msg, models.ErrorCodeInvalidMessage, "Failed to parse registration message")
+		h.releaseWriter(conn)
 		conn.Close()
 		return
 	}
 	if msg.Command != models.CMD_TYPE_REGISTER {
-		h.sendResponseError(conn, &msg, "Invalid message type")
+		h.sendResponseError(conn, &msg, models.ErrorCodeNotRegistered, "Invalid message type")
+		h.releaseWriter(conn)
 		conn.Close()
 		return
 	}
 
 	err = h.checkWSMessage(msg)
 	if err != nil {
-		h.sendResponseError(conn, &msg, err.Error())
+		h.sendResponseError(conn, &msg, models.ErrorCodeOf(err), err.Error())
+		h.releaseWriter(conn)
 		conn.Close()
 		return
 	}
-	if h.handleRegistration(conn, &msg) {
-		// 使用 goroutine 异步处理消息
-		go h.handleMessagesWithTimeout(conn)
+	if !h.handleRegistration(conn, &msg) {
+		h.releaseWriter(conn)
+		conn.Close()
+		return
 	}
+
+	// 使用 goroutine 异步处理消息
+	go h.handleMessagesWithTimeout(conn, true)
+}
+
+// ServeRobot 接入服务端主动连接的上游机器人，按配置注册成功后调用registered并处理其消息，连接断开后返回
+func (h *WebSocketHandlers) ServeRobot(conn *websocket.Conn, config models.RobotConfig, registered func()) error {
+	h.writer(conn).codec = codecFor(conn.Subprotocol())
+
+	// 上游机器人不发送注册消息，按当前协议版本注册
+	protocolVersion := models.ProtocolVersionCurrent
+	h.setAdapter(conn, h.protocol.Adapter(protocolVersion))
+	msg := models.WebSocketMessage{
+		Type:       models.WSMessageTypeRequest,
+		Command:    models.CMD_TYPE_REGISTER,
+		UCode:      config.UCode,
+		ClientType: models.ClientTypeRobot,
+		Version:    protocolVersion,
+	}
+	register := models.CMD_REGISTER{
+		Name:         config.Name,
+		Capabilities: config.Capabilities,
+	}
+	if err := h.registerClient(conn, &msg, register, protocolVersion); err != nil {
+		h.releaseWriter(conn)
+		return err
+	}
+	registered()
+
+	log.Info().
+		Str("ucode", config.UCode).
+		Str("url", config.WebSocketURL).
+		Interface("capabilities", config.Capabilities).
+		Msg("Upstream robot registered")
+
+	// 心跳由桥接发送，这里只记录延迟
+	conn.SetReadLimit(512 * 1024)
+	bridgePong := conn.PongHandler()
+	conn.SetPongHandler(func(appData string) error {
+		h.handlePong(conn, appData)
+		return bridgePong(appData)
+	})
+
+	h.handleMessagesWithTimeout(conn, false)
+	return nil
+}
+
+// 处理pong，pong回显ping中的发送时间，用于测量RTT
+func (h *WebSocketHandlers) handlePong(conn *websocket.Conn, appData string) error {
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if len(appData) == 8 {
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64([]byte(appData))))
+		h.recordLatency(conn, time.Since(sent), false)
+	}
+	return nil
 }
 
 // 注册 - 返回是否成功
 func (h *WebSocketHandlers) handleRegistration(conn *websocket.Conn, msg *models.WebSocketMessage) bool {
-	// 检查UCode是否已被使用
-	h.mutex.Lock()
-	if _, exists := h.Ucode2Conn[msg.UCode]; exists {
-		h.mutex.Unlock()
-		h.sendResponseError(conn, msg, "Robot UCODE already in use")
+	// 协商协议版本
+	protocolVersion, protocolErr := h.protocol.Negotiate(msg.Version)
+	if protocolErr != nil {
+		log.Warn().
+			Str("ucode", msg.UCode).
+			Str("version", msg.Version).
+			Str("code", string(protocolErr.Code)).
+			Msg("Protocol version rejected")
+		h.sendResponseErrorData(conn, msg, protocolErr.Code, protocolErr.Message, protocolErr)
 		return false
 	}
+	adapter := h.protocol.Adapter(protocolVersion)
+	adapter.Inbound(msg)
+	h.setAdapter(conn, adapter)
 
-	// 创建Client连接信息
-	client := &models.Client{
-		UCode:      msg.UCode,
-		ClientType: msg.ClientType,
-		Version:    msg.Version,
-		Connected:  true,
-		LastSeen:   time.Now(),
-		RemoteAddr: conn.RemoteAddr().String(),
+	// 解析能力清单
+	var register models.CMD_REGISTER
+	if payload, ok := msg.Data.(models.Payload); ok && !payload.Empty() {
+		if err := payload.Decode(&register); err != nil {
+			h.sendResponseError(conn, msg, models.ErrorCodeInvalidPayload, "Invalid registration data: "+err.Error())
+			return false
+		}
 	}
 
-	// 绑定连接
-	h.Ucode2Conn[msg.UCode] = conn
-	h.Conn2Client[conn] = client
-	h.mutex.Unlock()
+	if err := h.registerClient(conn, msg, register, protocolVersion); err != nil {
+		h.sendResponseError(conn, msg, models.ErrorCodeOf(err), err.Error())
+		return false
+	}
 
 	log.Info().
 		Str("ucode", msg.UCode).
 		Str("client_type", string(msg.ClientType)).
 		Str("sequence", strconv.FormatInt(msg.Sequence, 10)).
 		Str("version", msg.Version).
+		Str("protocol_version", protocolVersion).
 		Str("remote_addr", conn.RemoteAddr().String()).
+		Interface("capabilities", register.Capabilities).
 		Msg("Robot registered successfully")
 
 	// 发送注册成功响应
-	h.sendResponse(conn, msg, fmt.Sprintf("Successfully registered with UCODE %s", msg.UCode))
+	h.sendResponse(conn, msg, fmt.Sprintf("Successfully registered with UCODE %s", msg.UCode), models.CMD_REGISTER_RESPONSE{
+		ProtocolVersion: protocolVersion,
+		Supported:       h.protocol.Range(),
+	})
 	return true
 }
 
-// 消息处理循环 - 带超时和心跳
-func (h *WebSocketHandlers) handleMessagesWithTimeout(conn *websocket.Conn) {
+// 创建客户端并绑定连接、订阅私有主题，机器人还会打开命令队列并创建视频流
+func (h *WebSocketHandlers) registerClient(conn *websocket.Conn, msg *models.WebSocketMessage, register models.CMD_REGISTER, protocolVersion string) error {
+	// 检查UCode是否已被使用
+	h.mutex.Lock()
+	if _, exists := h.Ucode2Conn[msg.UCode]; exists {
+		h.mutex.Unlock()
+		return models.NewError(models.ErrorCodeUCodeInUse, "Robot UCODE already in use")
+	}
+
+	// 创建Client连接信息
+	client := &models.Client{
+		UCode:        msg.UCode,
+		Name:         register.Name,
+		ClientType:   msg.ClientType,
+		Version:      msg.Version,
+		Connected:    true,
+		LastSeen:     time.Now(),
+		RemoteAddr:   conn.RemoteAddr().String(),
+		Capabilities: register.Capabilities,
+
+		ProtocolVersion: protocolVersion,
+	}
+
+	// 绑定连接
+	h.Ucode2Conn[msg.UCode] = conn
+	h.Conn2Client[conn] = client
+
+	// 订阅客户端私有主题
+	if h.hub != nil {
+		sub := h.hub.Subscribe(services.ClientTopic(msg.UCode))
+		h.subscriptions[conn] = sub
+		go h.deliverEvents(conn, sub)
+	}
+	h.mutex.Unlock()
+
+	if client.ClientType == models.ClientTypeRobot {
+		h.commandQueue.Open(client.UCode, func(command models.QueuedCommand) error {
+			return h.deliverControl(conn, client.UCode, command)
+		})
+		h.publish(models.EventTypeRobotConnected, client.UCode, *client)
+		if client.Capabilities.HasVideo() {
+			go h.provisionStream(client.UCode, client.Capabilities.VideoSources)
+		}
+	} else {
+		h.publish(models.EventTypeOperatorConnected, client.UCode, *client)
+	}
+	return nil
+}
+
+// 消息处理循环 - 带超时和心跳，heartbeat为false时由调用方负责发送ping
+func (h *WebSocketHandlers) handleMessagesWithTimeout(conn *websocket.Conn, heartbeat bool) {
 	defer func() {
 		h.cleanupConnection(conn)
 		conn.Close()
 	}()
 
-	// 启动心跳 goroutine
-	heartbeatTicker := time.NewTicker(25 * time.Second) // 25秒发送一次ping
-	defer heartbeatTicker.Stop()
-
-	// 启动心跳处理
-	go func() {
-		for {
-			select {
-			case <-heartbeatTicker.C:
-				if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second)); err != nil {
-					log.Error().Err(err).Msg("Failed to send ping")
+	// 启动心跳 goroutine，ping携带发送时间用于测量RTT
+	if heartbeat {
+		heartbeatTicker := time.NewTicker(h.latency.PingInterval())
+		defer heartbeatTicker.Stop()
+
+		go func() {
+			timestamp := make([]byte, 8)
+			for {
+				select {
+				case <-heartbeatTicker.C:
+					binary.BigEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()))
+					if err := conn.WriteControl(websocket.PingMessage, timestamp, time.Now().Add(10*time.Second)); err != nil {
+						log.Error().Err(err).Msg("Failed to send ping")
+						return
+					}
+				case <-h.ctx.Done():
 					return
 				}
-			case <-h.ctx.Done():
-				return
 			}
-		}
-	}()
+		}()
+	}
 
 	// 主消息处理循环
 	for {
@@ -255,8 +572,15 @@ func (h *WebSocketHandlers) handleMessagesWithTimeout(conn *websocket.Conn) {
 			// 设置读取超时
 			conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 
-			var msg models.WebSocketMessage
-			if err := conn.ReadJSON(&msg); err != nil {
+			msg, err := h.readMessage(conn)
+			if err != nil {
+				// 消息格式错误不影响连接本身
+				if models.ErrorCodeOf(err) == models.ErrorCodeInvalidMessage {
+					if h.handleViolation(conn, &msg, models.ViolationMalformed, err) {
+						return
+					}
+					continue
+				}
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					log.Error().Err(err).Msg("WebSocket read error")
 
@@ -269,17 +593,66 @@ func (h *WebSocketHandlers) handleMessagesWithTimeout(conn *websocket.Conn) {
 			// 重置读取超时
 			conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 
+			// 按协商版本升级消息格式
+			if adapter := h.adapter(conn); adapter != nil {
+				adapter.Inbound(&msg)
+			}
+
 			if err := h.checkWSMessage(msg); err != nil {
-				h.sendResponseError(conn, &msg, err.Error())
-				conn.Close()
+				if h.handleViolation(conn, &msg, models.ViolationMalformed, err) {
+					return
+				}
 				continue
 			}
+
+			// 限速
+			if err := h.policy.Allow(h.connUcode(conn), msg.Command); err != nil {
+				if h.handleViolation(conn, &msg, models.ViolationRateLimited, err) {
+					return
+				}
+				continue
+			}
+
 			// 处理消息
 			h.handleMessage(conn, &msg)
 		}
 	}
 }
 
+// 连接已注册的UCode
+func (h *WebSocketHandlers) connUcode(conn *websocket.Conn) string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if client, exists := h.Conn2Client[conn]; exists {
+		return client.UCode
+	}
+	return ""
+}
+
+// 处理违规消息：回复错误，按策略决定是否断开，返回是否已断开
+func (h *WebSocketHandlers) handleViolation(conn *websocket.Conn, msg *models.WebSocketMessage, kind string, err error) bool {
+	ucode := h.connUcode(conn)
+	code := models.ErrorCodeOf(err)
+	disconnect := h.policy.RecordViolation(ucode, msg.Command, kind, err.Error())
+
+	log.Warn().
+		Err(err).
+		Str("ucode", ucode).
+		Str("kind", kind).
+		Str("code", string(code)).
+		Str("command", string(msg.Command)).
+		Bool("disconnect", disconnect).
+		Msg("WebSocket message violation")
+
+	h.sendResponseError(conn, msg, code, err.Error())
+	if disconnect {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many violations"),
+			time.Now().Add(time.Second))
+	}
+	return disconnect
+}
+
 // 连接清理
 func (h *WebSocketHandlers) cleanupConnection(conn *websocket.Conn) {
 	h.mutex.Lock()
@@ -287,15 +660,57 @@ func (h *WebSocketHandlers) cleanupConnection(conn *websocket.Conn) {
 
 	if client, ok := h.Conn2Client[conn]; ok {
 		// 清理游戏连接
-		if h.gameService != nil {
-			h.gameService.RemoveRobotConnection(conn)
+		if h.gameService != nil && client.ClientType == models.ClientTypeRobot {
+			h.gameService.RemoveRobot(client.UCode)
+		}
+		h.policy.Remove(client.UCode)
+		h.latency.Remove(client.UCode)
+		if client.ClientType != models.ClientTypeRobot {
+			go h.signaling.StopAll(client.UCode)
+		}
+		if client.ClientType == models.ClientTypeRobot {
+			go h.deactivateStream(client.UCode)
+			h.missionService.Abort(client.UCode, "robot disconnected")
+			h.commandQueue.Close(client.UCode)
+			h.safetyService.Reset(client.UCode)
+			h.geofence.Remove(client.UCode)
+		}
+
+		// 取消事件订阅
+		if sub, exists := h.subscriptions[conn]; exists {
+			h.hub.Unsubscribe(sub)
+			delete(h.subscriptions, conn)
+		}
+
+		// 解除绑定关系
+		if robotUcode, exists := h.Operator2Robot[client.UCode]; exists {
+			delete(h.Robot2Operator, robotUcode)
+			delete(h.Operator2Robot, client.UCode)
+			h.publish(models.EventTypeRobotUnbound, robotUcode, models.BindingChange{
+				OperatorUCode: client.UCode,
+				RobotUCode:    robotUcode,
+			})
+		}
+		if operatorUcode, exists := h.Robot2Operator[client.UCode]; exists {
+			delete(h.Operator2Robot, operatorUcode)
+			delete(h.Robot2Operator, client.UCode)
+			h.publish(models.EventTypeRobotUnbound, client.UCode, models.BindingChange{
+				OperatorUCode: operatorUcode,
+				RobotUCode:    client.UCode,
+			})
 		}
 
 		delete(h.Ucode2Conn, client.UCode)
 		delete(h.Conn2Client, conn)
-		delete(h.Operator2Robot, client.UCode)
-		delete(h.Robot2Operator, client.UCode)
 		delete(h.RobotStatus, client.UCode)
+		h.releaseWriter(conn)
+
+		client.Connected = false
+		if client.ClientType == models.ClientTypeRobot {
+			h.publish(models.EventTypeRobotDisconnected, client.UCode, *client)
+		} else {
+			h.publish(models.EventTypeOperatorDisconnected, client.UCode, *client)
+		}
 
 		log.Info().
 			Str("ucode", client.UCode).
@@ -324,60 +739,81 @@ func (h *WebSocketHandlers) Shutdown() {
 		conn.Close()
 	}
 	log.Info().Msg("All connections closed")
+
+	// 取消所有事件订阅
+	for _, sub := range h.subscriptions {
+		h.hub.Unsubscribe(sub)
+	}
+
 	// 清空映射
 	h.Conn2Client = make(map[*websocket.Conn]*models.Client)
 	h.Ucode2Conn = make(map[string]*websocket.Conn)
 	h.RobotStatus = make(map[string]models.RobotState)
 	h.Operator2Robot = make(map[string]string)
 	h.Robot2Operator = make(map[string]string)
+	h.subscriptions = make(map[*websocket.Conn]*services.Subscription)
 }
 
 // 处理WebSocket消息
 func (h *WebSocketHandlers) handleMessage(conn *websocket.Conn, msg *models.WebSocketMessage) {
-	// 解析命令数据
-	data, ok := msg.Data.(map[string]interface{})
-	if !ok {
-		h.sendResponseError(conn, msg, "Command data must be an object")
-		return
-	}
-
-	// 转换为JSON
-	dataJSON, err := json.Marshal(data)
-	if err != nil {
-		h.sendResponseError(conn, msg, "Failed to serialize command")
+	// 命令数据延迟到具体处理函数中解码
+	data, ok := msg.Data.(models.Payload)
+	if !ok || data.Empty() {
+		h.sendResponseError(conn, msg, models.ErrorCodeInvalidPayload, "Command data must be an object")
 		return
 	}
 
-	err = errors.New("Unknown message type: " + string(msg.Command))
+	var result interface{}
+	var err error = models.NewError(models.ErrorCodeUnknownCommand, "Unknown message type: "+string(msg.Command))
 	switch msg.Command {
 	case models.CMD_TYPE_BIND_ROBOT:
-		err = h.handleBindRobot(conn, dataJSON)
+		result, err = h.handleBindRobot(conn, data)
 	case models.CMD_TYPE_CONTROL_ROBOT:
-		err = h.handleControlRobot(conn, dataJSON)
+		result, err = h.handleControlRobot(conn, data)
 	case models.CMD_TYPE_UPDATE_ROBOT_STATUS:
-		err = h.handleUpdateRobotStatus(conn, dataJSON)
+		err = h.handleUpdateRobotStatus(conn, data)
 	case models.CMD_TYPE_PING:
-		err = h.handlePing(conn, dataJSON)
+		result, err = h.handlePing(conn, data)
+	case models.CMD_TYPE_EMERGENCY_STOP:
+		err = h.handleEmergencyStop(conn, data)
+	case models.CMD_TYPE_CLEAR_EMERGENCY:
+		err = h.handleClearEmergency(conn, data)
+	// 任务相关命令
+	case models.CMD_TYPE_MISSION_START,
+		models.CMD_TYPE_MISSION_PAUSE,
+		models.CMD_TYPE_MISSION_RESUME,
+		models.CMD_TYPE_MISSION_ABORT,
+		models.CMD_TYPE_MISSION_STATUS:
+		result, err = h.handleMission(conn, msg.Command, data)
+	// WebRTC信令
+	case models.CMD_TYPE_WEBRTC_WATCH,
+		models.CMD_TYPE_WEBRTC_ANSWER,
+		models.CMD_TYPE_WEBRTC_ICE,
+		models.CMD_TYPE_WEBRTC_STOP:
+		result, err = h.handleWebRTC(conn, msg.Command, data)
 	// 游戏相关命令
 	case models.CMD_TYPE_JOIN_GAME:
-		err = h.handleJoinGame(conn, dataJSON)
+		err = h.handleJoinGame(conn, data)
 	case models.CMD_TYPE_LEAVE_GAME:
-		err = h.handleLeaveGame(conn, dataJSON)
+		err = h.handleLeaveGame(conn, data)
 	case models.CMD_TYPE_GAME_SHOOT:
-		err = h.handleGameShoot(conn, dataJSON)
+		err = h.handleGameShoot(conn, data)
 	case models.CMD_TYPE_GAME_MOVE:
-		err = h.handleGameMove(conn, dataJSON)
+		err = h.handleGameMove(conn, data)
 	case models.CMD_TYPE_GAME_STATUS:
-		err = h.handleGameStatus(conn, dataJSON)
+		err = h.handleGameStatus(conn, data)
 	case models.CMD_TYPE_GAME_START:
-		err = h.handleGameStart(conn, dataJSON)
+		result, err = h.handleGameStart(conn, data)
 	case models.CMD_TYPE_GAME_STOP:
-		err = h.handleGameStop(conn, dataJSON)
+		err = h.handleGameStop(conn, data)
 	}
 
 	if err != nil {
-		h.sendResponseError(conn, msg, err.Error())
+		code := models.ErrorCodeOf(err)
+		// 部分错误附带数据，例如安全包络拒绝时返回生效的限制
+		h.sendResponseErrorData(conn, msg, code, err.Error(), result)
 		log.Error().
+			Str("code", string(code)).
 			Str("client", conn.RemoteAddr().String()).
 			Str("command", string(msg.Command)).
 			Str("ucode", msg.UCode).
@@ -386,7 +822,7 @@ func (h *WebSocketHandlers) handleMessage(conn *websocket.Conn, msg *models.WebS
 			Str("sequence", strconv.FormatInt(msg.Sequence, 10)).
 			Msg("WebSocket message handled : Failed, " + err.Error())
 	} else {
-		h.sendResponse(conn, msg, "Success")
+		h.sendResponse(conn, msg, "Success", result)
 
 		log.Debug().
 			Str("client", conn.RemoteAddr().String()).
@@ -395,135 +831,777 @@ func (h *WebSocketHandlers) handleMessage(conn *websocket.Conn, msg *models.WebS
 			Str("client_type", string(msg.ClientType)).
 			Str("version", msg.Version).
 			Str("sequence", strconv.FormatInt(msg.Sequence, 10)).
-			Str("data", string(dataJSON)).
+			Str("data", fmt.Sprint(data)).
 			Msg("WebSocket message handled : Success")
 	}
 }
 
-// 处理绑定机器人
-func (h *WebSocketHandlers) handleBindRobot(conn *websocket.Conn, dataJSON []byte) error {
+// 处理绑定机器人，返回机器人的视频播放信息
+func (h *WebSocketHandlers) handleBindRobot(conn *websocket.Conn, payload models.Payload) (interface{}, error) {
 	// 获取操作者信息
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return nil, models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	if client.ClientType != models.ClientTypeOperator {
-		return errors.New("client is not an operator")
+		return nil, models.NewError(models.ErrorCodeUnauthorized, "client is not an operator")
 	}
 
 	var data models.CMD_BIND_ROBOT
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	// 绑定机器人
 	clientRobot := h.GetClientByUcode(data.UCode)
 	if clientRobot == nil || clientRobot.ClientType != models.ClientTypeRobot {
-		return errors.New("target robot not connected")
+		return nil, models.NewError(models.ErrorCodeRobotOffline, "target robot not connected")
 	}
 
-	if _, exists := h.Robot2Operator[client.UCode]; exists {
-		return errors.New("robot already bound to another operator")
+	h.mutex.Lock()
+	if _, exists := h.Robot2Operator[clientRobot.UCode]; exists {
+		h.mutex.Unlock()
+		return nil, models.NewError(models.ErrorCodeAlreadyBound, "robot already bound to another operator")
 	}
 	if _, exists := h.Operator2Robot[client.UCode]; exists {
-		return errors.New("operator already bound to another robot")
+		h.mutex.Unlock()
+		return nil, models.NewError(models.ErrorCodeAlreadyBound, "operator already bound to another robot")
 	}
 
 	// 绑定成功
 	h.Operator2Robot[client.UCode] = clientRobot.UCode
 	h.Robot2Operator[clientRobot.UCode] = client.UCode
+	h.mutex.Unlock()
 
-	return nil
+	h.publish(models.EventTypeRobotBound, clientRobot.UCode, models.BindingChange{
+		OperatorUCode: client.UCode,
+		RobotUCode:    clientRobot.UCode,
+	})
+
+	// 返回视频播放信息，操作者可直接发起观看
+	return h.playInfo(clientRobot.UCode), nil
 }
 
-// 处理控制命令
-func (h *WebSocketHandlers) handleControlRobot(conn *websocket.Conn, dataJSON []byte) error {
+// 处理控制命令，返回本次生效的安全限制
+func (h *WebSocketHandlers) handleControlRobot(conn *websocket.Conn, payload models.Payload) (interface{}, error) {
 	// 获取操作者信息
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
+	robotUcode := ""
+	if exists {
+		robotUcode = h.Operator2Robot[client.UCode]
+	}
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return nil, models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
-	robotUcode := h.Operator2Robot[client.UCode]
 	if robotUcode == "" {
-		return errors.New("robot not bound to operator")
+		return nil, models.NewError(models.ErrorCodeNotBound, "robot not bound to operator")
+	}
+
+	var data models.CMD_CONTROL_ROBOT
+	if err := payload.Decode(&data); err != nil {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
-	// 获取机器人连接
+	if err := h.CheckMissionOverride(robotUcode, &data); err != nil {
+		return nil, err
+	}
+
+	limits, err := h.ExecuteControl(robotUcode, client, data)
+	if limits == nil {
+		return nil, err
+	}
+	return limits, err
+}
+
+// ExecuteControl 依次检查急停、动作、电子围栏和安全包络后将命令放入下发队列
+func (h *WebSocketHandlers) ExecuteControl(robotUcode string, from *models.Client, command models.CMD_CONTROL_ROBOT) (*models.SafetyLimits, error) {
+	if h.estopService.IsLatched(robotUcode) {
+		return nil, models.NewError(models.ErrorCodeEmergencyStop, "robot is in emergency_stop state")
+	}
+
+	if err := h.ValidateControl(robotUcode, &command); err != nil {
+		return nil, err
+	}
+
+	if err := h.geofence.CheckMove(robotUcode, &command); err != nil {
+		return nil, err
+	}
+
+	limits, err := h.ApplySafetyEnvelope(robotUcode, &command)
+	if err != nil {
+		return limits, err
+	}
+
+	if err := h.SendControlToRobot(robotUcode, from, command); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// CheckMissionOverride 任务执行中只接受手动停止命令，停止命令同时中止任务
+func (h *WebSocketHandlers) CheckMissionOverride(robotUcode string, command *models.CMD_CONTROL_ROBOT) error {
+	if !h.missionService.IsRunning(robotUcode) {
+		return nil
+	}
+	if command.Action == models.ControlActionStop {
+		h.missionService.Abort(robotUcode, "stopped manually")
+		return nil
+	}
+	return models.Errorf(models.ErrorCodeMissionState, "robot %s is running a mission, pause or abort it before manual control", robotUcode)
+}
+
+// GetRobotState 获取机器人最近上报的状态
+func (h *WebSocketHandlers) GetRobotState(robotUcode string) (models.RobotState, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	state, exists := h.RobotStatus[robotUcode]
+	return state, exists
+}
+
+// ValidateControl 按机器人型号和能力清单校验控制命令
+func (h *WebSocketHandlers) ValidateControl(robotUcode string, command *models.CMD_CONTROL_ROBOT) error {
+	if err := h.actionRegistry.Validate(h.robotModel(robotUcode), command); err != nil {
+		return err
+	}
+
+	// 停止命令始终允许
+	if command.Action == models.ControlActionStop {
+		return nil
+	}
+	if client := h.GetClientByUcode(robotUcode); client != nil && !client.Capabilities.SupportsAction(command.Action) {
+		return models.Errorf(models.ErrorCodeUnsupportedAction, "robot %s does not advertise action %q", robotUcode, command.Action)
+	}
+	return nil
+}
+
+// ApplySafetyEnvelope 按机器人当前状态对Move命令限速，裁剪模式下会修改命令参数
+func (h *WebSocketHandlers) ApplySafetyEnvelope(robotUcode string, command *models.CMD_CONTROL_ROBOT) (*models.SafetyLimits, error) {
 	h.mutex.RLock()
-	robotConn, exists := h.Ucode2Conn[robotUcode]
+	state, exists := h.RobotStatus[robotUcode]
 	h.mutex.RUnlock()
 
-	if !exists {
-		return errors.New("target robot not connected")
+	var statePtr *models.RobotState
+	if exists {
+		statePtr = &state
 	}
 
-	var data models.CMD_CONTROL_ROBOT
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	limits, err := h.safetyService.ApplyMove(robotUcode, statePtr, command)
+	if limits != nil && limits.Clamped {
+		log.Info().
+			Str("robot_ucode", robotUcode).
+			Bool("rejected", err != nil).
+			Float64("scale", limits.Scale).
+			Strs("reasons", limits.Reasons).
+			Interface("requested", limits.Requested).
+			Interface("applied", limits.Applied).
+			Msg("Move command limited by safety envelope")
+	}
+	return limits, err
+}
+
+// 获取机器人型号，未上报时使用默认型号
+func (h *WebSocketHandlers) robotModel(robotUcode string) string {
+	client := h.GetClientByUcode(robotUcode)
+	if client == nil || client.Capabilities == nil {
+		return ""
+	}
+	return client.Capabilities.Model
+}
+
+// 为有视频源的机器人自动注册WebRTC流
+func (h *WebSocketHandlers) provisionStream(robotUcode string, sources []models.VideoSource) {
+	if h.janusService == nil || !h.janusService.AutoRegister {
+		return
+	}
+	streams, failed := h.janusService.RegisterWebRTCSources(robotUcode, sources)
+	for source, err := range failed {
+		log.Error().Err(err).Str("ucode", robotUcode).Str("source", source).Msg("Failed to auto-register WebRTC stream")
+	}
+	for _, stream := range streams {
+		log.Info().Str("ucode", robotUcode).Str("source", stream.Source).Int("stream_id", stream.StreamID).Msg("WebRTC stream auto-registered")
+	}
+
+	// 注册期间机器人已断开时，断开处理可能早于流保存而未停用，这里补做
+	if len(streams) > 0 && h.GetClientByUcode(robotUcode) == nil {
+		log.Info().Str("ucode", robotUcode).Msg("Robot disconnected during stream registration, deactivating")
+		h.janusService.DeactivateStream(robotUcode)
 	}
+}
 
+// 机器人断开后停用视频流，挂载点超过宽限期由清理协程销毁；期间已重连则保留
+func (h *WebSocketHandlers) deactivateStream(robotUcode string) {
+	if h.janusService == nil || h.GetClientByUcode(robotUcode) != nil {
+		return
+	}
+	h.janusService.DeactivateStream(robotUcode)
+}
+
+// 机器人的视频播放信息，没有活跃视频流时为空
+func (h *WebSocketHandlers) playInfo(robotUcode string) models.CMD_BIND_ROBOT_RESPONSE {
+	info := models.CMD_BIND_ROBOT_RESPONSE{UCode: robotUcode}
+	if h.janusService == nil {
+		return info
+	}
+	sources, err := h.janusService.GetWebRTCSources(robotUcode)
+	if err != nil {
+		return info
+	}
+	info.Sources = sources
+	for _, source := range sources {
+		info.URLs = append(info.URLs, source.PlayURL)
+	}
+	return info
+}
+
+// SendControlToRobot 控制命令进入机器人的下发队列，from为空表示来自服务端
+func (h *WebSocketHandlers) SendControlToRobot(robotUcode string, from *models.Client, data models.CMD_CONTROL_ROBOT) error {
+	queued, err := h.commandQueue.Enqueue(robotUcode, from, data)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().
+		Str("ucode", robotUcode).
+		Int64("id", queued.ID).
+		Str("action", data.Action).
+		Str("priority", queued.Priority).
+		Int("coalesced", queued.Coalesced).
+		Msg("Control command queued")
+	return nil
+}
+
+// 由下发队列调用，实际发送命令到机器人
+func (h *WebSocketHandlers) deliverControl(robotConn *websocket.Conn, robotUcode string, command models.QueuedCommand) error {
 	// 创建命令消息
 	commandMessage := models.WebSocketMessage{
 		Type:       models.WSMessageTypeRequest,
 		Command:    models.CMD_TYPE_CONTROL_ROBOT,
-		Sequence:   1,
-		UCode:      client.UCode,
-		ClientType: client.ClientType,
-		Version:    client.Version,
-		Data:       data,
+		Sequence:   atomic.AddInt64(&h.sequence, 1),
+		UCode:      robotUcode,
+		ClientType: models.ClientTypeOperator,
+		Version:    models.ProtocolVersionCurrent,
+		Data:       command.Command,
+	}
+	if command.FromUCode != "" {
+		commandMessage.UCode = command.FromUCode
+		commandMessage.ClientType = command.FromType
 	}
 
-	// 发送命令到机器人
-	if err := robotConn.WriteJSON(commandMessage); err != nil {
-		return errors.New("failed to send command to robot: " + err.Error())
+	// 发送命令到机器人，写超时后断开连接，避免阻塞队列中的急停和停止命令
+	timeout := h.robotService.ControlTimeout(robotUcode)
+	if timeout <= 0 {
+		timeout = h.commandQueue.WriteTimeout()
+	}
+	err := h.writeMessageTimeout(robotConn, commandMessage, timeout)
+	h.robotService.RecordCommand(robotUcode, err)
+	if err != nil {
+		return models.NewError(models.ErrorCodeSendFailed, "failed to send command to robot: "+err.Error())
 	}
 	return nil
 }
 
+// EmergencyStop 急停并锁定机器人，ucodes为空时急停全部在线机器人
+func (h *WebSocketHandlers) EmergencyStop(ucodes []string, triggeredBy, reason string) models.EmergencyStopResult {
+	if len(ucodes) == 0 {
+		for _, robot := range h.GetAllRobotConnections() {
+			ucodes = append(ucodes, robot.UCode)
+		}
+	}
+
+	result := models.EmergencyStopResult{
+		Records: h.estopService.Trigger(ucodes, triggeredBy, reason),
+		Stopped: make([]string, 0, len(ucodes)),
+		Offline: make([]string, 0),
+		Failed:  make(map[string]string),
+	}
+
+	stop := models.CMD_CONTROL_ROBOT{
+		Action: models.ControlActionStop,
+		ParamMaps: map[string]string{
+			"reason": reason,
+		},
+		Priority:  models.CommandPriorityEmergency,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	for _, ucode := range ucodes {
+		// 急停后重新从静止开始计算加速度
+		h.safetyService.Reset(ucode)
+		h.missionService.Abort(ucode, "emergency stop")
+
+		client := h.GetClientByUcode(ucode)
+		if client == nil || client.ClientType != models.ClientTypeRobot {
+			result.Offline = append(result.Offline, ucode)
+			continue
+		}
+		if err := h.SendControlToRobot(ucode, nil, stop); err != nil {
+			result.Failed[ucode] = err.Error()
+			log.Error().Err(err).Str("ucode", ucode).Msg("Failed to send emergency stop")
+			continue
+		}
+		result.Stopped = append(result.Stopped, ucode)
+	}
+
+	return result
+}
+
+// ClearEmergencyStop 解除急停锁定，ucodes为空时解除全部
+func (h *WebSocketHandlers) ClearEmergencyStop(ucodes []string, clearedBy string) []models.EmergencyStopRecord {
+	return h.estopService.Clear(ucodes, clearedBy)
+}
+
+// 检查客户端是否有急停权限
+func canEmergencyStop(client *models.Client) bool {
+	return client.ClientType == models.ClientTypeAdmin || client.ClientType == models.ClientTypeReferee
+}
+
+// 处理急停
+func (h *WebSocketHandlers) handleEmergencyStop(conn *websocket.Conn, payload models.Payload) error {
+	h.mutex.RLock()
+	client, exists := h.Conn2Client[conn]
+	h.mutex.RUnlock()
+
+	if !exists {
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
+	}
+
+	if !canEmergencyStop(client) {
+		return models.NewError(models.ErrorCodeUnauthorized, "only admins and referees can trigger emergency stop")
+	}
+
+	var data models.CMD_EMERGENCY_STOP
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
+	}
+
+	result := h.EmergencyStop(data.UCodes, string(client.ClientType)+":"+client.UCode, data.Reason)
+	if len(result.Failed) > 0 {
+		return models.Errorf(models.ErrorCodeSendFailed, "emergency stop failed for %d robot(s)", len(result.Failed))
+	}
+	return nil
+}
+
+// 处理任务命令，操作者默认作用于已绑定的机器人，管理员需指定机器人
+func (h *WebSocketHandlers) handleMission(conn *websocket.Conn, command models.CommandType, payload models.Payload) (interface{}, error) {
+	h.mutex.RLock()
+	client, exists := h.Conn2Client[conn]
+	boundRobot := ""
+	if exists {
+		boundRobot = h.Operator2Robot[client.UCode]
+	}
+	h.mutex.RUnlock()
+
+	if !exists {
+		return nil, models.NewError(models.ErrorCodeNotRegistered, "client not found")
+	}
+
+	// 开始任务携带任务定义，其他命令只有UCode和原因
+	var start models.CMD_MISSION_START
+	var control models.CMD_MISSION_CONTROL
+	var err error
+	if command == models.CMD_TYPE_MISSION_START {
+		err = payload.Decode(&start)
+		control.UCode = start.UCode
+	} else {
+		err = payload.Decode(&control)
+	}
+	if err != nil {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
+	}
+
+	robotUcode := control.UCode
+	switch client.ClientType {
+	case models.ClientTypeAdmin:
+		if robotUcode == "" {
+			return nil, models.NewError(models.ErrorCodeInvalidPayload, "ucode is required")
+		}
+	case models.ClientTypeOperator:
+		if boundRobot == "" {
+			return nil, models.NewError(models.ErrorCodeNotBound, "robot not bound to operator")
+		}
+		if robotUcode != "" && robotUcode != boundRobot {
+			return nil, models.NewError(models.ErrorCodeUnauthorized, "operators can only run missions on their bound robot")
+		}
+		robotUcode = boundRobot
+	default:
+		return nil, models.NewError(models.ErrorCodeUnauthorized, "only operators and admins can manage missions")
+	}
+
+	switch command {
+	case models.CMD_TYPE_MISSION_START:
+		if h.GetClientByUcode(robotUcode) == nil {
+			return nil, models.NewError(models.ErrorCodeRobotOffline, "target robot not connected")
+		}
+		return h.missionService.Start(robotUcode, start.Mission, string(client.ClientType)+":"+client.UCode)
+	case models.CMD_TYPE_MISSION_PAUSE:
+		return h.missionService.Pause(robotUcode)
+	case models.CMD_TYPE_MISSION_RESUME:
+		return h.missionService.Resume(robotUcode)
+	case models.CMD_TYPE_MISSION_ABORT:
+		reason := control.Reason
+		if reason == "" {
+			reason = "aborted by " + string(client.ClientType) + ":" + client.UCode
+		}
+		return h.missionService.Abort(robotUcode, reason)
+	default:
+		status, exists := h.missionService.GetStatus(robotUcode)
+		if !exists {
+			return nil, models.Errorf(models.ErrorCodeMissionNotFound, "no mission for robot %s", robotUcode)
+		}
+		return status, nil
+	}
+}
+
+// 处理WebRTC信令，操作者只能观看绑定的机器人
+func (h *WebSocketHandlers) handleWebRTC(conn *websocket.Conn, command models.CommandType, payload models.Payload) (interface{}, error) {
+	h.mutex.RLock()
+	client, exists := h.Conn2Client[conn]
+	var boundRobot string
+	if exists {
+		boundRobot = h.Operator2Robot[client.UCode]
+	}
+	h.mutex.RUnlock()
+
+	if !exists {
+		return nil, models.NewError(models.ErrorCodeNotRegistered, "client not found")
+	}
+	if client.ClientType == models.ClientTypeRobot {
+		return nil, models.NewError(models.ErrorCodeUnauthorized, "robots cannot watch streams")
+	}
+
+	var target struct {
+		UCode  string `json:"ucode"`
+		Source string `json:"source"`
+	}
+	if err := payload.Decode(&target); err != nil {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
+	}
+	robotUcode := target.UCode
+	if client.ClientType == models.ClientTypeOperator {
+		if boundRobot == "" {
+			return nil, models.NewError(models.ErrorCodeNotBound, "robot not bound to operator")
+		}
+		if robotUcode != "" && robotUcode != boundRobot {
+			return nil, models.NewError(models.ErrorCodeUnauthorized, "operators can only watch their bound robot")
+		}
+		robotUcode = boundRobot
+	}
+	if robotUcode == "" {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "ucode is required")
+	}
+
+	switch command {
+	case models.CMD_TYPE_WEBRTC_WATCH:
+		return h.signaling.Watch(client.UCode, robotUcode, target.Source)
+	case models.CMD_TYPE_WEBRTC_ANSWER:
+		var data models.CMD_WEBRTC_ANSWER
+		if err := payload.Decode(&data); err != nil {
+			return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
+		}
+		return nil, h.signaling.Answer(client.UCode, robotUcode, target.Source, data.Answer)
+	case models.CMD_TYPE_WEBRTC_ICE:
+		var data models.CMD_WEBRTC_ICE
+		if err := payload.Decode(&data); err != nil {
+			return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
+		}
+		return nil, h.signaling.Trickle(client.UCode, robotUcode, target.Source, data.Candidate)
+	default:
+		return nil, h.signaling.Stop(client.UCode, robotUcode, target.Source)
+	}
+}
+
+// 处理解除急停
+func (h *WebSocketHandlers) handleClearEmergency(conn *websocket.Conn, payload models.Payload) error {
+	h.mutex.RLock()
+	client, exists := h.Conn2Client[conn]
+	h.mutex.RUnlock()
+
+	if !exists {
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
+	}
+
+	if !canEmergencyStop(client) {
+		return models.NewError(models.ErrorCodeUnauthorized, "only admins and referees can clear emergency stop")
+	}
+
+	var data models.CMD_CLEAR_EMERGENCY
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
+	}
+
+	h.ClearEmergencyStop(data.UCodes, string(client.ClientType)+":"+client.UCode)
+	return nil
+}
+
 // 处理状态请求
-func (h *WebSocketHandlers) handleUpdateRobotStatus(conn *websocket.Conn, data []byte) error {
+func (h *WebSocketHandlers) handleUpdateRobotStatus(conn *websocket.Conn, payload models.Payload) error {
 
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	var status models.RobotState
-	if err := json.Unmarshal(data, &status); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&status); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
+	h.mutex.Lock()
 	h.RobotStatus[client.UCode] = status
+	h.mutex.Unlock()
+
+	h.publish(models.EventTypeRobotState, client.UCode, status)
+
+	h.updateGeofence(client.UCode, status)
 
 	return nil
 }
 
-// 处理ping消息
-func (h *WebSocketHandlers) handlePing(conn *websocket.Conn, commandJSON []byte) error {
+// 更新电子围栏状态，状态变化时通知绑定的操作者
+func (h *WebSocketHandlers) updateGeofence(robotUcode string, state models.RobotState) {
+	status, previous := h.geofence.Update(robotUcode, state)
+	if status == nil || status.State == previous {
+		return
+	}
+
+	warning := models.CMD_GEOFENCE_WARNING{
+		GeofenceStatus: *status,
+		Previous:       previous,
+	}
+
+	switch status.State {
+	case models.GeofenceStateOutside:
+		warning.Message = fmt.Sprintf("Robot %s left geofence %s", robotUcode, status.Fence)
+		if h.geofence.StopOnBreach() {
+			stop := models.CMD_CONTROL_ROBOT{
+				Action: models.ControlActionStop,
+				ParamMaps: map[string]string{
+					"reason": "geofence breach",
+				},
+				Priority:  models.CommandPriorityEmergency,
+				Timestamp: time.Now().UnixMilli(),
+			}
+			h.safetyService.Reset(robotUcode)
+			if err := h.SendControlToRobot(robotUcode, nil, stop); err != nil {
+				log.Error().Err(err).Str("ucode", robotUcode).Msg("Failed to stop robot on geofence breach")
+			} else {
+				warning.Stopped = true
+			}
+		}
+	case models.GeofenceStateApproaching:
+		warning.Message = fmt.Sprintf("Robot %s is approaching geofence %s boundary", robotUcode, status.Fence)
+	default:
+		warning.Message = fmt.Sprintf("Robot %s is back inside geofence %s", robotUcode, status.Fence)
+	}
+
+	log.Warn().
+		Str("ucode", robotUcode).
+		Str("fence", status.Fence).
+		Str("state", status.State).
+		Str("previous", previous).
+		Float64("distance", status.Distance).
+		Bool("stopped", warning.Stopped).
+		Msg("Geofence state changed")
+
+	h.publish(models.EventTypeGeofence, robotUcode, warning)
+
 	h.mutex.RLock()
-	client, exists := h.Conn2Client[conn]
+	operatorUcode := h.Robot2Operator[robotUcode]
 	h.mutex.RUnlock()
+	if operatorUcode == "" {
+		return
+	}
+
+	h.hub.Publish(services.ClientTopic(operatorUcode), models.Event{
+		Type:  models.EventTypeMessage,
+		UCode: operatorUcode,
+		Data: models.WebSocketMessage{
+			Type:       models.WSMessageTypeRequest,
+			Command:    models.CMD_TYPE_GEOFENCE_WARNING,
+			Sequence:   atomic.AddInt64(&h.sequence, 1),
+			UCode:      robotUcode,
+			ClientType: models.ClientTypeRobot,
+			Version:    models.ProtocolVersionCurrent,
+			Data:       warning,
+		},
+	})
+}
+
+// 处理ping消息，回显客户端时间戳并返回服务端测得的链路延迟
+func (h *WebSocketHandlers) handlePing(conn *websocket.Conn, payload models.Payload) (interface{}, error) {
+	h.mutex.Lock()
+	client, exists := h.Conn2Client[conn]
+	if exists {
+		client.LastSeen = time.Now()
+	}
+	h.mutex.Unlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return nil, models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
-	client.LastSeen = time.Now()
-	return nil
+	var data models.CMD_PING
+	if err := payload.Decode(&data); err != nil {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
+	}
+	if data.RTT < 0 {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "rtt_ms must not be negative")
+	}
+
+	// 客户端上报的RTT只在没有ping帧测量结果时使用
+	if data.RTT > 0 {
+		h.recordLatency(conn, time.Duration(data.RTT)*time.Millisecond, true)
+	}
+
+	response := models.CMD_PING_RESPONSE{
+		Timestamp:  data.Timestamp,
+		ServerTime: time.Now().UnixMilli(),
+	}
+	if latency, exists := h.latency.Get(client.UCode); exists {
+		response.Latency = &latency
+	}
+	return response, nil
+}
+
+// 记录RTT采样，reported表示客户端上报；卡顿状态或推荐频率变化时通知操作者
+func (h *WebSocketHandlers) recordLatency(conn *websocket.Conn, rtt time.Duration, reported bool) {
+	h.mutex.RLock()
+	client, exists := h.Conn2Client[conn]
+	h.mutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	var latency models.LinkLatency
+	var changed bool
+	if reported {
+		latency, changed = h.latency.Report(client.UCode, rtt)
+	} else {
+		latency, changed = h.latency.Record(client.UCode, rtt)
+	}
+
+	h.mutex.Lock()
+	client.Latency = &latency
+	clientType := client.ClientType
+	operatorUcode := client.UCode
+	if clientType == models.ClientTypeRobot {
+		operatorUcode = h.Robot2Operator[client.UCode]
+	}
+	h.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	notice := models.CMD_LINK_QUALITY{
+		UCode:   client.UCode,
+		Reason:  models.LinkQualityChanged,
+		Latency: latency,
+	}
+	if latency.Laggy {
+		notice.Message = fmt.Sprintf("Link to %s is laggy (%dms RTT), send control at most %.0f Hz", client.UCode, latency.SmoothedRTT, latency.RecommendedRate)
+	}
+	h.publish(models.EventTypeLinkQuality, client.UCode, notice)
+
+	// 机器人链路的变化通知其绑定的操作者
+	if operatorUcode != "" {
+		h.pushLinkQuality(operatorUcode, clientType, notice)
+	}
 }
 
-// 获取所有机器人连接信息
+// 推送链路质量通知给操作者
+func (h *WebSocketHandlers) pushLinkQuality(operatorUcode string, clientType models.ClientType, notice models.CMD_LINK_QUALITY) {
+	if h.hub == nil {
+		return
+	}
+	h.hub.Publish(services.ClientTopic(operatorUcode), models.Event{
+		Type:  models.EventTypeMessage,
+		UCode: operatorUcode,
+		Data: models.WebSocketMessage{
+			Type:       models.WSMessageTypeRequest,
+			Command:    models.CMD_TYPE_LINK_QUALITY,
+			Sequence:   atomic.AddInt64(&h.sequence, 1),
+			UCode:      notice.UCode,
+			ClientType: clientType,
+			Version:    models.ProtocolVersionCurrent,
+			Data:       notice,
+		},
+	})
+}
+
+// 赛前检查全部参赛机器人及其操作者的链路
+func (h *WebSocketHandlers) checkMatchLinks(gameID string) []models.CMD_LINK_QUALITY {
+	players, err := h.gameService.GetPlayers(gameID)
+	if err != nil {
+		return nil
+	}
+
+	laggy := make([]models.CMD_LINK_QUALITY, 0)
+	for _, robotUcode := range players {
+		laggy = append(laggy, h.checkPlayerLinks(gameID, robotUcode)...)
+	}
+	return laggy
+}
+
+// 赛前检查机器人及其操作者的链路，卡顿的链路通知对应操作者
+func (h *WebSocketHandlers) checkPlayerLinks(gameID, robotUcode string) []models.CMD_LINK_QUALITY {
+	h.mutex.RLock()
+	operatorUcode := h.Robot2Operator[robotUcode]
+	h.mutex.RUnlock()
+
+	laggy := make([]models.CMD_LINK_QUALITY, 0)
+	links := []struct {
+		ucode      string
+		clientType models.ClientType
+	}{
+		{robotUcode, models.ClientTypeRobot},
+		{operatorUcode, models.ClientTypeOperator},
+	}
+	for _, link := range links {
+		if link.ucode == "" {
+			continue
+		}
+		latency, exists := h.latency.Get(link.ucode)
+		if !exists || !latency.Laggy {
+			continue
+		}
+
+		notice := models.CMD_LINK_QUALITY{
+			UCode:   link.ucode,
+			Reason:  models.LinkQualityPreMatch,
+			GameID:  gameID,
+			Latency: latency,
+			Message: fmt.Sprintf("Link to %s is laggy before game %s (%dms RTT), send control at most %.0f Hz", link.ucode, gameID, latency.SmoothedRTT, latency.RecommendedRate),
+		}
+		laggy = append(laggy, notice)
+
+		log.Warn().
+			Str("game_id", gameID).
+			Str("ucode", link.ucode).
+			Int64("smoothed_rtt_ms", latency.SmoothedRTT).
+			Float64("recommended_rate_hz", latency.RecommendedRate).
+			Msg("Laggy link before match")
+
+		h.publish(models.EventTypeLinkQuality, link.ucode, notice)
+		if operatorUcode != "" {
+			h.pushLinkQuality(operatorUcode, link.clientType, notice)
+		}
+	}
+	return laggy
+}
+
+// 获取所有机器人连接信息的副本
 func (h *WebSocketHandlers) GetAllRobotConnections() []*models.Client {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
@@ -531,13 +1609,14 @@ func (h *WebSocketHandlers) GetAllRobotConnections() []*models.Client {
 	robots := make([]*models.Client, 0, len(h.Conn2Client))
 	for _, client := range h.Conn2Client {
 		if client.ClientType == models.ClientTypeRobot {
-			robots = append(robots, client)
+			copied := *client
+			robots = append(robots, &copied)
 		}
 	}
 	return robots
 }
 
-// 获取所有操作者连接信息
+// 获取所有操作者连接信息的副本
 func (h *WebSocketHandlers) GetAllOperatorConnections() []*models.Client {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
@@ -545,7 +1624,8 @@ func (h *WebSocketHandlers) GetAllOperatorConnections() []*models.Client {
 	operators := make([]*models.Client, 0, len(h.Conn2Client))
 	for _, client := range h.Conn2Client {
 		if client.ClientType == models.ClientTypeOperator {
-			operators = append(operators, client)
+			copied := *client
+			operators = append(operators, &copied)
 		}
 	}
 	return operators
@@ -554,22 +1634,22 @@ func (h *WebSocketHandlers) GetAllOperatorConnections() []*models.Client {
 // 游戏相关处理方法
 
 // 处理加入游戏
-func (h *WebSocketHandlers) handleJoinGame(conn *websocket.Conn, dataJSON []byte) error {
+func (h *WebSocketHandlers) handleJoinGame(conn *websocket.Conn, payload models.Payload) error {
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	if client.ClientType != models.ClientTypeRobot {
-		return errors.New("only robots can join games")
+		return models.NewError(models.ErrorCodeUnauthorized, "only robots can join games")
 	}
 
 	var data models.CMD_JOIN_GAME
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	// 如果游戏不存在，创建新游戏
@@ -578,53 +1658,59 @@ func (h *WebSocketHandlers) handleJoinGame(conn *websocket.Conn, dataJSON []byte
 	}
 
 	// 加入游戏
-	return h.gameService.JoinGame(data.GameID, client.UCode, data.Name, conn)
+	if err := h.gameService.JoinGame(data.GameID, client.UCode, data.Name); err != nil {
+		return err
+	}
+
+	// 等待开赛期间即提醒操作者链路卡顿
+	h.checkPlayerLinks(data.GameID, client.UCode)
+	return nil
 }
 
 // 处理离开游戏
-func (h *WebSocketHandlers) handleLeaveGame(conn *websocket.Conn, dataJSON []byte) error {
+func (h *WebSocketHandlers) handleLeaveGame(conn *websocket.Conn, payload models.Payload) error {
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	if client.ClientType != models.ClientTypeRobot {
-		return errors.New("only robots can leave games")
+		return models.NewError(models.ErrorCodeUnauthorized, "only robots can leave games")
 	}
 
 	var data map[string]interface{}
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	gameID, ok := data["game_id"].(string)
 	if !ok {
-		return errors.New("game_id is required")
+		return models.NewError(models.ErrorCodeInvalidPayload, "game_id is required")
 	}
 
 	return h.gameService.LeaveGame(gameID, client.UCode)
 }
 
 // 处理游戏射击
-func (h *WebSocketHandlers) handleGameShoot(conn *websocket.Conn, dataJSON []byte) error {
+func (h *WebSocketHandlers) handleGameShoot(conn *websocket.Conn, payload models.Payload) error {
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	if client.ClientType != models.ClientTypeRobot {
-		return errors.New("only robots can shoot")
+		return models.NewError(models.ErrorCodeUnauthorized, "only robots can shoot")
 	}
 
 	var data models.CMD_GAME_SHOOT
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	// 需要从消息中获取游戏ID，这里简化处理，假设只有一个活跃游戏
@@ -635,22 +1721,22 @@ func (h *WebSocketHandlers) handleGameShoot(conn *websocket.Conn, dataJSON []byt
 }
 
 // 处理游戏移动
-func (h *WebSocketHandlers) handleGameMove(conn *websocket.Conn, dataJSON []byte) error {
+func (h *WebSocketHandlers) handleGameMove(conn *websocket.Conn, payload models.Payload) error {
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	if client.ClientType != models.ClientTypeRobot {
-		return errors.New("only robots can move")
+		return models.NewError(models.ErrorCodeUnauthorized, "only robots can move")
 	}
 
 	var data models.CMD_GAME_MOVE
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	// 简化处理，假设只有一个活跃游戏
@@ -660,23 +1746,23 @@ func (h *WebSocketHandlers) handleGameMove(conn *websocket.Conn, dataJSON []byte
 }
 
 // 处理游戏状态请求
-func (h *WebSocketHandlers) handleGameStatus(conn *websocket.Conn, dataJSON []byte) error {
+func (h *WebSocketHandlers) handleGameStatus(conn *websocket.Conn, payload models.Payload) error {
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	var data map[string]interface{}
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	gameID, ok := data["game_id"].(string)
 	if !ok {
-		return errors.New("game_id is required")
+		return models.NewError(models.ErrorCodeInvalidPayload, "game_id is required")
 	}
 
 	gameState, err := h.gameService.GetGameState(gameID)
@@ -701,58 +1787,68 @@ func (h *WebSocketHandlers) handleGameStatus(conn *websocket.Conn, dataJSON []by
 		},
 	}
 
-	return conn.WriteJSON(response)
+	return h.writeMessage(conn, response)
 }
 
-// 处理开始游戏
-func (h *WebSocketHandlers) handleGameStart(conn *websocket.Conn, dataJSON []byte) error {
+// 处理开始游戏，返回卡顿的链路
+func (h *WebSocketHandlers) handleGameStart(conn *websocket.Conn, payload models.Payload) (interface{}, error) {
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return nil, models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	if client.ClientType != models.ClientTypeOperator {
-		return errors.New("only operators can start games")
+		return nil, models.NewError(models.ErrorCodeUnauthorized, "only operators can start games")
 	}
 
 	var data map[string]interface{}
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	gameID, ok := data["game_id"].(string)
 	if !ok {
-		return errors.New("game_id is required")
+		return nil, models.NewError(models.ErrorCodeInvalidPayload, "game_id is required")
+	}
+
+	// 开赛前标记卡顿链路，提醒操作者降低控制频率
+	laggy := h.checkMatchLinks(gameID)
+
+	if err := h.gameService.StartGame(gameID); err != nil {
+		return nil, err
 	}
 
-	return h.gameService.StartGame(gameID)
+	return models.CMD_GAME_START_RESPONSE{
+		GameID:     gameID,
+		LaggyLinks: laggy,
+	}, nil
 }
 
 // 处理停止游戏
-func (h *WebSocketHandlers) handleGameStop(conn *websocket.Conn, dataJSON []byte) error {
+func (h *WebSocketHandlers) handleGameStop(conn *websocket.Conn, payload models.Payload) error {
 	h.mutex.RLock()
 	client, exists := h.Conn2Client[conn]
 	h.mutex.RUnlock()
 
 	if !exists {
-		return errors.New("client not found")
+		return models.NewError(models.ErrorCodeNotRegistered, "client not found")
 	}
 
 	if client.ClientType != models.ClientTypeOperator {
-		return errors.New("only operators can stop games")
+		return models.NewError(models.ErrorCodeUnauthorized, "only operators can stop games")
 	}
 
 	var data map[string]interface{}
-	if err := json.Unmarshal(dataJSON, &data); err != nil {
-		return errors.New("failed to parse command: " + err.Error())
+	if err := payload.Decode(&data); err != nil {
+		return models.NewError(models.ErrorCodeInvalidPayload, "failed to parse command: "+err.Error())
 	}
 
 	gameID, ok := data["game_id"].(string)
 	if !ok {
-		return errors.New("game_id is required")
+		return models.NewError(models.ErrorCodeInvalidPayload, "game_id is required")
 	}
 
 	return h.gameService.StopGame(gameID)
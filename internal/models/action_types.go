@@ -0,0 +1,35 @@
+package models
+
+// 动作参数类型
+type ActionParamType string
+
+const (
+	ActionParamTypeFloat  ActionParamType = "float"  // 浮点数
+	ActionParamTypeInt    ActionParamType = "int"    // 整数
+	ActionParamTypeBool   ActionParamType = "bool"   // 布尔
+	ActionParamTypeString ActionParamType = "string" // 字符串
+)
+
+// 动作参数定义
+type ActionParamSchema struct {
+	Name        string          `mapstructure:"name" json:"name"`                         // 参数名
+	Type        ActionParamType `mapstructure:"type" json:"type"`                         // 参数类型
+	Required    bool            `mapstructure:"required" json:"required"`                 // 是否必填
+	Min         *float64        `mapstructure:"min" json:"min,omitempty"`                 // 最小值 (数值类型)
+	Max         *float64        `mapstructure:"max" json:"max,omitempty"`                 // 最大值 (数值类型)
+	Enum        []string        `mapstructure:"enum" json:"enum,omitempty"`               // 可选值 (字符串类型)
+	Description string          `mapstructure:"description" json:"description,omitempty"` // 说明
+}
+
+// 动作定义
+type ActionSchema struct {
+	Name        string              `mapstructure:"name" json:"name"`                         // 动作名
+	Description string              `mapstructure:"description" json:"description,omitempty"` // 说明
+	Params      []ActionParamSchema `mapstructure:"params" json:"params"`                     // 参数列表
+}
+
+// 动作注册表配置
+type ActionRegistryConfig struct {
+	DefaultModel string                    `mapstructure:"default_model"` // 未知型号使用的默认型号
+	Models       map[string][]ActionSchema `mapstructure:"models"`        // 型号 -> 支持的动作
+}
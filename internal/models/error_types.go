@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 错误码，客户端应按错误码而不是错误信息判断错误类型
+type ErrorCode string
+
+const (
+	// 通用
+	ErrorCodeBadRequest       ErrorCode = "BAD_REQUEST"        // 请求参数错误
+	ErrorCodeMethodNotAllowed ErrorCode = "METHOD_NOT_ALLOWED" // 请求方法不支持
+	ErrorCodeNotFound         ErrorCode = "NOT_FOUND"          // 资源不存在
+	ErrorCodeInternal         ErrorCode = "INTERNAL"           // 服务端内部错误
+	ErrorCodeUnavailable      ErrorCode = "UNAVAILABLE"        // 依赖服务不可用
+
+	// 协议与连接
+	ErrorCodeInvalidMessage             ErrorCode = "INVALID_MESSAGE"              // 消息格式错误
+	ErrorCodeInvalidPayload             ErrorCode = "INVALID_PAYLOAD"              // 命令数据无法解析
+	ErrorCodeUnknownCommand             ErrorCode = "UNKNOWN_COMMAND"              // 未知命令
+	ErrorCodeInvalidProtocolVersion     ErrorCode = "INVALID_PROTOCOL_VERSION"     // 版本号格式错误
+	ErrorCodeUnsupportedProtocolVersion ErrorCode = "UNSUPPORTED_PROTOCOL_VERSION" // 版本不在支持范围内
+	ErrorCodeNotRegistered              ErrorCode = "NOT_REGISTERED"               // 连接未注册
+	ErrorCodeUCodeInUse                 ErrorCode = "UCODE_IN_USE"                 // UCode已被占用
+	ErrorCodeUnauthorized               ErrorCode = "UNAUTHORIZED"                 // 客户端类型无权执行该操作
+	ErrorCodeRateLimited                ErrorCode = "RATE_LIMITED"                 // 超出消息速率限制
+
+	// 机器人控制
+	ErrorCodeRobotOffline      ErrorCode = "ROBOT_OFFLINE"      // 机器人不在线
+	ErrorCodeNotBound          ErrorCode = "NOT_BOUND"          // 操作者未绑定机器人
+	ErrorCodeAlreadyBound      ErrorCode = "ALREADY_BOUND"      // 机器人或操作者已绑定
+	ErrorCodeEmergencyStop     ErrorCode = "EMERGENCY_STOP"     // 机器人处于急停锁定
+	ErrorCodeInvalidCommand    ErrorCode = "INVALID_COMMAND"    // 命令参数校验失败
+	ErrorCodeUnsupportedAction ErrorCode = "UNSUPPORTED_ACTION" // 机器人不支持该动作
+	ErrorCodeSafetyLimit       ErrorCode = "SAFETY_LIMIT"       // 超出安全包络
+	ErrorCodeGeofence          ErrorCode = "GEOFENCE"           // 会驶出电子围栏
+	ErrorCodeSendFailed        ErrorCode = "SEND_FAILED"        // 命令下发失败
+	ErrorCodeQueueFull         ErrorCode = "QUEUE_FULL"         // 下发队列已满
+
+	// 任务
+	ErrorCodeInvalidMission  ErrorCode = "INVALID_MISSION"   // 任务定义错误
+	ErrorCodeMissionState    ErrorCode = "MISSION_STATE"     // 任务状态不允许该操作
+	ErrorCodeMissionNotFound ErrorCode = "MISSION_NOT_FOUND" // 没有正在执行的任务
+
+	// 游戏
+	ErrorCodeGameNotFound     ErrorCode = "GAME_NOT_FOUND"     // 游戏不存在
+	ErrorCodeGameState        ErrorCode = "GAME_STATE"         // 游戏状态不允许该操作
+	ErrorCodeNotInGame        ErrorCode = "NOT_IN_GAME"        // 机器人不在游戏中
+	ErrorCodeAlreadyInGame    ErrorCode = "ALREADY_IN_GAME"    // 机器人已在游戏中
+	ErrorCodeNotEnoughPlayers ErrorCode = "NOT_ENOUGH_PLAYERS" // 人数不足
+	ErrorCodeRobotNotAlive    ErrorCode = "ROBOT_NOT_ALIVE"    // 机器人已被击毁
+	ErrorCodeCooldown         ErrorCode = "COOLDOWN"           // 射击冷却中
+	ErrorCodeOutOfBounds      ErrorCode = "OUT_OF_BOUNDS"      // 位置超出地图
+)
+
+// 错误码说明
+type ErrorCodeInfo struct {
+	Code        ErrorCode `json:"code"`        // 错误码
+	HTTPStatus  int       `json:"http_status"` // REST接口对应的HTTP状态码
+	Description string    `json:"description"` // 说明
+}
+
+// 错误码说明表
+var errorDescriptions = []struct {
+	code        ErrorCode
+	description string
+}{
+	{ErrorCodeBadRequest, "Request parameters are missing or malformed"},
+	{ErrorCodeMethodNotAllowed, "HTTP method is not supported by this endpoint"},
+	{ErrorCodeNotFound, "Requested resource does not exist"},
+	{ErrorCodeInternal, "Unexpected server error"},
+	{ErrorCodeUnavailable, "A dependent service (e.g. Janus) is unavailable"},
+	{ErrorCodeInvalidMessage, "Websocket message envelope is malformed"},
+	{ErrorCodeInvalidPayload, "Command data could not be decoded"},
+	{ErrorCodeUnknownCommand, "Command type is not recognised"},
+	{ErrorCodeInvalidProtocolVersion, "Protocol version is not a valid semantic version"},
+	{ErrorCodeUnsupportedProtocolVersion, "Protocol version is outside the supported range"},
+	{ErrorCodeNotRegistered, "Connection has not completed CMD_REGISTER"},
+	{ErrorCodeUCodeInUse, "UCode is already registered by another connection"},
+	{ErrorCodeUnauthorized, "Client type is not allowed to perform this operation"},
+	{ErrorCodeRateLimited, "Client exceeded its message rate limit"},
+	{ErrorCodeRobotOffline, "Target robot is not connected"},
+	{ErrorCodeNotBound, "Operator is not bound to a robot"},
+	{ErrorCodeAlreadyBound, "Robot or operator is already bound"},
+	{ErrorCodeEmergencyStop, "Robot is latched in emergency stop"},
+	{ErrorCodeInvalidCommand, "Control command failed schema validation"},
+	{ErrorCodeUnsupportedAction, "Robot does not support the requested action"},
+	{ErrorCodeSafetyLimit, "Command exceeds the robot safety envelope"},
+	{ErrorCodeGeofence, "Command would move the robot out of its geofence"},
+	{ErrorCodeSendFailed, "Command could not be delivered to the robot"},
+	{ErrorCodeQueueFull, "Robot command queue is full"},
+	{ErrorCodeInvalidMission, "Mission definition is invalid"},
+	{ErrorCodeMissionState, "Mission state does not allow this operation"},
+	{ErrorCodeMissionNotFound, "Robot has no active mission"},
+	{ErrorCodeGameNotFound, "Game does not exist"},
+	{ErrorCodeGameState, "Game status does not allow this operation"},
+	{ErrorCodeNotInGame, "Robot is not part of the game"},
+	{ErrorCodeAlreadyInGame, "Robot has already joined a game"},
+	{ErrorCodeNotEnoughPlayers, "Not enough robots to start the game"},
+	{ErrorCodeRobotNotAlive, "Robot has been destroyed in the game"},
+	{ErrorCodeCooldown, "Weapon is cooling down"},
+	{ErrorCodeOutOfBounds, "Position is outside the game map"},
+}
+
+// ErrorCatalog 获取错误码列表，statusFor 提供HTTP状态码映射
+func ErrorCatalog(statusFor func(ErrorCode) int) []ErrorCodeInfo {
+	catalog := make([]ErrorCodeInfo, 0, len(errorDescriptions))
+	for _, item := range errorDescriptions {
+		catalog = append(catalog, ErrorCodeInfo{
+			Code:        item.code,
+			HTTPStatus:  statusFor(item.code),
+			Description: item.description,
+		})
+	}
+	return catalog
+}
+
+// CodedError 带错误码的错误
+type CodedError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *CodedError) Error() string {
+	return e.Message
+}
+
+// NewError 创建带错误码的错误
+func NewError(code ErrorCode, message string) *CodedError {
+	return &CodedError{Code: code, Message: message}
+}
+
+// Errorf 创建带错误码的格式化错误
+func Errorf(code ErrorCode, format string, args ...interface{}) *CodedError {
+	return &CodedError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCodeOf 获取错误码，未分类的错误视为内部错误
+func ErrorCodeOf(err error) ErrorCode {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) {
+		return protocolErr.Code
+	}
+	return ErrorCodeInternal
+}
+
+// REST错误响应
+type ErrorResponse struct {
+	Success bool        `json:"success"`
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
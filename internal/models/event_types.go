@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// 事件类型
+type EventType string
+
+const (
+	EventTypeRobotConnected       EventType = "robot_connected"       // 机器人上线
+	EventTypeRobotDisconnected    EventType = "robot_disconnected"    // 机器人下线
+	EventTypeOperatorConnected    EventType = "operator_connected"    // 操作者上线
+	EventTypeOperatorDisconnected EventType = "operator_disconnected" // 操作者下线
+	EventTypeRobotState           EventType = "robot_state"           // 机器人状态更新
+	EventTypeRobotBound           EventType = "robot_bound"           // 机器人绑定
+	EventTypeRobotUnbound         EventType = "robot_unbound"         // 机器人解绑
+	EventTypeEmergencyStop        EventType = "emergency_stop"        // 急停触发
+	EventTypeEmergencyCleared     EventType = "emergency_cleared"     // 急停解除
+	EventTypeGeofence             EventType = "geofence"              // 电子围栏状态变化
+	EventTypeLinkQuality          EventType = "link_quality"          // 客户端链路质量变化
+	EventTypeMission              EventType = "mission"               // 任务状态变化
+	EventTypeGameEvent            EventType = "game_event"            // 游戏事件
+	EventTypeMessage              EventType = "message"               // 直接投递给客户端的WebSocket消息
+)
+
+// 内部事件
+type Event struct {
+	Topic     string      `json:"topic"`           // 主题
+	Type      EventType   `json:"type"`            // 事件类型
+	UCode     string      `json:"ucode,omitempty"` // 相关UCode
+	Timestamp time.Time   `json:"timestamp"`       // 时间戳
+	Data      interface{} `json:"data,omitempty"`  // 数据
+}
+
+// 绑定关系变更
+type BindingChange struct {
+	OperatorUCode string `json:"operator_ucode"` // 操作者UCode
+	RobotUCode    string `json:"robot_ucode"`    // 机器人UCode
+}
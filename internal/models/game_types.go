@@ -183,7 +183,8 @@ type CMD_GAME_STATUS_RESPONSE struct {
 
 // 游戏事件通知
 type CMD_GAME_EVENT struct {
-	Event *GameEvent `json:"event"`
+	GameID string     `json:"game_id"` // 游戏ID
+	Event  *GameEvent `json:"event"`
 }
 
 // 游戏统计响应
@@ -0,0 +1,41 @@
+package models
+
+// 电子围栏状态
+const (
+	GeofenceStateInside      = "inside"      // 围栏内
+	GeofenceStateApproaching = "approaching" // 接近边界
+	GeofenceStateOutside     = "outside"     // 越界
+)
+
+// 电子围栏，Robots为空表示适用于整个场地
+type Geofence struct {
+	Name    string       `mapstructure:"name" json:"name"`                         // 围栏名称
+	Robots  []string     `mapstructure:"robots" json:"robots,omitempty"`           // 适用的机器人UCode
+	Polygon [][2]float64 `mapstructure:"polygon" json:"polygon"`                   // 多边形顶点 [x, y]
+	Warning float64      `mapstructure:"warning_distance" json:"warning_distance"` // 预警距离 (m)，为0时使用全局配置
+}
+
+// 电子围栏配置
+type GeofenceConfig struct {
+	Fences          []Geofence `mapstructure:"fences"`           // 围栏列表
+	WarningDistance float64    `mapstructure:"warning_distance"` // 默认预警距离 (m)
+	StopOnBreach    bool       `mapstructure:"stop_on_breach"`   // 越界时是否下发停止命令
+}
+
+// 机器人相对围栏的状态
+type GeofenceStatus struct {
+	UCode    string     `json:"ucode"`    // 机器人UCode
+	Fence    string     `json:"fence"`    // 围栏名称
+	State    string     `json:"state"`    // inside, approaching, outside
+	Position [2]float64 `json:"position"` // 当前位置 [x, y]
+	Distance float64    `json:"distance"` // 到边界的距离 (m)，围栏外为负数
+	Outward  [2]float64 `json:"outward"`  // 指向围栏外的单位向量
+}
+
+// 电子围栏告警，推送给绑定的操作者
+type CMD_GEOFENCE_WARNING struct {
+	GeofenceStatus
+	Previous string `json:"previous"` // 之前的状态
+	Stopped  bool   `json:"stopped"`  // 是否已下发停止命令
+	Message  string `json:"message"`  // 提示信息
+}
@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// RTP端口范围
+type JanusPortRange struct {
+	Min int `mapstructure:"min" json:"min"`
+	Max int `mapstructure:"max" json:"max"`
+}
+
+// Janus实例配置，未配置的字段沿用顶层配置
+type JanusInstanceConfig struct {
+	Name         string         `mapstructure:"name"`          // 实例名称
+	HTTPURL      string         `mapstructure:"http_url"`      // REST API地址
+	BasePath     string         `mapstructure:"base_path"`     // REST API路径
+	WebSocketURL string         `mapstructure:"websocket_url"` // WebSocket API地址
+	RTPHost      string         `mapstructure:"rtp_host"`      // 机器人推流的目标地址
+	RTPPorts     JanusPortRange `mapstructure:"rtp_ports"`     // 挂载点RTP端口池
+	AdminURL     string         `mapstructure:"admin_url"`     // Admin API地址
+	Weight       int            `mapstructure:"weight"`        // 负载权重，默认1
+}
+
+// Janus配置
+type JanusConfig struct {
+	Transport    string         `mapstructure:"transport"`     // 服务端调用Janus API的方式: http, websocket
+	HTTPURL      string         `mapstructure:"http_url"`      // REST API地址
+	BasePath     string         `mapstructure:"base_path"`     // REST API路径，默认 /janus
+	WebSocketURL string         `mapstructure:"websocket_url"` // WebSocket API地址，浏览器播放和websocket传输共用
+	StreamID     int            `mapstructure:"stream_id"`     // 挂载点ID起始值
+	AutoRegister bool           `mapstructure:"auto_register"` // 有视频源的机器人注册时自动创建流
+	AdminKey     string         `mapstructure:"admin_key"`     // streaming插件的admin_key
+	RTPHost      string         `mapstructure:"rtp_host"`      // 机器人推流的目标地址，默认取 http_url 的主机名
+	RTPPorts     JanusPortRange `mapstructure:"rtp_ports"`     // 挂载点RTP端口池
+	VideoCodec   string         `mapstructure:"video_codec"`   // h264 / vp8 / vp9
+	Audio        bool           `mapstructure:"audio"`         // 是否同时创建音频端口
+	AdminURL     string         `mapstructure:"admin_url"`     // Admin API地址，用于采集观看者的媒体统计，留空则只采集挂载点信息
+	AdminSecret  string         `mapstructure:"admin_secret"`  // Admin API的admin_secret
+
+	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval"` // 会话keepalive间隔
+	InactiveGrace     time.Duration `mapstructure:"inactive_grace"`     // 机器人断开后保留挂载点的时间
+	BatchConcurrency  int           `mapstructure:"batch_concurrency"`  // 批量注册的并发数
+
+	Instances        []JanusInstanceConfig `mapstructure:"instances"`         // 多实例部署，为空时使用顶层地址作为唯一实例
+	HealthInterval   time.Duration         `mapstructure:"health_interval"`   // 健康检查间隔
+	FailureThreshold int                   `mapstructure:"failure_threshold"` // 连续失败多少次判定实例故障并迁移挂载点
+
+	StatsInterval time.Duration `mapstructure:"stats_interval"` // 媒体统计采集间隔
+	StatsHistory  int           `mapstructure:"stats_history"`  // 每个流保留的统计采样数
+}
+
+// Janus实例健康状态
+type JanusInstanceHealth struct {
+	Name      string `json:"name"`
+	HTTPURL   string `json:"http_url"`
+	Healthy   bool   `json:"healthy"`
+	Failures  int    `json:"failures"`             // 连续失败次数
+	Streams   int    `json:"streams"`              // 实例上的流数量
+	Latency   int64  `json:"latency_ms"`           // 最近一次检查耗时
+	LastCheck int64  `json:"last_check,omitempty"` // 最近一次检查时间戳
+	LastError string `json:"last_error,omitempty"`
+}
+
+// streaming插件挂载点
+type JanusMountpoint struct {
+	ID          int    `json:"id"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	VideoPort   int    `json:"video_port,omitempty"`
+	AudioPort   int    `json:"audio_port,omitempty"`
+	Instance    string `json:"instance,omitempty"` // 所在Janus实例
+	Viewers     int    `json:"viewers"`            // 观看者数量
+	AgeMs       int64  `json:"age_ms"`             // 距最近一次收到机器人RTP包的毫秒数，-1表示未知
+}
+
+// 挂载点对账结果
+type JanusReconcileResult struct {
+	Mountpoints int      `json:"mountpoints"` // Janus中的挂载点数量
+	Adopted     []string `json:"adopted"`     // 从Janus恢复到本地的流
+	Recreated   []string `json:"recreated"`   // Janus中缺失并已重新创建的流
+	Failed      []string `json:"failed"`      // 重新创建失败的流
+	Removed     []string `json:"removed"`     // 已迁移到其他实例、被销毁的旧挂载点
+}
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// 链路延迟测量配置
+type LatencyConfig struct {
+	PingInterval   time.Duration `mapstructure:"ping_interval"`    // 服务端带时间戳ping的间隔
+	Smoothing      float64       `mapstructure:"smoothing"`        // RTT平滑系数，0~1，越大越偏向最新采样
+	LaggyRTT       time.Duration `mapstructure:"laggy_rtt"`        // 平滑RTT超过该值视为链路卡顿
+	MaxControlRate float64       `mapstructure:"max_control_rate"` // 推荐控制发送频率上限 (Hz)
+	MinControlRate float64       `mapstructure:"min_control_rate"` // 推荐控制发送频率下限 (Hz)
+}
+
+// RTT采样来源
+const (
+	LatencySourcePing   = "ping"   // 服务端ping帧测量
+	LatencySourceClient = "client" // 客户端上报，仅用于无法响应ping帧的客户端
+)
+
+// 链路延迟
+type LinkLatency struct {
+	Source          string    `json:"source"`              // 采样来源
+	RTT             int64     `json:"rtt_ms"`              // 最近一次RTT
+	SmoothedRTT     int64     `json:"smoothed_rtt_ms"`     // 平滑RTT
+	MinRTT          int64     `json:"min_rtt_ms"`          // 最小RTT
+	MaxRTT          int64     `json:"max_rtt_ms"`          // 最大RTT
+	Jitter          int64     `json:"jitter_ms"`           // RTT抖动
+	Samples         int64     `json:"samples"`             // 采样次数
+	RecommendedRate float64   `json:"recommended_rate_hz"` // 推荐控制发送频率
+	Laggy           bool      `json:"laggy"`               // 是否卡顿
+	UpdatedAt       time.Time `json:"updated_at"`          // 最近一次采样时间
+}
+
+// 心跳请求
+type CMD_PING struct {
+	Timestamp int64 `json:"timestamp,omitempty"` // 客户端发送时间 (毫秒)，响应中原样返回
+	RTT       int64 `json:"rtt_ms,omitempty"`    // 客户端根据上一次回显测得的RTT (毫秒)，仅在没有ping帧测量结果时使用
+}
+
+// 心跳响应
+type CMD_PING_RESPONSE struct {
+	Timestamp  int64        `json:"timestamp,omitempty"` // 回显客户端发送时间
+	ServerTime int64        `json:"server_time"`         // 服务端时间 (毫秒)
+	Latency    *LinkLatency `json:"latency,omitempty"`   // 服务端测得的链路延迟
+}
+
+// 链路质量通知原因
+const (
+	LinkQualityChanged  = "changed"   // 卡顿状态或推荐频率变化
+	LinkQualityPreMatch = "pre_match" // 比赛开始前检查
+)
+
+// 链路质量通知
+type CMD_LINK_QUALITY struct {
+	UCode   string      `json:"ucode"`             // 被测客户端UCode
+	Reason  string      `json:"reason"`            // 通知原因
+	GameID  string      `json:"game_id,omitempty"` // 比赛ID，赛前检查时有值
+	Latency LinkLatency `json:"latency"`           // 链路延迟
+	Message string      `json:"message,omitempty"` // 提示信息
+}
+
+// 开始比赛响应，列出卡顿的链路
+type CMD_GAME_START_RESPONSE struct {
+	GameID     string             `json:"game_id"`
+	LaggyLinks []CMD_LINK_QUALITY `json:"laggy_links,omitempty"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// 任务步骤类型
+const (
+	MissionStepCommand = "command" // 下发控制命令，可带持续时间
+	MissionStepWait    = "wait"    // 等待一段时间或等待状态条件
+	MissionStepLoop    = "loop"    // 循环执行子步骤
+)
+
+// 任务状态
+const (
+	MissionStateRunning   = "running"
+	MissionStatePaused    = "paused"
+	MissionStateCompleted = "completed"
+	MissionStateAborted   = "aborted"
+	MissionStateFailed    = "failed"
+)
+
+// 任务相关命令
+const (
+	CMD_TYPE_MISSION_START  CommandType = "CMD_MISSION_START"  // 开始任务
+	CMD_TYPE_MISSION_PAUSE  CommandType = "CMD_MISSION_PAUSE"  // 暂停任务
+	CMD_TYPE_MISSION_RESUME CommandType = "CMD_MISSION_RESUME" // 继续任务
+	CMD_TYPE_MISSION_ABORT  CommandType = "CMD_MISSION_ABORT"  // 中止任务
+	CMD_TYPE_MISSION_STATUS CommandType = "CMD_MISSION_STATUS" // 任务状态
+)
+
+// 状态等待条件，field 可选 battery_level, temperature, error_code, x, y, z, status
+type MissionCondition struct {
+	Field string      `json:"field" yaml:"field"`
+	Op    string      `json:"op" yaml:"op"` // <, <=, >, >=, ==, !=
+	Value interface{} `json:"value" yaml:"value"`
+}
+
+// 任务步骤
+type MissionStep struct {
+	Type      string            `json:"type" yaml:"type"`
+	Name      string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Action    string            `json:"action,omitempty" yaml:"action,omitempty"`       // command: 控制动作
+	Params    map[string]string `json:"params,omitempty" yaml:"params,omitempty"`       // command: 动作参数
+	Duration  string            `json:"duration,omitempty" yaml:"duration,omitempty"`   // command: Move持续时间或动作后等待; wait: 等待时间
+	Condition *MissionCondition `json:"condition,omitempty" yaml:"condition,omitempty"` // wait: 等待条件
+	Timeout   string            `json:"timeout,omitempty" yaml:"timeout,omitempty"`     // wait: 条件等待超时
+	Count     int               `json:"count,omitempty" yaml:"count,omitempty"`         // loop: 循环次数
+	Steps     []MissionStep     `json:"steps,omitempty" yaml:"steps,omitempty"`         // loop: 子步骤
+}
+
+// 任务定义
+type Mission struct {
+	Name  string        `json:"name" yaml:"name"`
+	Steps []MissionStep `json:"steps" yaml:"steps"`
+}
+
+// 任务执行状态
+type MissionStatus struct {
+	UCode       string     `json:"ucode"`
+	Mission     string     `json:"mission"`
+	State       string     `json:"state"`
+	Step        string     `json:"step"`         // 当前步骤路径，例如 "3" 或 "3.2"
+	StepName    string     `json:"step_name"`    // 当前步骤说明
+	Iteration   int        `json:"iteration"`    // 当前所在循环的次数，从1开始
+	CommandSent int        `json:"command_sent"` // 已下发的命令数
+	StartedBy   string     `json:"started_by"`
+	StartedAt   time.Time  `json:"started_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// 开始任务请求
+type CMD_MISSION_START struct {
+	UCode   string  `json:"ucode"` // 机器人UCode，操作者为空时使用绑定的机器人
+	Mission Mission `json:"mission"`
+}
+
+// 暂停/继续/中止/查询任务请求
+type CMD_MISSION_CONTROL struct {
+	UCode  string `json:"ucode"`
+	Reason string `json:"reason,omitempty"`
+}
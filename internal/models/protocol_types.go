@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// 协议版本
+const (
+	ProtocolVersionMin     = "1.0.0" // 最低支持版本
+	ProtocolVersionCurrent = "1.1.0" // 当前版本
+)
+
+// 支持的协议版本范围
+type ProtocolRange struct {
+	Min string `json:"min"` // 最低版本
+	Max string `json:"max"` // 最高版本
+}
+
+// 协议协商失败信息
+type ProtocolError struct {
+	Code          ErrorCode     `json:"code"`           // 错误码
+	Message       string        `json:"message"`        // 错误信息
+	ClientVersion string        `json:"client_version"` // 客户端请求的版本
+	Supported     ProtocolRange `json:"supported"`      // 服务端支持的版本范围
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+// 注册成功响应
+type CMD_REGISTER_RESPONSE struct {
+	ProtocolVersion string        `json:"protocol_version"` // 协商后的协议版本
+	Supported       ProtocolRange `json:"supported"`        // 服务端支持的版本范围
+}
+
+// 消息编码，通过WebSocket子协议协商
+const (
+	EncodingJSON    = "json"    // 默认编码
+	EncodingMsgpack = "msgpack" // MessagePack二进制编码
+)
+
+// Payload 延迟解码的消息数据，按连接的编码直接解码到具体命令结构
+type Payload interface {
+	Decode(v interface{}) error
+	Empty() bool
+}
+
+// ValuePayload 已解码的通用数据，用于协议适配器改写后的消息
+type ValuePayload struct {
+	Value interface{}
+}
+
+func (p ValuePayload) Decode(v interface{}) error {
+	data, err := json.Marshal(p.Value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (p ValuePayload) Empty() bool {
+	return p.Value == nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// 下发队列优先级，数值越大越先发送
+const (
+	QueuePriorityCosmetic  = iota // 灯光、表情等外观动作
+	QueuePriorityAction           // 其他动作
+	QueuePriorityMove             // 运动
+	QueuePriorityStop             // 停止
+	QueuePriorityEmergency        // 急停
+)
+
+// 优先级名称
+var QueuePriorityNames = [...]string{
+	QueuePriorityCosmetic:  "cosmetic",
+	QueuePriorityAction:    "action",
+	QueuePriorityMove:      "move",
+	QueuePriorityStop:      "stop",
+	QueuePriorityEmergency: "emergency",
+}
+
+// 命令下发队列配置
+type CommandQueueConfig struct {
+	MaxSize         int           `mapstructure:"max_size"`         // 每个机器人的队列长度上限
+	TTL             time.Duration `mapstructure:"ttl"`              // 命令有效期，超时未发送则丢弃
+	MoveTTL         time.Duration `mapstructure:"move_ttl"`         // Move命令有效期
+	WriteTimeout    time.Duration `mapstructure:"write_timeout"`    // 发送到机器人的写超时，超时后断开连接
+	CosmeticActions []string      `mapstructure:"cosmetic_actions"` // 外观类动作，最低优先级
+}
+
+// 队列中的命令
+type QueuedCommand struct {
+	ID         int64             `json:"id"`
+	Priority   string            `json:"priority"`
+	Command    CMD_CONTROL_ROBOT `json:"command"`
+	FromUCode  string            `json:"from_ucode,omitempty"`
+	FromType   ClientType        `json:"from_type,omitempty"`
+	EnqueuedAt time.Time         `json:"enqueued_at"`
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"` // 停止和急停命令不过期
+	Coalesced  int               `json:"coalesced"`            // 被合并的旧Move命令数
+}
+
+// 队列统计
+type CommandQueueStats struct {
+	Enqueued  int64 `json:"enqueued"`
+	Sent      int64 `json:"sent"`
+	Coalesced int64 `json:"coalesced"` // 被新Move取代
+	Expired   int64 `json:"expired"`   // 超过有效期
+	Dropped   int64 `json:"dropped"`   // 队列满或被停止命令清除
+	Failed    int64 `json:"failed"`    // 发送失败
+}
+
+// 机器人下发队列状态
+type CommandQueueStatus struct {
+	UCode      string            `json:"ucode"`
+	Length     int               `json:"length"`
+	Pending    []QueuedCommand   `json:"pending"`
+	Stats      CommandQueueStats `json:"stats"`
+	LastSentAt *time.Time        `json:"last_sent_at,omitempty"`
+}
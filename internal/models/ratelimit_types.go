@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// 违规处理方式
+const (
+	ViolationActionReply      = "reply"      // 回复错误并保持连接
+	ViolationActionDisconnect = "disconnect" // 窗口内违规次数达到上限后断开
+)
+
+// 违规类型
+const (
+	ViolationMalformed   = "malformed"    // 消息格式错误
+	ViolationRateLimited = "rate_limited" // 超出速率限制
+)
+
+// 令牌桶参数
+type RateLimit struct {
+	Rate  float64 `mapstructure:"rate" json:"rate"`   // 每秒补充的令牌数，<=0 表示不限速
+	Burst int     `mapstructure:"burst" json:"burst"` // 桶容量
+}
+
+// 单个命令的速率限制
+type CommandRateLimit struct {
+	Command   CommandType `mapstructure:"command" json:"command"`
+	RateLimit `mapstructure:",squash"`
+}
+
+// WebSocket消息策略配置
+type MessagePolicyConfig struct {
+	OnViolation     string             `mapstructure:"on_violation"`     // reply / disconnect
+	MaxViolations   int                `mapstructure:"max_violations"`   // disconnect 模式下的违规上限
+	ViolationWindow time.Duration      `mapstructure:"violation_window"` // 违规计数窗口
+	ClientLimit     RateLimit          `mapstructure:"client_limit"`     // 每个客户端的总速率
+	CommandLimits   []CommandRateLimit `mapstructure:"command_limits"`   // 每个客户端的单命令速率
+	Exempt          []CommandType      `mapstructure:"exempt"`           // 不限速的命令
+}
+
+// 客户端违规统计
+type ClientViolations struct {
+	UCode      string         `json:"ucode"`
+	Total      int64          `json:"total"`
+	Recent     int            `json:"recent"` // 当前窗口内的违规次数
+	ByKind     map[string]int `json:"by_kind"`
+	LastKind   string         `json:"last_kind"`
+	LastReason string         `json:"last_reason"`
+	LastAt     time.Time      `json:"last_at"`
+}
+
+// 违规指标
+type ViolationMetrics struct {
+	Total       int64              `json:"total"`
+	ByKind      map[string]int64   `json:"by_kind"`
+	ByCommand   map[string]int64   `json:"by_command"`
+	Disconnects int64              `json:"disconnects"`
+	Clients     []ClientViolations `json:"clients"`
+}
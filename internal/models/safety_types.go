@@ -0,0 +1,48 @@
+package models
+
+// 安全包络处理模式
+const (
+	SafetyModeClamp  = "clamp"  // 超限时裁剪
+	SafetyModeReject = "reject" // 超限时拒绝
+)
+
+// 安全包络，数值为0表示继承默认配置
+type SafetyEnvelope struct {
+	UCode                    string  `mapstructure:"ucode" json:"ucode,omitempty"`                                 // 机器人UCode (仅用于单机配置)
+	MaxLinearVelocity        float64 `mapstructure:"max_linear_velocity" json:"max_linear_velocity"`               // 最大线速度 (m/s)
+	MaxAngularVelocity       float64 `mapstructure:"max_angular_velocity" json:"max_angular_velocity"`             // 最大角速度 (rad/s)
+	MaxLinearAcceleration    float64 `mapstructure:"max_linear_acceleration" json:"max_linear_acceleration"`       // 最大线加速度 (m/s²)
+	MaxAngularAcceleration   float64 `mapstructure:"max_angular_acceleration" json:"max_angular_acceleration"`     // 最大角加速度 (rad/s²)
+	LowBatteryThreshold      float64 `mapstructure:"low_battery_threshold" json:"low_battery_threshold"`           // 低电量阈值 (%)
+	LowBatteryScale          float64 `mapstructure:"low_battery_scale" json:"low_battery_scale"`                   // 低电量时的限速比例
+	HighTemperatureThreshold float64 `mapstructure:"high_temperature_threshold" json:"high_temperature_threshold"` // 高温阈值 (°C)
+	HighTemperatureScale     float64 `mapstructure:"high_temperature_scale" json:"high_temperature_scale"`         // 高温时的限速比例
+	Mode                     string  `mapstructure:"mode" json:"mode"`                                             // 处理模式: clamp, reject
+}
+
+// 安全配置
+type SafetyConfig struct {
+	Default SafetyEnvelope   `mapstructure:"default"` // 默认包络
+	Robots  []SafetyEnvelope `mapstructure:"robots"`  // 单机覆盖
+}
+
+// 速度指令
+type VelocityCommand struct {
+	VX   float64 `json:"vx"`   // 前向速度 (m/s)
+	VY   float64 `json:"vy"`   // 侧向速度 (m/s)
+	VYaw float64 `json:"vyaw"` // 偏航角速度 (rad/s)
+}
+
+// 本次命令实际生效的安全限制
+type SafetyLimits struct {
+	MaxLinearVelocity      float64         `json:"max_linear_velocity"`      // 生效的最大线速度
+	MaxAngularVelocity     float64         `json:"max_angular_velocity"`     // 生效的最大角速度
+	MaxLinearAcceleration  float64         `json:"max_linear_acceleration"`  // 生效的最大线加速度
+	MaxAngularAcceleration float64         `json:"max_angular_acceleration"` // 生效的最大角加速度
+	Scale                  float64         `json:"scale"`                    // 降额比例
+	Reasons                []string        `json:"reasons,omitempty"`        // 降额原因: low_battery, high_temperature
+	Mode                   string          `json:"mode"`                     // 处理模式
+	Clamped                bool            `json:"clamped"`                  // 是否被裁剪
+	Requested              VelocityCommand `json:"requested"`                // 请求的速度
+	Applied                VelocityCommand `json:"applied"`                  // 实际下发的速度
+}
@@ -0,0 +1,150 @@
+package models
+
+import "time"
+
+// WebRTC信令命令，客户端通过服务端与Janus协商，不直接连接Janus
+const (
+	CMD_TYPE_WEBRTC_WATCH  CommandType = "CMD_WEBRTC_WATCH"  // 请求观看机器人视频，响应中返回SDP offer
+	CMD_TYPE_WEBRTC_ANSWER CommandType = "CMD_WEBRTC_ANSWER" // 提交SDP answer
+	CMD_TYPE_WEBRTC_ICE    CommandType = "CMD_WEBRTC_ICE"    // ICE候选，双向
+	CMD_TYPE_WEBRTC_STOP   CommandType = "CMD_WEBRTC_STOP"   // 停止观看
+	CMD_TYPE_WEBRTC_STATE  CommandType = "CMD_WEBRTC_STATE"  // 观看状态变化，服务端推送
+	CMD_TYPE_WEBRTC_PUSH   CommandType = "CMD_WEBRTC_PUSH"   // 推流地址变化，服务端推送给机器人
+	CMD_TYPE_WEBRTC_STATS  CommandType = "CMD_WEBRTC_STATS"  // 媒体统计，服务端定期推送给绑定的操作者
+)
+
+// 链路质量
+const (
+	MediaQualityGood     = "good"
+	MediaQualityFair     = "fair"
+	MediaQualityPoor     = "poor"
+	MediaQualityNoSignal = "no_signal" // 机器人未推流
+)
+
+// 观看状态
+const (
+	ViewerStateOffered = "offered" // 已下发offer，等待answer
+	ViewerStateStarted = "started" // 已提交answer
+	ViewerStateUp      = "webrtcup"
+	ViewerStateMedia   = "media"
+	ViewerStateHangup  = "hangup"
+	ViewerStateStopped = "stopped"
+)
+
+// SDP
+type JSEP struct {
+	Type string `json:"type"` // offer, answer
+	SDP  string `json:"sdp"`
+}
+
+// ICE候选
+type ICECandidate struct {
+	Candidate     string `json:"candidate,omitempty"`
+	SDPMid        string `json:"sdpMid,omitempty"`
+	SDPMLineIndex int    `json:"sdpMLineIndex"`
+	Completed     bool   `json:"completed,omitempty"` // 候选收集完成
+}
+
+// 观看请求
+type CMD_WEBRTC_WATCH struct {
+	UCode  string `json:"ucode"`            // 机器人UCode
+	Source string `json:"source,omitempty"` // 视频源，为空表示默认视频源
+}
+
+// 观看响应
+type CMD_WEBRTC_WATCH_RESPONSE struct {
+	UCode    string `json:"ucode"`     // 机器人UCode
+	Source   string `json:"source"`    // 视频源
+	StreamID int    `json:"stream_id"` // 挂载点ID
+	Offer    JSEP   `json:"offer"`     // Janus生成的SDP offer
+}
+
+// 提交answer
+type CMD_WEBRTC_ANSWER struct {
+	UCode  string `json:"ucode"`            // 机器人UCode
+	Source string `json:"source,omitempty"` // 视频源
+	Answer JSEP   `json:"answer"`           // SDP answer
+}
+
+// ICE候选
+type CMD_WEBRTC_ICE struct {
+	UCode     string        `json:"ucode"`            // 机器人UCode
+	Source    string        `json:"source,omitempty"` // 视频源
+	Candidate *ICECandidate `json:"candidate"`        // 为空或completed表示收集完成
+}
+
+// 停止观看
+type CMD_WEBRTC_STOP struct {
+	UCode  string `json:"ucode"`            // 机器人UCode
+	Source string `json:"source,omitempty"` // 视频源，为空表示默认视频源
+}
+
+// 观看状态推送
+type CMD_WEBRTC_STATE struct {
+	UCode     string `json:"ucode"`               // 机器人UCode
+	Source    string `json:"source"`              // 视频源
+	State     string `json:"state"`               // 状态
+	Media     string `json:"media,omitempty"`     // media事件的媒体类型
+	Receiving *bool  `json:"receiving,omitempty"` // media事件：Janus是否在收流
+	Reason    string `json:"reason,omitempty"`    // hangup原因
+}
+
+// 推流地址推送，机器人需改向新地址推流
+type CMD_WEBRTC_PUSH struct {
+	Source    string `json:"source"`               // 视频源
+	PushURL   string `json:"push_url"`             // 推流地址
+	RTPHost   string `json:"rtp_host"`             // 推流目标地址
+	VideoPort int    `json:"video_port"`           // 视频RTP端口
+	AudioPort int    `json:"audio_port,omitempty"` // 音频RTP端口
+	Codec     string `json:"codec"`                // 视频编码
+	Reason    string `json:"reason,omitempty"`     // 变化原因
+}
+
+// 观看者句柄的媒体统计，来自Janus Admin API
+type ViewerMediaStats struct {
+	Packets      int64   `json:"packets"`       // 累计发送包数
+	BytesLastSec int64   `json:"bytes_lastsec"` // 最近一秒发送字节数
+	Lost         int64   `json:"lost"`          // 对端报告的累计丢包
+	JitterMs     float64 `json:"jitter_ms"`     // 对端报告的抖动
+	RTTMs        int64   `json:"rtt_ms"`        // RTCP往返时延
+}
+
+// 媒体统计采样
+type MediaStatsSample struct {
+	Timestamp   int64   `json:"timestamp"`    // 采样时间戳
+	BitrateKbps float64 `json:"bitrate_kbps"` // 发往观看者的码率，多个观看者取最大值
+	PacketLoss  float64 `json:"packet_loss"`  // 采样周期内的丢包率(%)
+	JitterMs    float64 `json:"jitter_ms"`    // 抖动，多个观看者取最大值
+	RTTMs       int64   `json:"rtt_ms"`       // 往返时延，多个观看者取最大值
+	Viewers     int     `json:"viewers"`      // 观看者数量
+	AgeMs       int64   `json:"age_ms"`       // 距最近一次收到机器人RTP包的毫秒数，-1表示未知
+	Quality     string  `json:"quality"`      // 链路质量
+}
+
+// 流的媒体统计
+type StreamMediaStats struct {
+	UCode    string             `json:"ucode"`    // 机器人UCode
+	Source   string             `json:"source"`   // 视频源
+	Instance string             `json:"instance"` // Janus实例
+	StreamID int                `json:"stream_id"`
+	Latest   MediaStatsSample   `json:"latest"`            // 最近一次采样
+	History  []MediaStatsSample `json:"history,omitempty"` // 按时间顺序的采样
+}
+
+// 媒体统计推送
+type CMD_WEBRTC_STATS struct {
+	UCode   string             `json:"ucode"`   // 机器人UCode
+	Sources []StreamMediaStats `json:"sources"` // 各视频源的最近一次采样，不含历史
+}
+
+// 观看会话
+type ViewerSession struct {
+	ViewerUCode string    `json:"viewer_ucode"` // 观看者UCode
+	RobotUCode  string    `json:"robot_ucode"`  // 机器人UCode
+	Source      string    `json:"source"`       // 视频源
+	StreamID    int       `json:"stream_id"`    // 挂载点ID
+	Instance    string    `json:"instance"`     // Janus实例
+	HandleID    int64     `json:"handle_id"`    // Janus句柄ID
+	State       string    `json:"state"`        // 状态
+	StartedAt   time.Time `json:"started_at"`   // 开始时间
+}
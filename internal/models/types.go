@@ -1,14 +1,18 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 )
 
 // WebRTC播放地址响应
 type WebRTCPlayURLResponse struct {
-	Success bool     `json:"success"`
-	URLs    []string `json:"urls"` // 播放地址列表
-	Message string   `json:"message"`
+	Success bool               `json:"success"`
+	Code    ErrorCode          `json:"code,omitempty"`    // 错误码
+	URLs    []string           `json:"urls"`              // 播放地址列表
+	Sources []WebRTCSourceInfo `json:"sources,omitempty"` // 视频源
+	Message string             `json:"message"`
 }
 
 // 客户端类型
@@ -19,6 +23,8 @@ type CommandType string
 const (
 	ClientTypeRobot       ClientType    = "robot"    // 机器人
 	ClientTypeOperator    ClientType    = "operator" // 操作者
+	ClientTypeAdmin       ClientType    = "admin"    // 管理员
+	ClientTypeReferee     ClientType    = "referee"  // 裁判
 	WSMessageTypeResponse WSMessageType = "Response"
 	WSMessageTypeRequest  WSMessageType = "Request"
 )
@@ -31,6 +37,63 @@ type Client struct {
 	Connected  bool       `json:"connected"`   // 是否连接
 	LastSeen   time.Time  `json:"last_seen"`   // 最后活跃时间
 	RemoteAddr string     `json:"remote_addr"` // 远程地址
+
+	ProtocolVersion string              `json:"protocol_version"`       // 协商后的协议版本
+	Capabilities    *ClientCapabilities `json:"capabilities,omitempty"` // 注册时上报的能力清单
+	Latency         *LinkLatency        `json:"latency,omitempty"`      // 链路延迟，测得后才有值
+}
+
+// 默认视频源名称，注册时未指定视频源使用
+const DefaultVideoSource = "default"
+
+// 视频源
+type VideoSource struct {
+	Name  string `json:"name" mapstructure:"name"`             // 视频源名称: front, turret
+	Codec string `json:"codec,omitempty" mapstructure:"codec"` // 编码: h264, vp8
+}
+
+// 客户端能力清单
+type ClientCapabilities struct {
+	Model        string        `json:"model,omitempty" mapstructure:"model"`                 // 机器人型号
+	Firmware     string        `json:"firmware,omitempty" mapstructure:"firmware"`           // 固件版本
+	Actions      []string      `json:"actions,omitempty" mapstructure:"actions"`             // 支持的控制动作，为空表示按型号定义
+	Sensors      []string      `json:"sensors,omitempty" mapstructure:"sensors"`             // 传感器: armor, imu, lidar
+	VideoSources []VideoSource `json:"video_sources,omitempty" mapstructure:"video_sources"` // 视频源
+}
+
+// UnmarshalJSON 兼容旧客户端直接上报动作列表的格式
+func (c *ClientCapabilities) UnmarshalJSON(data []byte) error {
+	var actions []string
+	if err := json.Unmarshal(data, &actions); err == nil {
+		*c = ClientCapabilities{Actions: actions}
+		return nil
+	}
+
+	type manifest ClientCapabilities
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*c = ClientCapabilities(m)
+	return nil
+}
+
+// SupportsAction 是否支持指定动作(不区分大小写)，未声明动作列表时视为支持
+func (c *ClientCapabilities) SupportsAction(action string) bool {
+	if c == nil || len(c.Actions) == 0 {
+		return true
+	}
+	for _, supported := range c.Actions {
+		if strings.EqualFold(supported, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasVideo 是否有视频源
+func (c *ClientCapabilities) HasVideo() bool {
+	return c != nil && len(c.VideoSources) > 0
 }
 
 const (
@@ -41,6 +104,22 @@ const (
 	CMD_TYPE_CONTROL_ROBOT       CommandType = "CMD_CONTROL_ROBOT"       // 控制机器人
 	CMD_TYPE_REPORT_HIT_DATA     CommandType = "CMD_REPORT_HIT_DATA"     // 上报伤害数据
 	CMD_TYPE_UPDATE_LIFE_DATA    CommandType = "CMD_UPDATE_LIFE_DATA"    // 更新生命数据
+	CMD_TYPE_EMERGENCY_STOP      CommandType = "CMD_EMERGENCY_STOP"      // 急停
+	CMD_TYPE_CLEAR_EMERGENCY     CommandType = "CMD_CLEAR_EMERGENCY"     // 解除急停
+	CMD_TYPE_GEOFENCE_WARNING    CommandType = "CMD_GEOFENCE_WARNING"    // 电子围栏告警
+	CMD_TYPE_LINK_QUALITY        CommandType = "CMD_LINK_QUALITY"        // 链路质量通知
+)
+
+// 控制动作
+const (
+	ControlActionMove = "Move" // 移动
+	ControlActionStop = "stop" // 停止
+)
+
+// 控制命令优先级
+const (
+	CommandPriorityNormal    = 0   // 普通
+	CommandPriorityEmergency = 100 // 急停
 )
 
 // WebSocket消息
@@ -54,16 +133,67 @@ type WebSocketMessage struct {
 	Data       interface{}   `json:"data"`        // 数据
 }
 
+// 注册请求
+type CMD_REGISTER struct {
+	Name         string              `json:"name,omitempty"`         // 客户端名称
+	Capabilities *ClientCapabilities `json:"capabilities,omitempty"` // 能力清单
+}
+
 type CMD_BIND_ROBOT struct {
 	UCode string `json:"ucode"` // 机器人UCode
 }
 
+// 绑定响应，附带机器人的视频播放信息
+type CMD_BIND_ROBOT_RESPONSE struct {
+	UCode   string             `json:"ucode"`             // 机器人UCode
+	URLs    []string           `json:"urls,omitempty"`    // 播放地址列表
+	Sources []WebRTCSourceInfo `json:"sources,omitempty"` // 视频源，通过CMD_WEBRTC_WATCH观看
+}
+
 type CMD_CONTROL_ROBOT struct {
 	Action    string            `json:"action"`    // 动作: move, stop, reset, etc.
 	ParamMaps map[string]string `json:"params"`    // 参数: 动作参数
+	Priority  int               `json:"-"`         // 优先级，仅由服务端设置(急停、越界停止)
 	Timestamp int64             `json:"timestamp"` // 时间戳
 }
 
+// 急停请求，UCodes为空表示全部在线机器人
+type CMD_EMERGENCY_STOP struct {
+	UCodes []string `json:"ucodes"` // 机器人UCode列表
+	Reason string   `json:"reason"` // 急停原因
+}
+
+// 解除急停请求，UCodes为空表示全部已急停机器人
+type CMD_CLEAR_EMERGENCY struct {
+	UCodes []string `json:"ucodes"` // 机器人UCode列表
+}
+
+// 急停REST请求
+type EmergencyStopRequest struct {
+	UCodes   []string `json:"ucodes"`   // 机器人UCode列表，为空表示全部
+	Reason   string   `json:"reason"`   // 急停原因
+	Operator string   `json:"operator"` // 操作人
+}
+
+// 急停结果
+type EmergencyStopResult struct {
+	Records []EmergencyStopRecord `json:"records"`          // 锁定/解除记录
+	Stopped []string              `json:"stopped"`          // 已下发停止命令的机器人
+	Offline []string              `json:"offline"`          // 不在线的机器人，已锁定但未下发
+	Failed  map[string]string     `json:"failed,omitempty"` // 下发失败的机器人
+}
+
+// 急停记录
+type EmergencyStopRecord struct {
+	UCode       string    `json:"ucode"`                // 机器人UCode
+	Active      bool      `json:"active"`               // 是否处于急停锁定
+	Reason      string    `json:"reason"`               // 急停原因
+	TriggeredBy string    `json:"triggered_by"`         // 触发者
+	TriggeredAt time.Time `json:"triggered_at"`         // 触发时间
+	ClearedBy   string    `json:"cleared_by,omitempty"` // 解除者
+	ClearedAt   time.Time `json:"cleared_at,omitempty"` // 解除时间
+}
+
 type CMD_REPORT_HIT_DATA struct {
 	HitPress  int64 `json:"hit_press"` // 伤害值
 	Timestamp int64 `json:"timestamp"` // 时间戳
@@ -76,9 +206,11 @@ type CMD_UPDATE_LIFE_DATA struct {
 
 // 命令响应
 type CMD_RESPONSE struct {
-	Success   bool   `json:"success"`
-	Message   string `json:"message,omitempty"`
-	Timestamp int64  `json:"timestamp"`
+	Success   bool        `json:"success"`
+	Code      ErrorCode   `json:"code,omitempty"` // 失败时的错误码
+	Message   string      `json:"message,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
 // 机器人状态
@@ -92,8 +224,32 @@ type RobotState struct {
 	ErrorMessage    string     `json:"error_message"`    // 错误信息
 }
 
+// 上游机器人桥接配置，用于自身提供WebSocket服务的机器人，由服务端主动连接
+type RobotConfig struct {
+	WebSocketURL      string              `mapstructure:"websocket_url"`      // 机器人WebSocket服务地址
+	UCode             string              `mapstructure:"ucode"`              // 接入后使用的机器人UCode，留空不启用桥接
+	Name              string              `mapstructure:"name"`               // 机器人名称
+	Capabilities      *ClientCapabilities `mapstructure:"capabilities"`       // 能力清单，等同于注册时上报
+	ControlTimeout    time.Duration       `mapstructure:"control_timeout"`    // 控制命令的写超时，超时后断开重连
+	MaxRetries        int                 `mapstructure:"max_retries"`        // 连续重连失败的次数上限，0表示一直重试
+	HeartbeatInterval time.Duration       `mapstructure:"heartbeat_interval"` // 心跳间隔
+	InitialBackoff    time.Duration       `mapstructure:"initial_backoff"`    // 首次重连等待时间，之后指数增长
+	MaxBackoff        time.Duration       `mapstructure:"max_backoff"`        // 重连等待时间上限
+}
+
+// 上游连接状态
+const (
+	RobotLinkDisabled     = "disabled"     // 未启用桥接
+	RobotLinkConnecting   = "connecting"   // 正在连接或等待重连
+	RobotLinkConnected    = "connected"    // 已连接
+	RobotLinkDisconnected = "disconnected" // 已断开，重连次数用尽或已停止
+)
+
 // 连接状态
 type ConnectionStatus struct {
+	UCode           string    `json:"ucode,omitempty"` // 上游机器人UCode
+	URL             string    `json:"url,omitempty"`   // 上游机器人地址
+	State           string    `json:"state"`           // 连接状态
 	Connected       bool      `json:"connected"`
 	LastHeartbeat   time.Time `json:"last_heartbeat"`
 	Latency         int64     `json:"latency_ms"`      // 延迟 (毫秒)
@@ -101,6 +257,9 @@ type ConnectionStatus struct {
 	TotalCommands   int64     `json:"total_commands"`  // 总命令数
 	FailedCommands  int64     `json:"failed_commands"` // 失败命令数
 	LastCommandTime time.Time `json:"last_command_time"`
+	Reconnects      int64     `json:"reconnects"`           // 重连成功次数
+	Retries         int       `json:"retries"`              // 当前连续失败次数
+	LastError       string    `json:"last_error,omitempty"` // 最近一次连接错误
 }
 
 // 系统状态
@@ -120,33 +279,67 @@ type JanusSession struct {
 	URL       string `json:"url"`
 }
 
-// 机器人配置
-type RobotConfig struct {
-	WebSocketURL string `json:"websocket_url"`
-	Timeout      int    `json:"timeout_seconds"`
-	MaxRetries   int    `json:"max_retries"`
-}
-
 // WebRTC流信息
 type WebRTCStream struct {
-	UCode     string `json:"ucode"`      // 机器人唯一标识
-	StreamID  int    `json:"stream_id"`  // 流ID
-	SessionID int64  `json:"session_id"` // Janus会话ID
-	HandleID  int64  `json:"handle_id"`  // Janus句柄ID
-	PlayURL   string `json:"play_url"`   // 播放地址
-	PushURL   string `json:"push_url"`   // 推流地址
-	Status    string `json:"status"`     // 状态: active, inactive, error
-	CreatedAt int64  `json:"created_at"` // 创建时间戳
+	UCode       string `json:"ucode"`                // 机器人唯一标识
+	Source      string `json:"source"`               // 视频源名称
+	StreamID    int    `json:"stream_id"`            // Janus挂载点ID
+	Instance    string `json:"instance"`             // 所在Janus实例
+	SessionID   int64  `json:"session_id"`           // 管理挂载点的Janus会话ID
+	HandleID    int64  `json:"handle_id"`            // 管理挂载点的Janus句柄ID
+	Description string `json:"description"`          // 挂载点描述
+	RTPHost     string `json:"rtp_host"`             // 推流目标地址
+	VideoPort   int    `json:"video_port"`           // 视频RTP端口
+	AudioPort   int    `json:"audio_port,omitempty"` // 音频RTP端口
+	VideoCodec  string `json:"video_codec"`          // 视频编码
+	PlayURL     string `json:"play_url"`             // 播放地址
+	PushURL     string `json:"push_url"`             // 推流地址
+	Status      string `json:"status"`               // 状态: active, inactive, error
+	CreatedAt   int64  `json:"created_at"`           // 创建时间戳
+
+	InactiveSince int64 `json:"inactive_since,omitempty"` // 停用时间戳，超过宽限期后销毁挂载点
+}
+
+// SourceInfo 视频源播放信息
+func (s *WebRTCStream) SourceInfo() WebRTCSourceInfo {
+	return WebRTCSourceInfo{
+		Name:     s.Source,
+		StreamID: s.StreamID,
+		Codec:    s.VideoCodec,
+		PlayURL:  s.PlayURL,
+		Status:   s.Status,
+	}
+}
+
+// 视频源播放信息
+type WebRTCSourceInfo struct {
+	Name     string `json:"name"`      // 视频源名称
+	StreamID int    `json:"stream_id"` // Janus挂载点ID
+	Codec    string `json:"codec"`     // 视频编码
+	PlayURL  string `json:"play_url"`  // 播放地址
+	Status   string `json:"status"`    // 状态
 }
 
 // WebRTC注册请求
 type WebRTCRegisterRequest struct {
-	UCode string `json:"ucode"` // 机器人唯一标识
+	UCode  string `json:"ucode"`            // 机器人唯一标识
+	Source string `json:"source,omitempty"` // 视频源名称，为空表示默认视频源
+	Codec  string `json:"codec,omitempty"`  // 视频编码，为空使用配置
 }
 
 // WebRTC注册响应
 type WebRTCRegisterResponse struct {
 	Success bool          `json:"success"`
+	Code    ErrorCode     `json:"code,omitempty"` // 错误码
 	Stream  *WebRTCStream `json:"stream,omitempty"`
 	Message string        `json:"message"`
 }
+
+// 批量注册中单个UCode的结果
+type WebRTCBatchRegisterItem struct {
+	UCode   string        `json:"ucode"`
+	Success bool          `json:"success"`
+	Code    ErrorCode     `json:"code,omitempty"`  // 错误码
+	Error   string        `json:"error,omitempty"` // 失败原因
+	Stream  *WebRTCStream `json:"stream,omitempty"`
+}
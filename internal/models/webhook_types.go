@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook事件类型
+const (
+	WebhookEventRobotConnected     = "robot_connected"      // 机器人上线
+	WebhookEventRobotDisconnected  = "robot_disconnected"   // 机器人下线
+	WebhookEventRobotError         = "robot_error"          // 机器人上报错误状态
+	WebhookEventRobotEmergencyStop = "robot_emergency_stop" // 机器人上报急停状态
+	WebhookEventBatteryLow         = "battery_low"          // 电量低于阈值
+	WebhookEventBatteryRecovered   = "battery_recovered"    // 电量恢复到阈值以上
+	WebhookEventGameEnd            = "game_end"             // 游戏结束
+)
+
+// Webhook目标
+type WebhookEndpoint struct {
+	URL    string   `mapstructure:"url" json:"url"`       // 推送地址
+	Secret string   `mapstructure:"secret" json:"-"`      // HMAC签名密钥
+	Events []string `mapstructure:"events" json:"events"` // 订阅的事件，为空表示全部
+}
+
+// Webhook配置
+type WebhookConfig struct {
+	Endpoints        []WebhookEndpoint `mapstructure:"endpoints"`         // 推送目标
+	OutboxPath       string            `mapstructure:"outbox_path"`       // 待发送队列持久化文件
+	BatteryThreshold float64           `mapstructure:"battery_threshold"` // 电量告警阈值 (%)
+	MaxRetries       int               `mapstructure:"max_retries"`       // 最大重试次数
+	InitialBackoff   time.Duration     `mapstructure:"initial_backoff"`   // 首次重试间隔
+	MaxBackoff       time.Duration     `mapstructure:"max_backoff"`       // 最大重试间隔
+	Timeout          time.Duration     `mapstructure:"timeout"`           // 单次请求超时
+}
+
+// Webhook推送内容
+type WebhookPayload struct {
+	ID        string      `json:"id"`              // 事件ID
+	Event     string      `json:"event"`           // 事件类型
+	UCode     string      `json:"ucode,omitempty"` // 相关UCode
+	Timestamp time.Time   `json:"timestamp"`       // 事件时间
+	Data      interface{} `json:"data,omitempty"`  // 事件数据
+}
+
+// Webhook待发送记录
+type WebhookDelivery struct {
+	ID          string          `json:"id"`                   // 事件ID
+	URL         string          `json:"url"`                  // 推送地址
+	Event       string          `json:"event"`                // 事件类型
+	Body        json.RawMessage `json:"body,omitempty"`       // 推送内容
+	Attempts    int             `json:"attempts"`             // 已尝试次数
+	NextAttempt time.Time       `json:"next_attempt"`         // 下次尝试时间
+	CreatedAt   time.Time       `json:"created_at"`           // 创建时间
+	LastError   string          `json:"last_error,omitempty"` // 最近一次错误
+}
+
+// Webhook队列日志操作
+const (
+	WebhookJournalAdd    = "add"    // 新增记录
+	WebhookJournalUpdate = "update" // 发送失败，更新重试状态 (不含推送内容)
+	WebhookJournalRemove = "remove" // 发送成功或放弃
+)
+
+// Webhook队列日志条目，队列文件按行追加
+type WebhookJournalEntry struct {
+	Op       string          `json:"op"`
+	Delivery WebhookDelivery `json:"delivery"`
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 内置默认型号
+const DefaultRobotModel = "default"
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// 内置动作定义，与 tools/test_robot_real.py 保持一致
+func DefaultActionSchemas() []models.ActionSchema {
+	return []models.ActionSchema{
+		{
+			Name:        models.ControlActionMove,
+			Description: "Body velocity command",
+			// 范围覆盖客户端摇杆的输出，实际速度由安全包络裁剪
+			Params: []models.ActionParamSchema{
+				{Name: "vx", Type: models.ActionParamTypeFloat, Required: true, Min: floatPtr(-2.5), Max: floatPtr(3.8), Description: "Forward velocity (m/s)"},
+				{Name: "vy", Type: models.ActionParamTypeFloat, Min: floatPtr(-1.0), Max: floatPtr(1.0), Description: "Lateral velocity (m/s)"},
+				{Name: "vyaw", Type: models.ActionParamTypeFloat, Min: floatPtr(-2.0), Max: floatPtr(2.0), Description: "Yaw rate (rad/s)"},
+			},
+		},
+		{
+			Name:        models.ControlActionStop,
+			Description: "Stop all motion",
+			Params: []models.ActionParamSchema{
+				{Name: "reason", Type: models.ActionParamTypeString},
+			},
+		},
+		{Name: "shoot", Description: "Fire the turret", Params: []models.ActionParamSchema{}},
+		{Name: "raise", Description: "Stand up", Params: []models.ActionParamSchema{}},
+		{Name: "lower", Description: "Lie down", Params: []models.ActionParamSchema{}},
+	}
+}
+
+// ActionRegistry 控制动作定义注册表
+type ActionRegistry struct {
+	defaultModel string
+	models       map[string]map[string]models.ActionSchema // 型号 -> 动作名 -> 定义
+}
+
+// NewActionRegistry 创建动作注册表，未配置任何型号时使用内置定义
+func NewActionRegistry(config models.ActionRegistryConfig) *ActionRegistry {
+	registry := &ActionRegistry{
+		defaultModel: strings.ToLower(config.DefaultModel),
+		models:       make(map[string]map[string]models.ActionSchema),
+	}
+
+	for model, actions := range config.Models {
+		registry.setModel(model, actions)
+	}
+
+	if len(registry.models) == 0 {
+		registry.setModel(DefaultRobotModel, DefaultActionSchemas())
+	}
+
+	if _, exists := registry.models[registry.defaultModel]; !exists {
+		if registry.defaultModel != "" {
+			log.Warn().Str("model", registry.defaultModel).Msg("Default robot model not configured, falling back")
+		}
+		registry.defaultModel = registry.firstModel()
+	}
+
+	log.Info().
+		Strs("models", registry.GetModels()).
+		Str("default_model", registry.defaultModel).
+		Msg("Control action registry loaded")
+
+	return registry
+}
+
+func (r *ActionRegistry) setModel(model string, actions []models.ActionSchema) {
+	schemas := make(map[string]models.ActionSchema, len(actions))
+	for _, action := range actions {
+		for i, param := range action.Params {
+			if param.Type == "" {
+				action.Params[i].Type = models.ActionParamTypeString
+			}
+		}
+		schemas[action.Name] = action
+	}
+	r.models[strings.ToLower(model)] = schemas
+}
+
+func (r *ActionRegistry) firstModel() string {
+	names := make([]string, 0, len(r.models))
+	for name := range r.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// 查找型号对应的动作定义，未知型号使用默认型号
+func (r *ActionRegistry) resolve(model string) (string, map[string]models.ActionSchema) {
+	model = strings.ToLower(model)
+	if schemas, exists := r.models[model]; exists {
+		return model, schemas
+	}
+	return r.defaultModel, r.models[r.defaultModel]
+}
+
+// GetModels 获取所有型号
+func (r *ActionRegistry) GetModels() []string {
+	names := make([]string, 0, len(r.models))
+	for name := range r.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetActions 获取型号支持的动作，返回实际使用的型号
+func (r *ActionRegistry) GetActions(model string) (string, []models.ActionSchema) {
+	resolved, schemas := r.resolve(model)
+	actions := make([]models.ActionSchema, 0, len(schemas))
+	for _, schema := range schemas {
+		actions = append(actions, schema)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].Name < actions[j].Name
+	})
+	return resolved, actions
+}
+
+// Validate 按型号校验控制命令
+func (r *ActionRegistry) Validate(model string, command *models.CMD_CONTROL_ROBOT) error {
+	resolved, schemas := r.resolve(model)
+
+	if command.Action == "" {
+		return models.NewError(models.ErrorCodeInvalidCommand, "action is required")
+	}
+
+	schema, exists := schemas[command.Action]
+	if !exists {
+		supported := make([]string, 0, len(schemas))
+		for name := range schemas {
+			supported = append(supported, name)
+		}
+		sort.Strings(supported)
+		return models.Errorf(models.ErrorCodeUnsupportedAction, "unsupported action %q for model %s (supported: %s)",
+			command.Action, resolved, strings.Join(supported, ", "))
+	}
+
+	known := make(map[string]models.ActionParamSchema, len(schema.Params))
+	for _, param := range schema.Params {
+		known[param.Name] = param
+	}
+
+	// 检查未定义的参数
+	for name := range command.ParamMaps {
+		if _, ok := known[name]; !ok {
+			allowed := make([]string, 0, len(schema.Params))
+			for _, param := range schema.Params {
+				allowed = append(allowed, param.Name)
+			}
+			return models.Errorf(models.ErrorCodeInvalidCommand, "action %q: unknown parameter %q (allowed: %s)",
+				command.Action, name, strings.Join(allowed, ", "))
+		}
+	}
+
+	// 逐个校验参数
+	for _, param := range schema.Params {
+		value, present := command.ParamMaps[param.Name]
+		if !present {
+			if param.Required {
+				return models.Errorf(models.ErrorCodeInvalidCommand, "action %q: missing required parameter %q", command.Action, param.Name)
+			}
+			continue
+		}
+		if err := validateParam(param, value); err != nil {
+			return models.Errorf(models.ErrorCodeInvalidCommand, "action %q: parameter %q %s", command.Action, param.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// 校验单个参数值
+func validateParam(param models.ActionParamSchema, value string) error {
+	switch param.Type {
+	case models.ActionParamTypeFloat, models.ActionParamTypeInt:
+		var number float64
+		if param.Type == models.ActionParamTypeInt {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("must be an integer, got %q", value)
+			}
+			number = float64(n)
+		} else {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return fmt.Errorf("must be a number, got %q", value)
+			}
+			number = f
+		}
+		if param.Min != nil && number < *param.Min {
+			return fmt.Errorf("must be >= %g, got %s", *param.Min, value)
+		}
+		if param.Max != nil && number > *param.Max {
+			return fmt.Errorf("must be <= %g, got %s", *param.Max, value)
+		}
+	case models.ActionParamTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("must be a boolean, got %q", value)
+		}
+	case models.ActionParamTypeString:
+		if len(param.Enum) > 0 {
+			for _, option := range param.Enum {
+				if value == option {
+					return nil
+				}
+			}
+			return fmt.Errorf("must be one of [%s], got %q", strings.Join(param.Enum, ", "), value)
+		}
+	default:
+		return fmt.Errorf("has unsupported schema type %q", param.Type)
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 下发队列默认值
+const (
+	DefaultCommandQueueSize    = 64
+	DefaultCommandTTL          = 2 * time.Second
+	DefaultMoveCommandTTL      = 500 * time.Millisecond
+	DefaultCommandWriteTimeout = time.Second
+)
+
+// CommandSender 实际发送命令到机器人
+type CommandSender func(command models.QueuedCommand) error
+
+type queueItem struct {
+	level   int
+	command models.QueuedCommand
+}
+
+// 单个机器人的下发队列
+type robotQueue struct {
+	items      []*queueItem // 按优先级从高到低，同优先级按入队顺序
+	send       CommandSender
+	signal     chan struct{}
+	done       chan struct{}
+	stats      models.CommandQueueStats
+	lastSentAt time.Time
+}
+
+// CommandQueueService 机器人控制命令下发队列
+type CommandQueueService struct {
+	mutex        sync.Mutex
+	maxSize      int
+	ttl          time.Duration
+	moveTTL      time.Duration
+	writeTimeout time.Duration
+	cosmetic     map[string]bool
+	queues       map[string]*robotQueue
+	nextID       int64
+}
+
+// NewCommandQueueService 创建下发队列服务
+func NewCommandQueueService(config models.CommandQueueConfig) *CommandQueueService {
+	maxSize := config.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultCommandQueueSize
+	}
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = DefaultCommandTTL
+	}
+	moveTTL := config.MoveTTL
+	if moveTTL <= 0 {
+		moveTTL = DefaultMoveCommandTTL
+	}
+	writeTimeout := config.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultCommandWriteTimeout
+	}
+
+	cosmetic := make(map[string]bool, len(config.CosmeticActions))
+	for _, action := range config.CosmeticActions {
+		cosmetic[strings.ToLower(action)] = true
+	}
+
+	return &CommandQueueService{
+		maxSize:      maxSize,
+		ttl:          ttl,
+		moveTTL:      moveTTL,
+		writeTimeout: writeTimeout,
+		cosmetic:     cosmetic,
+		queues:       make(map[string]*robotQueue),
+	}
+}
+
+// WriteTimeout 发送到机器人的写超时，避免卡住的连接阻塞后续的急停和停止命令
+func (s *CommandQueueService) WriteTimeout() time.Duration {
+	return s.writeTimeout
+}
+
+// 命令的队列优先级
+func (s *CommandQueueService) classify(command models.CMD_CONTROL_ROBOT) int {
+	switch {
+	case command.Priority >= models.CommandPriorityEmergency:
+		return models.QueuePriorityEmergency
+	case strings.EqualFold(command.Action, models.ControlActionStop):
+		return models.QueuePriorityStop
+	case command.Action == models.ControlActionMove:
+		return models.QueuePriorityMove
+	case s.cosmetic[strings.ToLower(command.Action)]:
+		return models.QueuePriorityCosmetic
+	default:
+		return models.QueuePriorityAction
+	}
+}
+
+// Open 机器人上线时创建队列并启动发送协程
+func (s *CommandQueueService) Open(ucode string, send CommandSender) {
+	q := &robotQueue{
+		send:   send,
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	if old, exists := s.queues[ucode]; exists {
+		close(old.done)
+	}
+	s.queues[ucode] = q
+	s.mutex.Unlock()
+
+	go s.run(ucode, q)
+}
+
+// Close 机器人下线时停止发送并丢弃未发送的命令
+func (s *CommandQueueService) Close(ucode string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	q, exists := s.queues[ucode]
+	if !exists {
+		return
+	}
+	if len(q.items) > 0 {
+		log.Info().Str("ucode", ucode).Int("pending", len(q.items)).Msg("Dropping queued commands for disconnected robot")
+	}
+	close(q.done)
+	delete(s.queues, ucode)
+}
+
+// Enqueue 命令入队，返回入队后的命令
+func (s *CommandQueueService) Enqueue(ucode string, from *models.Client, command models.CMD_CONTROL_ROBOT) (models.QueuedCommand, error) {
+	level := s.classify(command)
+	now := time.Now()
+	var expiresAt *time.Time
+	if level < models.QueuePriorityStop {
+		ttl := s.ttl
+		if level == models.QueuePriorityMove {
+			ttl = s.moveTTL
+		}
+		expires := now.Add(ttl)
+		expiresAt = &expires
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	q, exists := s.queues[ucode]
+	if !exists {
+		return models.QueuedCommand{}, models.NewError(models.ErrorCodeRobotOffline, "target robot not connected")
+	}
+
+	s.nextID++
+	queued := models.QueuedCommand{
+		ID:         s.nextID,
+		Priority:   models.QueuePriorityNames[level],
+		Command:    command,
+		EnqueuedAt: now,
+		ExpiresAt:  expiresAt,
+	}
+	if from != nil {
+		queued.FromUCode = from.UCode
+		queued.FromType = from.ClientType
+	}
+	q.stats.Enqueued++
+
+	switch {
+	case level == models.QueuePriorityEmergency:
+		// 急停清除所有低优先级命令
+		q.drop(func(item *queueItem) bool { return item.level < models.QueuePriorityEmergency })
+	case level == models.QueuePriorityStop:
+		// 停止命令使未发送的Move失效
+		q.drop(func(item *queueItem) bool { return item.level == models.QueuePriorityMove })
+	case level == models.QueuePriorityMove:
+		// 合并未发送的Move，只保留最新的
+		for _, item := range q.items {
+			if item.level == models.QueuePriorityMove {
+				queued.Coalesced = item.command.Coalesced + 1
+				item.command = queued
+				q.stats.Coalesced++
+				q.notify()
+				return queued, nil
+			}
+		}
+	}
+
+	if len(q.items) >= s.maxSize {
+		// 队列满时挤掉优先级最低且更低的命令
+		last := q.items[len(q.items)-1]
+		if last.level >= level {
+			q.stats.Dropped++
+			return queued, models.Errorf(models.ErrorCodeQueueFull, "command queue for robot %s is full (%d)", ucode, s.maxSize)
+		}
+		q.items = q.items[:len(q.items)-1]
+		q.stats.Dropped++
+	}
+
+	// 插入到同优先级命令之后
+	index := len(q.items)
+	for i, item := range q.items {
+		if item.level < level {
+			index = i
+			break
+		}
+	}
+	q.items = append(q.items, nil)
+	copy(q.items[index+1:], q.items[index:])
+	q.items[index] = &queueItem{level: level, command: queued}
+
+	q.notify()
+	return queued, nil
+}
+
+// 删除满足条件的命令
+func (q *robotQueue) drop(match func(item *queueItem) bool) {
+	kept := q.items[:0]
+	for _, item := range q.items {
+		if match(item) {
+			q.stats.Dropped++
+			continue
+		}
+		kept = append(kept, item)
+	}
+	q.items = kept
+}
+
+// 唤醒发送协程
+func (q *robotQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// 发送协程
+func (s *CommandQueueService) run(ucode string, q *robotQueue) {
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-q.signal:
+		}
+
+		for {
+			command, ok := s.next(ucode, q)
+			if !ok {
+				break
+			}
+			err := q.send(command)
+
+			s.mutex.Lock()
+			if err != nil {
+				q.stats.Failed++
+			} else {
+				q.stats.Sent++
+				q.lastSentAt = time.Now()
+			}
+			s.mutex.Unlock()
+
+			if err != nil {
+				log.Error().Err(err).Str("ucode", ucode).Int64("id", command.ID).Str("action", command.Command.Action).Msg("Failed to send queued command")
+			}
+		}
+	}
+}
+
+// 取出下一条未过期的命令
+func (s *CommandQueueService) next(ucode string, q *robotQueue) (models.QueuedCommand, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for len(q.items) > 0 {
+		select {
+		case <-q.done:
+			return models.QueuedCommand{}, false
+		default:
+		}
+
+		item := q.items[0]
+		q.items = q.items[1:]
+		if item.command.ExpiresAt != nil && now.After(*item.command.ExpiresAt) {
+			q.stats.Expired++
+			log.Debug().Str("ucode", ucode).Int64("id", item.command.ID).Str("action", item.command.Command.Action).Msg("Queued command expired")
+			continue
+		}
+		return item.command, true
+	}
+	return models.QueuedCommand{}, false
+}
+
+// 队列状态，调用方持有锁
+func (q *robotQueue) status(ucode string) models.CommandQueueStatus {
+	status := models.CommandQueueStatus{
+		UCode:   ucode,
+		Length:  len(q.items),
+		Pending: make([]models.QueuedCommand, 0, len(q.items)),
+		Stats:   q.stats,
+	}
+	for _, item := range q.items {
+		status.Pending = append(status.Pending, item.command)
+	}
+	if !q.lastSentAt.IsZero() {
+		lastSentAt := q.lastSentAt
+		status.LastSentAt = &lastSentAt
+	}
+	return status
+}
+
+// GetStatus 获取机器人的下发队列状态
+func (s *CommandQueueService) GetStatus(ucode string) (models.CommandQueueStatus, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	q, exists := s.queues[ucode]
+	if !exists {
+		return models.CommandQueueStatus{}, false
+	}
+	return q.status(ucode), true
+}
+
+// GetStatuses 获取所有机器人的下发队列状态
+func (s *CommandQueueService) GetStatuses() []models.CommandQueueStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	statuses := make([]models.CommandQueueStatus, 0, len(s.queues))
+	for ucode, q := range s.queues {
+		statuses = append(statuses, q.status(ucode))
+	}
+	return statuses
+}
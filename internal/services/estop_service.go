@@ -0,0 +1,154 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 急停历史保留条数
+const maxEmergencyStopHistory = 500
+
+// EmergencyStopService 急停锁定管理
+type EmergencyStopService struct {
+	mutex sync.RWMutex
+
+	// 当前处于锁定状态的机器人
+	active map[string]*models.EmergencyStopRecord
+
+	// 已解除的急停记录
+	history []models.EmergencyStopRecord
+
+	hub *EventHub
+}
+
+// NewEmergencyStopService 创建急停服务
+func NewEmergencyStopService(hub *EventHub) *EmergencyStopService {
+	return &EmergencyStopService{
+		active:  make(map[string]*models.EmergencyStopRecord),
+		history: make([]models.EmergencyStopRecord, 0),
+		hub:     hub,
+	}
+}
+
+// Trigger 锁定机器人，已锁定的机器人保留原始记录
+func (s *EmergencyStopService) Trigger(ucodes []string, triggeredBy, reason string) []models.EmergencyStopRecord {
+	s.mutex.Lock()
+	now := time.Now()
+	records := make([]models.EmergencyStopRecord, 0, len(ucodes))
+	for _, ucode := range ucodes {
+		record, exists := s.active[ucode]
+		if !exists {
+			record = &models.EmergencyStopRecord{
+				UCode:       ucode,
+				Active:      true,
+				Reason:      reason,
+				TriggeredBy: triggeredBy,
+				TriggeredAt: now,
+			}
+			s.active[ucode] = record
+		}
+		records = append(records, *record)
+	}
+	s.mutex.Unlock()
+
+	for _, record := range records {
+		s.hub.Publish(TopicFleet, models.Event{
+			Type:  models.EventTypeEmergencyStop,
+			UCode: record.UCode,
+			Data:  record,
+		})
+	}
+
+	log.Warn().
+		Strs("ucodes", ucodes).
+		Str("triggered_by", triggeredBy).
+		Str("reason", reason).
+		Msg("Emergency stop triggered")
+
+	return records
+}
+
+// Clear 解除锁定，ucodes为空时解除全部
+func (s *EmergencyStopService) Clear(ucodes []string, clearedBy string) []models.EmergencyStopRecord {
+	s.mutex.Lock()
+	if len(ucodes) == 0 {
+		for ucode := range s.active {
+			ucodes = append(ucodes, ucode)
+		}
+	}
+
+	now := time.Now()
+	records := make([]models.EmergencyStopRecord, 0, len(ucodes))
+	for _, ucode := range ucodes {
+		record, exists := s.active[ucode]
+		if !exists {
+			continue
+		}
+		record.Active = false
+		record.ClearedBy = clearedBy
+		record.ClearedAt = now
+		delete(s.active, ucode)
+
+		s.history = append(s.history, *record)
+		records = append(records, *record)
+	}
+	if len(s.history) > maxEmergencyStopHistory {
+		s.history = s.history[len(s.history)-maxEmergencyStopHistory:]
+	}
+	s.mutex.Unlock()
+
+	for _, record := range records {
+		s.hub.Publish(TopicFleet, models.Event{
+			Type:  models.EventTypeEmergencyCleared,
+			UCode: record.UCode,
+			Data:  record,
+		})
+	}
+
+	if len(records) > 0 {
+		log.Info().
+			Int("count", len(records)).
+			Str("cleared_by", clearedBy).
+			Msg("Emergency stop cleared")
+	}
+
+	return records
+}
+
+// IsLatched 机器人是否处于急停锁定
+func (s *EmergencyStopService) IsLatched(ucode string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, exists := s.active[ucode]
+	return exists
+}
+
+// GetActive 获取当前锁定记录
+func (s *EmergencyStopService) GetActive() []models.EmergencyStopRecord {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]models.EmergencyStopRecord, 0, len(s.active))
+	for _, record := range s.active {
+		result = append(result, *record)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TriggeredAt.Before(result[j].TriggeredAt)
+	})
+	return result
+}
+
+// GetHistory 获取已解除的急停记录
+func (s *EmergencyStopService) GetHistory() []models.EmergencyStopRecord {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]models.EmergencyStopRecord, len(s.history))
+	copy(result, s.history)
+	return result
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 事件主题
+const (
+	TopicFleet = "fleet" // 机器人上下线、状态、绑定
+	TopicGame  = "game"  // 游戏事件
+)
+
+// 默认订阅缓冲区大小
+const DefaultSubscriberBuffer = 64
+
+// ClientTopic 客户端私有主题，用于向单个WebSocket客户端投递消息
+func ClientTopic(ucode string) string {
+	return "client." + ucode
+}
+
+// Subscription 事件订阅
+type Subscription struct {
+	id      int64
+	topics  []string
+	ch      chan models.Event
+	dropped int64
+}
+
+// C 事件通道，取消订阅后关闭
+func (s *Subscription) C() <-chan models.Event {
+	return s.ch
+}
+
+// Topics 订阅的主题
+func (s *Subscription) Topics() []string {
+	return s.topics
+}
+
+// Dropped 因缓冲区已满而丢弃的事件数
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// EventObserver 同步接收事件，在发布者的goroutine中调用，不会丢弃事件，处理需足够快
+type EventObserver func(event models.Event)
+
+// EventHub 基于主题的进程内发布/订阅中心
+type EventHub struct {
+	mutex      sync.RWMutex
+	bufferSize int
+	nextID     int64
+	topics     map[string]map[int64]*Subscription
+	observers  map[string][]EventObserver
+}
+
+// NewEventHub 创建事件中心
+func NewEventHub(bufferSize int) *EventHub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultSubscriberBuffer
+	}
+	return &EventHub{
+		bufferSize: bufferSize,
+		topics:     make(map[string]map[int64]*Subscription),
+		observers:  make(map[string][]EventObserver),
+	}
+}
+
+// Observe 注册同步观察者，用于不能丢失事件的消费者
+func (h *EventHub) Observe(observer EventObserver, topics ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, topic := range topics {
+		h.observers[topic] = append(h.observers[topic], observer)
+	}
+}
+
+// Subscribe 订阅一个或多个主题
+func (h *EventHub) Subscribe(topics ...string) *Subscription {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.nextID++
+	sub := &Subscription{
+		id:     h.nextID,
+		topics: topics,
+		ch:     make(chan models.Event, h.bufferSize),
+	}
+
+	for _, topic := range topics {
+		subs, exists := h.topics[topic]
+		if !exists {
+			subs = make(map[int64]*Subscription)
+			h.topics[topic] = subs
+		}
+		subs[sub.id] = sub
+	}
+
+	return sub
+}
+
+// Unsubscribe 取消订阅并关闭事件通道
+func (h *EventHub) Unsubscribe(sub *Subscription) {
+	if sub == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	removed := false
+	for _, topic := range sub.topics {
+		subs, exists := h.topics[topic]
+		if !exists {
+			continue
+		}
+		if _, ok := subs[sub.id]; ok {
+			delete(subs, sub.id)
+			removed = true
+		}
+		if len(subs) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+
+	if removed {
+		close(sub.ch)
+	}
+}
+
+// Publish 发布事件，订阅者缓冲区已满时丢弃该事件，不阻塞发布者；观察者同步调用
+func (h *EventHub) Publish(topic string, event models.Event) {
+	event.Topic = topic
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	h.mutex.RLock()
+	observers := h.observers[topic]
+	h.deliverLocked(topic, event)
+	h.mutex.RUnlock()
+
+	for _, observer := range observers {
+		observer(event)
+	}
+}
+
+// 投递给订阅者，调用方需持有读锁
+func (h *EventHub) deliverLocked(topic string, event models.Event) {
+	for _, sub := range h.topics[topic] {
+		select {
+		case sub.ch <- event:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+			log.Warn().
+				Str("topic", topic).
+				Str("type", string(event.Type)).
+				Int64("subscription", sub.id).
+				Msg("Subscriber buffer full, event dropped")
+		}
+	}
+}
+
+// SubscriberCount 主题的订阅者数量
+func (h *EventHub) SubscriberCount(topic string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.topics[topic])
+}
@@ -7,12 +7,12 @@ import (
 	"fmt"
 	"math"
 	mathrand "math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"remote-ctrl-robot/internal/models"
 
-	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
 )
 
@@ -25,9 +25,8 @@ type GameService struct {
 	// 游戏状态
 	games map[string]*models.GameState
 
-	// 机器人连接映射
-	robotConnections map[string]*websocket.Conn
-	connRobots       map[*websocket.Conn]string
+	// 事件中心
+	hub *EventHub
 
 	// 游戏配置
 	defaultConfig *models.GameConfig
@@ -37,15 +36,14 @@ type GameService struct {
 }
 
 // NewGameService 创建游戏服务
-func NewGameService() *GameService {
+func NewGameService(hub *EventHub) *GameService {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	service := &GameService{
-		ctx:              ctx,
-		cancel:           cancel,
-		games:            make(map[string]*models.GameState),
-		robotConnections: make(map[string]*websocket.Conn),
-		connRobots:       make(map[*websocket.Conn]string),
+		ctx:    ctx,
+		cancel: cancel,
+		games:  make(map[string]*models.GameState),
+		hub:    hub,
 		defaultConfig: &models.GameConfig{
 			MaxHealth:     models.DefaultMaxHealth,
 			BulletDamage:  models.DefaultBulletDamage,
@@ -89,22 +87,22 @@ func (s *GameService) CreateGame(gameID string) *models.GameState {
 }
 
 // JoinGame 加入游戏
-func (s *GameService) JoinGame(gameID, ucode, name string, conn *websocket.Conn) error {
+func (s *GameService) JoinGame(gameID, ucode, name string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	game, exists := s.games[gameID]
 	if !exists {
-		return fmt.Errorf("game %s not found", gameID)
+		return models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
 	if game.Status != models.GameStatusWaiting {
-		return fmt.Errorf("game %s is not in waiting status", gameID)
+		return models.Errorf(models.ErrorCodeGameState, "game %s is not in waiting status", gameID)
 	}
 
 	// 检查机器人是否已在游戏中
 	if _, exists := game.Robots[ucode]; exists {
-		return fmt.Errorf("robot %s already in game", ucode)
+		return models.Errorf(models.ErrorCodeAlreadyInGame, "robot %s already in game", ucode)
 	}
 
 	// 创建游戏机器人
@@ -130,8 +128,6 @@ func (s *GameService) JoinGame(gameID, ucode, name string, conn *websocket.Conn)
 	}
 
 	game.Robots[ucode] = robot
-	s.robotConnections[ucode] = conn
-	s.connRobots[conn] = ucode
 
 	// 创建机器人统计
 	game.Statistics.RobotStats[ucode] = &models.RobotStats{
@@ -152,7 +148,7 @@ func (s *GameService) JoinGame(gameID, ucode, name string, conn *websocket.Conn)
 		ShooterUCode: ucode,
 		Message:      fmt.Sprintf("Robot %s joined the game", name),
 	}
-	game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+	s.recordEvent(game, event)
 
 	log.Info().Str("game_id", gameID).Str("ucode", ucode).Str("name", name).Msg("Robot joined game")
 
@@ -167,33 +163,33 @@ func (s *GameService) LeaveGame(gameID, ucode string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	return s.leaveGameLocked(gameID, ucode)
+}
+
+// 离开游戏，调用方需持有锁
+func (s *GameService) leaveGameLocked(gameID, ucode string) error {
 	game, exists := s.games[gameID]
 	if !exists {
-		return fmt.Errorf("game %s not found", gameID)
+		return models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
-	if _, exists := game.Robots[ucode]; !exists {
-		return fmt.Errorf("robot %s not in game", ucode)
+	robot, exists := game.Robots[ucode]
+	if !exists {
+		return models.Errorf(models.ErrorCodeNotInGame, "robot %s not in game", ucode)
 	}
 
 	// 移除机器人
 	delete(game.Robots, ucode)
 	delete(game.Statistics.RobotStats, ucode)
 
-	// 移除连接映射
-	if conn, exists := s.robotConnections[ucode]; exists {
-		delete(s.connRobots, conn)
-		delete(s.robotConnections, ucode)
-	}
-
 	// 添加游戏事件
 	event := &models.GameEvent{
 		Type:         "leave",
 		Timestamp:    time.Now(),
 		ShooterUCode: ucode,
-		Message:      fmt.Sprintf("Robot %s left the game", game.Robots[ucode].Name),
+		Message:      fmt.Sprintf("Robot %s left the game", robot.Name),
 	}
-	game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+	s.recordEvent(game, event)
 
 	log.Info().Str("game_id", gameID).Str("ucode", ucode).Msg("Robot left game")
 
@@ -210,15 +206,15 @@ func (s *GameService) StartGame(gameID string) error {
 
 	game, exists := s.games[gameID]
 	if !exists {
-		return fmt.Errorf("game %s not found", gameID)
+		return models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
 	if game.Status != models.GameStatusWaiting {
-		return fmt.Errorf("game %s is not in waiting status", gameID)
+		return models.Errorf(models.ErrorCodeGameState, "game %s is not in waiting status", gameID)
 	}
 
 	if len(game.Robots) < 2 {
-		return fmt.Errorf("need at least 2 robots to start game")
+		return models.NewError(models.ErrorCodeNotEnoughPlayers, "need at least 2 robots to start game")
 	}
 
 	game.Status = models.GameStatusPlaying
@@ -231,7 +227,7 @@ func (s *GameService) StartGame(gameID string) error {
 		Timestamp: time.Now(),
 		Message:   "Game started",
 	}
-	game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+	s.recordEvent(game, event)
 
 	log.Info().Str("game_id", gameID).Msg("Game started")
 
@@ -248,7 +244,7 @@ func (s *GameService) StopGame(gameID string) error {
 
 	game, exists := s.games[gameID]
 	if !exists {
-		return fmt.Errorf("game %s not found", gameID)
+		return models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
 	game.Status = models.GameStatusFinished
@@ -263,7 +259,7 @@ func (s *GameService) StopGame(gameID string) error {
 		Timestamp: time.Now(),
 		Message:   fmt.Sprintf("Game ended. Winner: %s", game.Winner),
 	}
-	game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+	s.recordEvent(game, event)
 
 	log.Info().Str("game_id", gameID).Str("winner", game.Winner).Msg("Game stopped")
 
@@ -280,25 +276,25 @@ func (s *GameService) ProcessShot(gameID, shooterUCode string, targetX, targetY,
 
 	game, exists := s.games[gameID]
 	if !exists {
-		return fmt.Errorf("game %s not found", gameID)
+		return models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
 	if game.Status != models.GameStatusPlaying {
-		return fmt.Errorf("game %s is not in playing status", gameID)
+		return models.Errorf(models.ErrorCodeGameState, "game %s is not in playing status", gameID)
 	}
 
 	shooter, exists := game.Robots[shooterUCode]
 	if !exists {
-		return fmt.Errorf("shooter %s not found", shooterUCode)
+		return models.Errorf(models.ErrorCodeNotInGame, "shooter %s not found", shooterUCode)
 	}
 
 	if !shooter.IsAlive {
-		return fmt.Errorf("shooter %s is not alive", shooterUCode)
+		return models.Errorf(models.ErrorCodeRobotNotAlive, "shooter %s is not alive", shooterUCode)
 	}
 
 	// 检查射击冷却
 	if time.Since(shooter.LastShot) < time.Duration(game.Config.ShootCooldown)*time.Second {
-		return fmt.Errorf("shooter %s is in cooldown", shooterUCode)
+		return models.Errorf(models.ErrorCodeCooldown, "shooter %s is in cooldown", shooterUCode)
 	}
 
 	// 创建子弹
@@ -338,7 +334,7 @@ func (s *GameService) ProcessShot(gameID, shooterUCode string, targetX, targetY,
 		Position:     shooter.Position,
 		Message:      fmt.Sprintf("Robot %s fired a shot", shooter.Name),
 	}
-	game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+	s.recordEvent(game, event)
 
 	log.Debug().Str("game_id", gameID).Str("shooter", shooterUCode).Msg("Shot processed")
 
@@ -352,25 +348,25 @@ func (s *GameService) ProcessMove(gameID, ucode string, position models.Position
 
 	game, exists := s.games[gameID]
 	if !exists {
-		return fmt.Errorf("game %s not found", gameID)
+		return models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
 	if game.Status != models.GameStatusPlaying {
-		return fmt.Errorf("game %s is not in playing status", gameID)
+		return models.Errorf(models.ErrorCodeGameState, "game %s is not in playing status", gameID)
 	}
 
 	robot, exists := game.Robots[ucode]
 	if !exists {
-		return fmt.Errorf("robot %s not found", ucode)
+		return models.Errorf(models.ErrorCodeNotInGame, "robot %s not found", ucode)
 	}
 
 	if !robot.IsAlive {
-		return fmt.Errorf("robot %s is not alive", ucode)
+		return models.Errorf(models.ErrorCodeRobotNotAlive, "robot %s is not alive", ucode)
 	}
 
 	// 检查边界
 	if math.Abs(position.X) > game.Config.MapWidth/2 || math.Abs(position.Y) > game.Config.MapHeight/2 {
-		return fmt.Errorf("position out of bounds")
+		return models.NewError(models.ErrorCodeOutOfBounds, "position out of bounds")
 	}
 
 	robot.Position = position
@@ -386,12 +382,30 @@ func (s *GameService) GetGameState(gameID string) (*models.GameState, error) {
 
 	game, exists := s.games[gameID]
 	if !exists {
-		return nil, fmt.Errorf("game %s not found", gameID)
+		return nil, models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
 	return game, nil
 }
 
+// GetPlayers 获取游戏中的机器人UCode
+func (s *GameService) GetPlayers(gameID string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	game, exists := s.games[gameID]
+	if !exists {
+		return nil, models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
+	}
+
+	players := make([]string, 0, len(game.Robots))
+	for ucode := range game.Robots {
+		players = append(players, ucode)
+	}
+	sort.Strings(players)
+	return players, nil
+}
+
 // GetRobotInGame 获取机器人在游戏中的状态
 func (s *GameService) GetRobotInGame(gameID, ucode string) (*models.GameRobot, error) {
 	s.mutex.RLock()
@@ -399,31 +413,25 @@ func (s *GameService) GetRobotInGame(gameID, ucode string) (*models.GameRobot, e
 
 	game, exists := s.games[gameID]
 	if !exists {
-		return nil, fmt.Errorf("game %s not found", gameID)
+		return nil, models.Errorf(models.ErrorCodeGameNotFound, "game %s not found", gameID)
 	}
 
 	robot, exists := game.Robots[ucode]
 	if !exists {
-		return nil, fmt.Errorf("robot %s not found in game", ucode)
+		return nil, models.Errorf(models.ErrorCodeNotInGame, "robot %s not found in game", ucode)
 	}
 
 	return robot, nil
 }
 
-// RemoveRobotConnection 移除机器人连接
-func (s *GameService) RemoveRobotConnection(conn *websocket.Conn) {
+// RemoveRobot 机器人断开连接时从所有游戏中移除
+func (s *GameService) RemoveRobot(ucode string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if ucode, exists := s.connRobots[conn]; exists {
-		delete(s.robotConnections, ucode)
-		delete(s.connRobots, conn)
-
-		// 从所有游戏中移除该机器人
-		for gameID, game := range s.games {
-			if _, exists := game.Robots[ucode]; exists {
-				s.LeaveGame(gameID, ucode)
-			}
+	for gameID, game := range s.games {
+		if _, exists := game.Robots[ucode]; exists {
+			s.leaveGameLocked(gameID, ucode)
 		}
 	}
 }
@@ -464,7 +472,7 @@ func (s *GameService) updateGame(gameID string, game *models.GameState) {
 			Timestamp: time.Now(),
 			Message:   fmt.Sprintf("Game ended. Winner: %s", game.Winner),
 		}
-		game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+		s.recordEvent(game, event)
 
 		s.broadcastGameState(gameID)
 		return
@@ -557,7 +565,7 @@ func (s *GameService) applyDamage(robot *models.GameRobot, damage int, shooterUC
 		Message: fmt.Sprintf("Robot %s hit %s for %d damage",
 			game.Robots[shooterUCode].Name, robot.Name, damage),
 	}
-	game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+	s.recordEvent(game, event)
 
 	// 检查是否死亡
 	if robot.Health <= 0 {
@@ -588,7 +596,7 @@ func (s *GameService) applyDamage(robot *models.GameRobot, damage int, shooterUC
 			Message: fmt.Sprintf("Robot %s killed %s",
 				game.Robots[shooterUCode].Name, robot.Name),
 		}
-		game.Statistics.GameEvents = append(game.Statistics.GameEvents, killEvent)
+		s.recordEvent(game, killEvent)
 	}
 }
 
@@ -613,7 +621,7 @@ func (s *GameService) checkRespawns(game *models.GameState) {
 				Position:     robot.Position,
 				Message:      fmt.Sprintf("Robot %s respawned", robot.Name),
 			}
-			game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+			s.recordEvent(game, event)
 		}
 	}
 }
@@ -638,29 +646,80 @@ func (s *GameService) calculateWinner(game *models.GameState) {
 // 广播游戏状态
 func (s *GameService) broadcastGameState(gameID string) {
 	game, exists := s.games[gameID]
-	if !exists {
+	if !exists || s.hub == nil {
 		return
 	}
 
+	// 发布快照，避免投递时与游戏循环竞争
+	snapshot := snapshotGameState(game)
+
 	// 为每个机器人发送个性化状态
+	for ucode, robot := range snapshot.Robots {
+		response := models.WebSocketMessage{
+			Type:     models.WSMessageTypeResponse,
+			Command:  models.CMD_TYPE_GAME_STATUS,
+			Sequence: time.Now().UnixNano(),
+			UCode:    ucode,
+			Data: models.CMD_GAME_STATUS_RESPONSE{
+				GameState: snapshot,
+				MyRobot:   robot,
+			},
+		}
+
+		s.hub.Publish(ClientTopic(ucode), models.Event{
+			Type:  models.EventTypeMessage,
+			UCode: ucode,
+			Data:  response,
+		})
+	}
+}
+
+// 记录并发布游戏事件
+func (s *GameService) recordEvent(game *models.GameState, event *models.GameEvent) {
+	game.Statistics.GameEvents = append(game.Statistics.GameEvents, event)
+
+	if s.hub == nil {
+		return
+	}
+	s.hub.Publish(TopicGame, models.Event{
+		Type:      models.EventTypeGameEvent,
+		UCode:     event.ShooterUCode,
+		Timestamp: event.Timestamp,
+		Data: models.CMD_GAME_EVENT{
+			GameID: game.GameID,
+			Event:  event,
+		},
+	})
+}
+
+// 复制游戏状态
+func snapshotGameState(game *models.GameState) *models.GameState {
+	snapshot := *game
+
+	snapshot.Robots = make(map[string]*models.GameRobot, len(game.Robots))
 	for ucode, robot := range game.Robots {
-		if conn, exists := s.robotConnections[ucode]; exists {
-			response := models.WebSocketMessage{
-				Type:     models.WSMessageTypeResponse,
-				Command:  models.CMD_TYPE_GAME_STATUS,
-				Sequence: time.Now().UnixNano(),
-				UCode:    ucode,
-				Data: models.CMD_GAME_STATUS_RESPONSE{
-					GameState: game,
-					MyRobot:   robot,
-				},
-			}
+		robotCopy := *robot
+		snapshot.Robots[ucode] = &robotCopy
+	}
 
-			if err := conn.WriteJSON(response); err != nil {
-				log.Error().Err(err).Str("ucode", ucode).Msg("Failed to send game state")
-			}
+	snapshot.Bullets = make([]*models.GameBullet, 0, len(game.Bullets))
+	for _, bullet := range game.Bullets {
+		bulletCopy := *bullet
+		snapshot.Bullets = append(snapshot.Bullets, &bulletCopy)
+	}
+
+	if game.Statistics != nil {
+		stats := *game.Statistics
+		stats.RobotStats = make(map[string]*models.RobotStats, len(game.Statistics.RobotStats))
+		for ucode, robotStats := range game.Statistics.RobotStats {
+			statsCopy := *robotStats
+			stats.RobotStats[ucode] = &statsCopy
 		}
+		stats.GameEvents = append([]*models.GameEvent(nil), game.Statistics.GameEvents...)
+		snapshot.Statistics = &stats
 	}
+
+	return &snapshot
 }
 
 // 工具函数
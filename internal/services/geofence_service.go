@@ -0,0 +1,286 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"sync"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 默认预警距离 (m)
+const DefaultGeofenceWarningDistance = 0.5
+
+// 机器人最近一次的围栏状态
+type geofenceTrack struct {
+	status      models.GeofenceStatus
+	orientation [4]float64
+}
+
+// GeofenceService 电子围栏
+type GeofenceService struct {
+	mutex        sync.RWMutex
+	fences       []models.Geofence
+	stopOnBreach bool
+	tracks       map[string]*geofenceTrack
+}
+
+// NewGeofenceService 创建电子围栏服务
+func NewGeofenceService(config models.GeofenceConfig) *GeofenceService {
+	warning := config.WarningDistance
+	if warning <= 0 {
+		warning = DefaultGeofenceWarningDistance
+	}
+
+	fences := make([]models.Geofence, 0, len(config.Fences))
+	for i, fence := range config.Fences {
+		if len(fence.Polygon) < 3 {
+			log.Warn().Str("fence", fence.Name).Msg("Geofence polygon needs at least 3 points, ignored")
+			continue
+		}
+		if fence.Name == "" {
+			fence.Name = "fence_" + strconv.Itoa(i)
+		}
+		if fence.Warning <= 0 {
+			fence.Warning = warning
+		}
+		fences = append(fences, fence)
+	}
+
+	if len(fences) > 0 {
+		log.Info().Int("fences", len(fences)).Bool("stop_on_breach", config.StopOnBreach).Msg("Geofences loaded")
+	}
+
+	return &GeofenceService{
+		fences:       fences,
+		stopOnBreach: config.StopOnBreach,
+		tracks:       make(map[string]*geofenceTrack),
+	}
+}
+
+// StopOnBreach 越界时是否需要停止机器人
+func (s *GeofenceService) StopOnBreach() bool {
+	return s.stopOnBreach
+}
+
+// GetFences 获取所有围栏
+func (s *GeofenceService) GetFences() []models.Geofence {
+	return s.fences
+}
+
+// 查找机器人适用的围栏，单机围栏优先于场地围栏
+func (s *GeofenceService) fenceFor(ucode string) *models.Geofence {
+	var site *models.Geofence
+	for i := range s.fences {
+		fence := &s.fences[i]
+		if len(fence.Robots) == 0 {
+			if site == nil {
+				site = fence
+			}
+			continue
+		}
+		for _, robot := range fence.Robots {
+			if robot == ucode {
+				return fence
+			}
+		}
+	}
+	return site
+}
+
+// Update 根据状态上报更新机器人的围栏状态，返回新状态和之前的状态
+func (s *GeofenceService) Update(ucode string, state models.RobotState) (*models.GeofenceStatus, string) {
+	fence := s.fenceFor(ucode)
+	if fence == nil {
+		return nil, ""
+	}
+
+	position := [2]float64{state.BasePosition[0], state.BasePosition[1]}
+	status := evaluateFence(fence, position)
+	status.UCode = ucode
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := models.GeofenceStateInside
+	if track, exists := s.tracks[ucode]; exists {
+		previous = track.status.State
+	}
+	s.tracks[ucode] = &geofenceTrack{
+		status:      status,
+		orientation: state.BaseOrientation,
+	}
+
+	return &status, previous
+}
+
+// GetStatus 获取机器人的围栏状态
+func (s *GeofenceService) GetStatus(ucode string) (models.GeofenceStatus, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	track, exists := s.tracks[ucode]
+	if !exists {
+		return models.GeofenceStatus{}, false
+	}
+	return track.status, true
+}
+
+// GetStatuses 获取所有机器人的围栏状态
+func (s *GeofenceService) GetStatuses() []models.GeofenceStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	statuses := make([]models.GeofenceStatus, 0, len(s.tracks))
+	for _, track := range s.tracks {
+		statuses = append(statuses, track.status)
+	}
+	return statuses
+}
+
+// Remove 机器人断开时清除围栏状态
+func (s *GeofenceService) Remove(ucode string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tracks, ucode)
+}
+
+// CheckMove 越界或接近边界时拒绝向外的Move命令
+func (s *GeofenceService) CheckMove(ucode string, command *models.CMD_CONTROL_ROBOT) error {
+	if command.Action != models.ControlActionMove {
+		return nil
+	}
+
+	s.mutex.RLock()
+	track, exists := s.tracks[ucode]
+	var status models.GeofenceStatus
+	var orientation [4]float64
+	if exists {
+		status = track.status
+		orientation = track.orientation
+	}
+	s.mutex.RUnlock()
+
+	if !exists || status.State == models.GeofenceStateInside {
+		return nil
+	}
+
+	vx, err := parseVelocityParam(command.ParamMaps, "vx")
+	if err != nil {
+		return err
+	}
+	vy, err := parseVelocityParam(command.ParamMaps, "vy")
+	if err != nil {
+		return err
+	}
+
+	// 机体坐标系速度转换到场地坐标系
+	yaw := quaternionYaw(orientation)
+	wx := vx*math.Cos(yaw) - vy*math.Sin(yaw)
+	wy := vx*math.Sin(yaw) + vy*math.Cos(yaw)
+
+	if wx*status.Outward[0]+wy*status.Outward[1] > 1e-6 {
+		return models.Errorf(models.ErrorCodeGeofence, "move rejected: robot %s is %s geofence %q (distance %.2f m), only inward motion is allowed",
+			ucode, status.State, status.Fence, status.Distance)
+	}
+	return nil
+}
+
+// 计算位置相对围栏的状态
+func evaluateFence(fence *models.Geofence, position [2]float64) models.GeofenceStatus {
+	inside := pointInPolygon(fence.Polygon, position)
+	nearest, distance := nearestBoundaryPoint(fence.Polygon, position)
+
+	// 向外方向：围栏内指向最近边界点，围栏外背离最近边界点
+	var outward [2]float64
+	if distance > 1e-9 {
+		dx := (nearest[0] - position[0]) / distance
+		dy := (nearest[1] - position[1]) / distance
+		if inside {
+			outward = [2]float64{dx, dy}
+		} else {
+			outward = [2]float64{-dx, -dy}
+		}
+	} else {
+		// 正好在边界上，使用背离中心的方向
+		center := polygonCenter(fence.Polygon)
+		dx, dy := position[0]-center[0], position[1]-center[1]
+		if norm := math.Hypot(dx, dy); norm > 1e-9 {
+			outward = [2]float64{dx / norm, dy / norm}
+		}
+	}
+
+	status := models.GeofenceStatus{
+		Fence:    fence.Name,
+		Position: position,
+		Distance: distance,
+		Outward:  outward,
+	}
+	switch {
+	case !inside:
+		status.State = models.GeofenceStateOutside
+		status.Distance = -distance
+	case distance < fence.Warning:
+		status.State = models.GeofenceStateApproaching
+	default:
+		status.State = models.GeofenceStateInside
+	}
+	return status
+}
+
+// 射线法判断点是否在多边形内
+func pointInPolygon(polygon [][2]float64, p [2]float64) bool {
+	inside := false
+	j := len(polygon) - 1
+	for i := 0; i < len(polygon); i++ {
+		a, b := polygon[i], polygon[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// 计算多边形边界上距离最近的点
+func nearestBoundaryPoint(polygon [][2]float64, p [2]float64) ([2]float64, float64) {
+	var nearest [2]float64
+	best := math.Inf(1)
+	for i := range polygon {
+		a := polygon[i]
+		b := polygon[(i+1)%len(polygon)]
+		abx, aby := b[0]-a[0], b[1]-a[1]
+		t := 0.0
+		if length := abx*abx + aby*aby; length > 0 {
+			t = ((p[0]-a[0])*abx + (p[1]-a[1])*aby) / length
+			t = math.Max(0, math.Min(1, t))
+		}
+		point := [2]float64{a[0] + t*abx, a[1] + t*aby}
+		if d := math.Hypot(p[0]-point[0], p[1]-point[1]); d < best {
+			best = d
+			nearest = point
+		}
+	}
+	return nearest, best
+}
+
+// 多边形顶点平均值
+func polygonCenter(polygon [][2]float64) [2]float64 {
+	var center [2]float64
+	for _, point := range polygon {
+		center[0] += point[0]
+		center[1] += point[1]
+	}
+	n := float64(len(polygon))
+	return [2]float64{center[0] / n, center[1] / n}
+}
+
+// 由四元数 [x, y, z, w] 计算偏航角
+func quaternionYaw(q [4]float64) float64 {
+	x, y, z, w := q[0], q[1], q[2], q[3]
+	if x == 0 && y == 0 && z == 0 && w == 0 {
+		return 0
+	}
+	return math.Atan2(2*(w*z+x*y), 1-2*(y*y+z*z))
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"remote-ctrl-robot/internal/models"
+)
+
+// 内存中的Janus，实现会话、句柄和streaming插件的create/destroy/list/info
+type fakeJanusTransport struct {
+	mutex       sync.Mutex
+	nextID      int64
+	sessions    map[int64]bool
+	handles     map[int64]int64 // 句柄ID -> 会话ID
+	mountpoints map[int]janusStreamInfo
+	requests    []JanusRequest
+	events      chan *JanusResponse
+
+	// 返回非nil时请求直接失败，plugin为插件请求类型，非插件请求为空
+	fail func(req JanusRequest, plugin string) error
+}
+
+func newFakeJanusTransport() *fakeJanusTransport {
+	return &fakeJanusTransport{
+		nextID:      1000,
+		sessions:    make(map[int64]bool),
+		handles:     make(map[int64]int64),
+		mountpoints: make(map[int]janusStreamInfo),
+		events:      make(chan *JanusResponse, janusEventBuffer),
+	}
+}
+
+func (f *fakeJanusTransport) Events() <-chan *JanusResponse {
+	return f.events
+}
+
+func (f *fakeJanusTransport) Close() error {
+	return nil
+}
+
+func (f *fakeJanusTransport) Send(req JanusRequest) (*JanusResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	req.Transaction = newTransaction()
+	f.requests = append(f.requests, req)
+
+	body, _ := req.Body.(map[string]interface{})
+	plugin, _ := body["request"].(string)
+	if f.fail != nil {
+		if err := f.fail(req, plugin); err != nil {
+			return nil, err
+		}
+	}
+
+	switch req.Janus {
+	case "info":
+		return &JanusResponse{Janus: "server_info", Transaction: req.Transaction}, nil
+	case "create":
+		f.nextID++
+		f.sessions[f.nextID] = true
+		return f.success(req, f.nextID), nil
+	case "attach":
+		if !f.sessions[req.SessionID] {
+			return nil, &JanusError{Code: janusErrorSessionNotFound, Reason: "No such session"}
+		}
+		f.nextID++
+		f.handles[f.nextID] = req.SessionID
+		return f.success(req, f.nextID), nil
+	case "keepalive":
+		if !f.sessions[req.SessionID] {
+			return nil, &JanusError{Code: janusErrorSessionNotFound, Reason: "No such session"}
+		}
+		return &JanusResponse{Janus: "ack", Transaction: req.Transaction, SessionID: req.SessionID}, nil
+	case "message":
+		if !f.sessions[req.SessionID] {
+			return nil, &JanusError{Code: janusErrorSessionNotFound, Reason: "No such session"}
+		}
+		if f.handles[req.HandleID] != req.SessionID {
+			return nil, &JanusError{Code: janusErrorHandleNotFound, Reason: "No such handle"}
+		}
+		data, err := json.Marshal(f.streaming(plugin, body))
+		if err != nil {
+			return nil, err
+		}
+		return &JanusResponse{
+			Janus:       "event",
+			Transaction: req.Transaction,
+			SessionID:   req.SessionID,
+			Sender:      req.HandleID,
+			PluginData:  &JanusPluginData{Plugin: "janus.plugin.streaming", Data: data},
+		}, nil
+	}
+	return nil, &JanusError{Code: 453, Reason: "Unknown request '" + req.Janus + "'"}
+}
+
+func (f *fakeJanusTransport) success(req JanusRequest, id int64) *JanusResponse {
+	data, _ := json.Marshal(map[string]int64{"id": id})
+	return &JanusResponse{Janus: "success", Transaction: req.Transaction, SessionID: req.SessionID, Data: data}
+}
+
+// streaming插件请求，调用方持有 f.mutex
+func (f *fakeJanusTransport) streaming(request string, body map[string]interface{}) interface{} {
+	id, _ := body["id"].(int)
+
+	switch request {
+	case "create":
+		if _, exists := f.mountpoints[id]; exists {
+			return janusPluginError{Code: janusStreamingErrorCantCreate, Reason: fmt.Sprintf("A stream with the provided ID %d already exists", id)}
+		}
+		info := janusStreamInfo{ID: id, Type: "rtp"}
+		info.Description, _ = body["description"].(string)
+		info.VideoPort, _ = body["videoport"].(int)
+		info.AudioPort, _ = body["audioport"].(int)
+		f.mountpoints[id] = info
+		return map[string]interface{}{"streaming": "created", "stream": info}
+	case "destroy":
+		if _, exists := f.mountpoints[id]; !exists {
+			return janusPluginError{Code: janusStreamingErrorNoSuchMountpoint, Reason: fmt.Sprintf("No such mountpoint/stream %d", id)}
+		}
+		delete(f.mountpoints, id)
+		return map[string]interface{}{"streaming": "destroyed", "id": id}
+	case "list":
+		list := make([]janusStreamInfo, 0, len(f.mountpoints))
+		for _, info := range f.mountpoints {
+			list = append(list, info)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].ID < list[j].ID
+		})
+		return map[string]interface{}{"streaming": "list", "list": list}
+	case "info":
+		info, exists := f.mountpoints[id]
+		if !exists {
+			return janusPluginError{Code: janusStreamingErrorNoSuchMountpoint, Reason: fmt.Sprintf("No such mountpoint/stream %d", id)}
+		}
+		return map[string]interface{}{"streaming": "info", "info": info}
+	}
+	return janusPluginError{Code: 450, Reason: "Invalid request"}
+}
+
+// 添加挂载点，模拟其他进程或重启前创建的挂载点
+func (f *fakeJanusTransport) addMountpoint(id int, description string, videoPort int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.mountpoints[id] = janusStreamInfo{ID: id, Type: "rtp", Description: description, VideoPort: videoPort}
+}
+
+func (f *fakeJanusTransport) mountpoint(id int) (janusStreamInfo, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	info, exists := f.mountpoints[id]
+	return info, exists
+}
+
+func (f *fakeJanusTransport) mountpointCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.mountpoints)
+}
+
+// 会话超时，句柄随之失效
+func (f *fakeJanusTransport) expireSessions() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sessions = make(map[int64]bool)
+	f.handles = make(map[int64]int64)
+}
+
+// 句柄被分离，会话仍有效
+func (f *fakeJanusTransport) detachHandles() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.handles = make(map[int64]int64)
+}
+
+// Janus重启，会话和非永久挂载点全部丢失
+func (f *fakeJanusTransport) restart() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sessions = make(map[int64]bool)
+	f.handles = make(map[int64]int64)
+	f.mountpoints = make(map[int]janusStreamInfo)
+}
+
+// 统计请求数，plugin为空时统计该类型的全部请求
+func (f *fakeJanusTransport) count(janus, plugin string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n := 0
+	for _, req := range f.requests {
+		body, _ := req.Body.(map[string]interface{})
+		if req.Janus == janus && (plugin == "" || body["request"] == plugin) {
+			n++
+		}
+	}
+	return n
+}
+
+// 使用假Janus创建服务，不启动后台协程
+func newTestJanusService(t *testing.T, config models.JanusConfig) (*JanusService, *fakeJanusTransport) {
+	t.Helper()
+	if config.HTTPURL == "" {
+		config.HTTPURL = "http://janus.test:8088"
+	}
+	if config.WebSocketURL == "" {
+		config.WebSocketURL = "ws://janus.test:8188"
+	}
+	if config.StreamID == 0 {
+		config.StreamID = 100
+	}
+	if config.RTPPorts.Min == 0 {
+		config.RTPPorts = models.JanusPortRange{Min: 20000, Max: 20100}
+	}
+
+	fake := newFakeJanusTransport()
+	js := NewJanusServiceWithTransport(config, fake)
+	t.Cleanup(js.Shutdown)
+	return js, fake
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 定期检查各实例，状态变化时故障转移或恢复
+func (js *JanusService) healthLoop() {
+	js.checkInstances()
+
+	ticker := time.NewTicker(js.config.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-js.ctx.Done():
+			return
+		case <-ticker.C:
+			js.checkInstances()
+		}
+	}
+}
+
+// 并发检查所有实例
+func (js *JanusService) checkInstances() {
+	var wg sync.WaitGroup
+	for _, inst := range js.instances {
+		wg.Add(1)
+		go func(inst *janusInstance) {
+			defer wg.Done()
+
+			started := time.Now()
+			err := inst.checkStatus()
+			healthy, changed := inst.recordCheck(err, time.Since(started), js.config.FailureThreshold)
+			if err != nil {
+				log.Debug().Err(err).Str("instance", inst.name).Msg("Janus health check failed")
+			}
+			if !changed {
+				return
+			}
+
+			if healthy {
+				log.Info().Str("instance", inst.name).Msg("Janus instance recovered")
+				go js.recoverSession(inst, "instance recovered")
+				return
+			}
+			log.Error().Err(err).Str("instance", inst.name).Int("failures", js.config.FailureThreshold).Msg("Janus instance unhealthy")
+			go js.failover(inst)
+		}(inst)
+	}
+	wg.Wait()
+}
+
+// 实例故障：观看者会话作废，实例上的流迁移到其他健康实例
+func (js *JanusService) failover(inst *janusInstance) {
+	inst.resetControlHandles()
+	js.markStreams(inst, "active", "error")
+	if listener := js.getListener(); listener != nil {
+		listener.SessionReset(inst.name)
+	}
+
+	js.mutex.RLock()
+	streams := make([]*models.WebRTCStream, 0)
+	js.eachStream(func(stream *models.WebRTCStream) {
+		if stream.Instance == inst.name {
+			streams = append(streams, stream)
+		}
+	})
+	js.mutex.RUnlock()
+
+	migrated := 0
+	for _, stream := range streams {
+		if err := js.migrateStream(stream, inst); err != nil {
+			log.Error().Err(err).Str("ucode", stream.UCode).Str("source", stream.Source).Str("from", inst.name).Msg("Failed to migrate WebRTC stream")
+			continue
+		}
+		migrated++
+	}
+
+	if len(streams) > 0 {
+		log.Warn().Str("instance", inst.name).Int("streams", len(streams)).Int("migrated", migrated).Msg("Janus instance failover completed")
+	}
+}
+
+// 在其他健康实例上重建流的挂载点并替换原流，机器人需按新的推流地址推流
+func (js *JanusService) migrateStream(stream *models.WebRTCStream, from *janusInstance) error {
+	target, err := js.pickInstance(from)
+	if err != nil {
+		return err
+	}
+	mountpoint, err := js.provisionMountpoint(target, stream.UCode, stream.Source, stream.VideoCodec)
+	if err != nil {
+		return err
+	}
+	migrated := js.newStream(target, stream.UCode, stream.Source, stream.VideoCodec, mountpoint)
+
+	js.mutex.Lock()
+	current, exists := js.lookupStream(stream.UCode, stream.Source)
+	if !exists || current != stream {
+		// 迁移期间流已被删除或重新注册
+		js.mutex.Unlock()
+		target.destroyMountpoint(mountpoint.ID)
+		target.ports.release(mountpoint.VideoPort, mountpoint.AudioPort)
+		target.ids.release(mountpoint.ID)
+		return nil
+	}
+	if stream.Status == "inactive" {
+		migrated.Status = "inactive"
+		migrated.InactiveSince = stream.InactiveSince
+	}
+	previous := *stream
+	js.removeStream(stream)
+	js.storeStream(migrated)
+	next := *migrated
+	js.mutex.Unlock()
+
+	log.Info().
+		Str("ucode", next.UCode).
+		Str("source", next.Source).
+		Str("from", previous.Instance).
+		Str("to", next.Instance).
+		Int("stream_id", next.StreamID).
+		Str("push_url", next.PushURL).
+		Msg("WebRTC stream migrated")
+
+	if listener := js.getListener(); listener != nil {
+		listener.StreamMigrated(previous, next)
+	}
+	return nil
+}
+
+// CheckStatus 立即检查所有实例，没有可用实例时返回错误
+func (js *JanusService) CheckStatus() error {
+	js.checkInstances()
+	for _, inst := range js.instances {
+		if inst.healthy() {
+			return nil
+		}
+	}
+	return models.NewError(models.ErrorCodeUnavailable, "no healthy janus instance")
+}
+
+// GetHealth 获取各实例最近一次健康检查的结果
+func (js *JanusService) GetHealth() []models.JanusInstanceHealth {
+	js.mutex.RLock()
+	counts := make(map[string]int)
+	js.eachStream(func(stream *models.WebRTCStream) {
+		counts[stream.Instance]++
+	})
+	js.mutex.RUnlock()
+
+	health := make([]models.JanusInstanceHealth, 0, len(js.instances))
+	for _, inst := range js.instances {
+		snapshot := inst.healthSnapshot()
+		snapshot.Streams = counts[inst.name]
+		health = append(health, snapshot)
+	}
+	return health
+}
@@ -0,0 +1,438 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 单实例部署时的实例名称
+const defaultJanusInstance = "default"
+
+// 未配置Admin API
+var errJanusAdminDisabled = errors.New("janus admin api not configured")
+
+// Janus实例，每个实例有独立的传输层、管理会话、端口池和挂载点ID池
+type janusInstance struct {
+	name         string
+	httpURL      string
+	webSocketURL string
+	rtpHost      string
+	adminKey     string
+	adminURL     string
+	adminSecret  string
+	adminClient  *http.Client
+	weight       int
+
+	transport JanusTransport
+	ports     *rtpPortPool
+	ids       *mountpointIDPool
+
+	// 管理挂载点使用的会话和句柄
+	controlMutex   sync.Mutex
+	controlSession int64
+	controlHandle  int64
+	recovering     sync.Mutex
+
+	// 正在创建中的挂载点数，负载均衡时计入
+	provisioning int32
+
+	healthMutex sync.RWMutex
+	health      models.JanusInstanceHealth
+}
+
+// 按配置展开实例列表，未配置instances时顶层地址即唯一实例
+func janusInstanceConfigs(config models.JanusConfig) []models.JanusInstanceConfig {
+	if len(config.Instances) == 0 {
+		return []models.JanusInstanceConfig{{
+			Name:         defaultJanusInstance,
+			HTTPURL:      config.HTTPURL,
+			BasePath:     config.BasePath,
+			WebSocketURL: config.WebSocketURL,
+			RTPHost:      config.RTPHost,
+			RTPPorts:     config.RTPPorts,
+			AdminURL:     config.AdminURL,
+		}}
+	}
+
+	instances := make([]models.JanusInstanceConfig, 0, len(config.Instances))
+	for i, instance := range config.Instances {
+		if instance.Name == "" {
+			instance.Name = fmt.Sprintf("janus-%d", i+1)
+		}
+		if instance.BasePath == "" {
+			instance.BasePath = config.BasePath
+		}
+		if instance.RTPPorts.Min == 0 && instance.RTPPorts.Max == 0 {
+			instance.RTPPorts = config.RTPPorts
+		}
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+func newJanusInstance(config models.JanusConfig, instance models.JanusInstanceConfig, transport JanusTransport) *janusInstance {
+	if instance.RTPHost == "" {
+		if u, err := url.Parse(instance.HTTPURL); err == nil {
+			instance.RTPHost = u.Hostname()
+		}
+	}
+	if instance.Weight <= 0 {
+		instance.Weight = 1
+	}
+	if transport == nil {
+		transportConfig := config
+		transportConfig.HTTPURL = instance.HTTPURL
+		transportConfig.BasePath = instance.BasePath
+		transportConfig.WebSocketURL = instance.WebSocketURL
+		transport = NewJanusTransport(transportConfig)
+	}
+
+	return &janusInstance{
+		name:         instance.Name,
+		httpURL:      instance.HTTPURL,
+		webSocketURL: instance.WebSocketURL,
+		rtpHost:      instance.RTPHost,
+		adminKey:     config.AdminKey,
+		adminURL:     instance.AdminURL,
+		adminSecret:  config.AdminSecret,
+		adminClient:  &http.Client{Timeout: janusRequestTimeout},
+		weight:       instance.Weight,
+		transport:    transport,
+		ports:        newRTPPortPool(instance.RTPPorts),
+		ids:          newMountpointIDPool(config.StreamID),
+		// 首次检查之前视为健康，允许启动阶段注册
+		health: models.JanusInstanceHealth{
+			Name:    instance.Name,
+			HTTPURL: instance.HTTPURL,
+			Healthy: true,
+		},
+	}
+}
+
+// 当前管理会话，未建立时返回0
+func (inst *janusInstance) currentSession() (int64, int64) {
+	inst.controlMutex.Lock()
+	defer inst.controlMutex.Unlock()
+	return inst.controlSession, inst.controlHandle
+}
+
+func (inst *janusInstance) healthy() bool {
+	inst.healthMutex.RLock()
+	defer inst.healthMutex.RUnlock()
+	return inst.health.Healthy
+}
+
+func (inst *janusInstance) healthSnapshot() models.JanusInstanceHealth {
+	inst.healthMutex.RLock()
+	defer inst.healthMutex.RUnlock()
+	return inst.health
+}
+
+// 请求Janus服务信息检查实例是否可用
+func (inst *janusInstance) checkStatus() error {
+	response, err := inst.transport.Send(JanusRequest{Janus: "info"})
+	if err != nil {
+		return fmt.Errorf("janus not responding: %w", err)
+	}
+	if response.Janus != "server_info" {
+		return fmt.Errorf("unexpected info response: %s", response.Janus)
+	}
+	return nil
+}
+
+// 记录一次健康检查结果，返回健康状态是否发生变化
+func (inst *janusInstance) recordCheck(err error, latency time.Duration, threshold int) (healthy, changed bool) {
+	inst.healthMutex.Lock()
+	defer inst.healthMutex.Unlock()
+
+	inst.health.LastCheck = time.Now().UnixMilli()
+	inst.health.Latency = latency.Milliseconds()
+	if err == nil {
+		inst.health.Failures = 0
+		inst.health.LastError = ""
+		changed = !inst.health.Healthy
+		inst.health.Healthy = true
+		return true, changed
+	}
+
+	inst.health.Failures++
+	inst.health.LastError = err.Error()
+	if inst.health.Healthy && inst.health.Failures >= threshold {
+		inst.health.Healthy = false
+		return false, true
+	}
+	return inst.health.Healthy, false
+}
+
+// 负载：流数量加上创建中的挂载点，按权重折算
+func (inst *janusInstance) load(streams int) float64 {
+	return float64(streams+int(atomic.LoadInt32(&inst.provisioning))) / float64(inst.weight)
+}
+
+// 创建会话
+func (inst *janusInstance) createSession() (int64, error) {
+	response, err := inst.transport.Send(JanusRequest{Janus: "create"})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create session: %w", err)
+	}
+	return responseID(response)
+}
+
+// 附加流插件
+func (inst *janusInstance) attachStreamingPlugin(sessionID int64) (int64, error) {
+	response, err := inst.transport.Send(JanusRequest{
+		Janus:     "attach",
+		SessionID: sessionID,
+		Plugin:    "janus.plugin.streaming",
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach streaming plugin: %w", err)
+	}
+	return responseID(response)
+}
+
+// 获取管理挂载点使用的会话和句柄，不存在时创建
+func (inst *janusInstance) controlHandles() (int64, int64, error) {
+	inst.controlMutex.Lock()
+	defer inst.controlMutex.Unlock()
+
+	if inst.controlSession != 0 && inst.controlHandle != 0 {
+		return inst.controlSession, inst.controlHandle, nil
+	}
+
+	sessionID, err := inst.createSession()
+	if err != nil {
+		return 0, 0, err
+	}
+	handleID, err := inst.attachStreamingPlugin(sessionID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	inst.controlSession = sessionID
+	inst.controlHandle = handleID
+	log.Info().Str("instance", inst.name).Int64("session_id", sessionID).Int64("handle_id", handleID).Msg("Janus streaming control handle attached")
+	return sessionID, handleID, nil
+}
+
+// 丢弃失效的管理会话
+func (inst *janusInstance) resetControlHandles() {
+	inst.controlMutex.Lock()
+	defer inst.controlMutex.Unlock()
+	inst.controlSession = 0
+	inst.controlHandle = 0
+}
+
+// 向streaming插件发送同步请求，返回插件数据
+func (inst *janusInstance) pluginRequest(body map[string]interface{}) (json.RawMessage, error) {
+	if inst.adminKey != "" {
+		body["admin_key"] = inst.adminKey
+	}
+
+	for attempt := 0; ; attempt++ {
+		sessionID, handleID, err := inst.controlHandles()
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := inst.transport.Send(JanusRequest{
+			Janus:     "message",
+			SessionID: sessionID,
+			HandleID:  handleID,
+			Body:      body,
+		})
+		if err != nil {
+			// 会话或句柄已失效时重建一次
+			var janusErr *JanusError
+			if attempt == 0 && errors.As(err, &janusErr) &&
+				(janusErr.Code == janusErrorSessionNotFound || janusErr.Code == janusErrorHandleNotFound) {
+				inst.resetControlHandles()
+				continue
+			}
+			return nil, err
+		}
+
+		if response.PluginData == nil {
+			return nil, fmt.Errorf("invalid plugin response: %s", response.Janus)
+		}
+		var pluginErr janusPluginError
+		if err := json.Unmarshal(response.PluginData.Data, &pluginErr); err == nil && pluginErr.Code != 0 {
+			return nil, &pluginErr
+		}
+		return response.PluginData.Data, nil
+	}
+}
+
+// 创建RTP挂载点
+func (inst *janusInstance) createMountpoint(id int, description, codec string, videoPort, audioPort int) (models.JanusMountpoint, error) {
+	pt, rtpmap, fmtp := videoCodecParams(codec)
+	body := map[string]interface{}{
+		"request":     "create",
+		"type":        "rtp",
+		"id":          id,
+		"name":        description,
+		"description": description,
+		"is_private":  false,
+		"permanent":   false,
+		"video":       true,
+		"videoport":   videoPort,
+		"videopt":     pt,
+		"videortpmap": rtpmap,
+		"audio":       audioPort > 0,
+	}
+	if fmtp != "" {
+		body["videofmtp"] = fmtp
+	}
+	if audioPort > 0 {
+		body["audioport"] = audioPort
+		body["audiopt"] = 111
+		body["audiortpmap"] = "opus/48000/2"
+	}
+
+	data, err := inst.pluginRequest(body)
+	if err != nil {
+		return models.JanusMountpoint{}, err
+	}
+
+	var created struct {
+		Stream janusStreamInfo `json:"stream"`
+	}
+	if err := json.Unmarshal(data, &created); err != nil {
+		return models.JanusMountpoint{}, fmt.Errorf("invalid create response: %w", err)
+	}
+
+	mountpoint := created.Stream.mountpoint()
+	if mountpoint.ID == 0 {
+		mountpoint.ID = id
+	}
+	if mountpoint.VideoPort == 0 {
+		mountpoint.VideoPort = videoPort
+	}
+	if mountpoint.AudioPort == 0 {
+		mountpoint.AudioPort = audioPort
+	}
+	mountpoint.Type = "rtp"
+	mountpoint.Description = description
+	mountpoint.Enabled = true
+	mountpoint.Instance = inst.name
+	return mountpoint, nil
+}
+
+// 销毁挂载点，挂载点不存在视为成功
+func (inst *janusInstance) destroyMountpoint(id int) error {
+	_, err := inst.pluginRequest(map[string]interface{}{
+		"request":   "destroy",
+		"id":        id,
+		"permanent": false,
+	})
+	var pluginErr *janusPluginError
+	if errors.As(err, &pluginErr) && pluginErr.Code == janusStreamingErrorNoSuchMountpoint {
+		return nil
+	}
+	return err
+}
+
+// 获取Janus中的所有挂载点
+func (inst *janusInstance) listMountpoints() ([]models.JanusMountpoint, error) {
+	data, err := inst.pluginRequest(map[string]interface{}{
+		"request": "list",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		List []janusStreamInfo `json:"list"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid list response: %w", err)
+	}
+
+	mountpoints := make([]models.JanusMountpoint, 0, len(list.List))
+	for _, info := range list.List {
+		mountpoint := info.mountpoint()
+		mountpoint.Instance = inst.name
+		mountpoints = append(mountpoints, mountpoint)
+	}
+	return mountpoints, nil
+}
+
+// 获取挂载点详情
+func (inst *janusInstance) mountpointInfo(id int) (models.JanusMountpoint, error) {
+	data, err := inst.pluginRequest(map[string]interface{}{
+		"request": "info",
+		"id":      id,
+	})
+	if err != nil {
+		return models.JanusMountpoint{}, err
+	}
+
+	var info struct {
+		Info janusStreamInfo `json:"info"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return models.JanusMountpoint{}, fmt.Errorf("invalid info response: %w", err)
+	}
+	mountpoint := info.Info.mountpoint()
+	mountpoint.Instance = inst.name
+	return mountpoint, nil
+}
+
+// 调用Admin API，返回响应中的info
+func (inst *janusInstance) adminRequest(request string, sessionID, handleID int64) (json.RawMessage, error) {
+	if inst.adminURL == "" {
+		return nil, errJanusAdminDisabled
+	}
+
+	endpoint := strings.TrimRight(inst.adminURL, "/")
+	if sessionID != 0 {
+		endpoint += "/" + strconv.FormatInt(sessionID, 10)
+		if handleID != 0 {
+			endpoint += "/" + strconv.FormatInt(handleID, 10)
+		}
+	}
+
+	body := map[string]interface{}{
+		"janus":       request,
+		"transaction": newTransaction(),
+	}
+	if inst.adminSecret != "" {
+		body["admin_secret"] = inst.adminSecret
+	}
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := inst.adminClient.Post(endpoint, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send admin request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Janus string          `json:"janus"`
+		Info  json.RawMessage `json:"info"`
+		Error *JanusError     `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode admin response: %w", err)
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+	return response.Info, nil
+}
@@ -1,11 +1,14 @@
 package services
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"remote-ctrl-robot/internal/models"
@@ -13,30 +16,85 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// Janus默认值
+const (
+	DefaultJanusBasePath   = "/janus"
+	DefaultJanusRTPPortMin = 10000
+	DefaultJanusRTPPortMax = 10999
+	DefaultJanusVideoCodec = "h264"
+
+	DefaultJanusKeepaliveInterval = 25 * time.Second // Janus默认60秒无keepalive即超时
+	DefaultJanusInactiveGrace     = 2 * time.Minute  // 机器人断开后保留挂载点的时间，期间重连可复用
+	DefaultJanusBatchConcurrency  = 4                // 批量注册时同时进行的注册数
+	DefaultJanusHealthInterval    = 10 * time.Second // 实例健康检查间隔
+	DefaultJanusFailureThreshold  = 3                // 连续失败多少次判定实例故障
+)
+
+// Janus错误码
+const (
+	janusErrorSessionNotFound = 458 // 会话不存在
+	janusErrorHandleNotFound  = 459 // 句柄不存在
+
+	janusStreamingErrorNoSuchMountpoint = 455 // 挂载点不存在
+	janusStreamingErrorCantCreate       = 456 // 挂载点无法创建（ID已存在等）
+)
+
+// 挂载点描述前缀，用于对账时识别本服务创建的挂载点
+const janusDescriptionPrefix = "robot:"
+
 type JanusService struct {
 	HTTPURL      string
 	WebSocketURL string
 	StreamID     int
-	HTTPClient   *http.Client
-	streams      map[string]*models.WebRTCStream // UCode -> WebRTCStream
+	AutoRegister bool                                       // 有视频源的机器人注册时自动创建流
+	streams      map[string]map[string]*models.WebRTCStream // UCode -> 视频源 -> WebRTCStream
 	mutex        sync.RWMutex
+
+	config    models.JanusConfig
+	instances []*janusInstance // 按配置顺序，第一个为主实例
+
+	// 进行中的注册，键为 UCode/视频源
+	registerMutex sync.Mutex
+	registering   map[string]*registerCall
+
+	listenerMutex sync.Mutex
+	listener      JanusEventListener
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type JanusRequest struct {
-	Janus     string      `json:"janus"`
-	SessionID int         `json:"session_id,omitempty"`
-	HandleID  int         `json:"handle_id,omitempty"`
-	Body      interface{} `json:"body,omitempty"`
-	JSEP      interface{} `json:"jsep,omitempty"`
+	Janus       string      `json:"janus"`
+	Transaction string      `json:"transaction"`
+	SessionID   int64       `json:"session_id,omitempty"`
+	HandleID    int64       `json:"handle_id,omitempty"`
+	Plugin      string      `json:"plugin,omitempty"`
+	Body        interface{} `json:"body,omitempty"`
+	JSEP        interface{} `json:"jsep,omitempty"`
+	Candidate   interface{} `json:"candidate,omitempty"`
 }
 
 type JanusResponse struct {
-	Janus     string      `json:"janus"`
-	SessionID int         `json:"session_id,omitempty"`
-	HandleID  int         `json:"handle_id,omitempty"`
-	Data      interface{} `json:"data,omitempty"`
-	JSEP      interface{} `json:"jsep,omitempty"`
-	Error     *JanusError `json:"error,omitempty"`
+	Janus       string           `json:"janus"`
+	Transaction string           `json:"transaction,omitempty"`
+	SessionID   int64            `json:"session_id,omitempty"`
+	Sender      int64            `json:"sender,omitempty"`
+	Data        json.RawMessage  `json:"data,omitempty"`
+	PluginData  *JanusPluginData `json:"plugindata,omitempty"`
+	JSEP        json.RawMessage  `json:"jsep,omitempty"`
+	Candidate   json.RawMessage  `json:"candidate,omitempty"`
+	Error       *JanusError      `json:"error,omitempty"`
+
+	// media/slowlink事件
+	Type      string `json:"type,omitempty"`
+	Receiving *bool  `json:"receiving,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type JanusPluginData struct {
+	Plugin string          `json:"plugin"`
+	Data   json.RawMessage `json:"data"`
 }
 
 type JanusError struct {
@@ -44,144 +102,601 @@ type JanusError struct {
 	Reason string `json:"reason"`
 }
 
-func NewJanusService(httpURL, wsURL string, streamID int) *JanusService {
-	return &JanusService{
-		HTTPURL:      httpURL,
-		WebSocketURL: wsURL,
-		StreamID:     streamID,
-		HTTPClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-		streams: make(map[string]*models.WebRTCStream),
+func (e *JanusError) Error() string {
+	return fmt.Sprintf("janus error %d: %s", e.Code, e.Reason)
+}
+
+// streaming插件的挂载点描述
+type janusStreamInfo struct {
+	ID          int    `json:"id"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Enabled     *bool  `json:"enabled"`
+	VideoPort   int    `json:"video_port"`
+	AudioPort   int    `json:"audio_port"`
+	Viewers     int    `json:"viewers"`
+	VideoAgeMs  *int64 `json:"video_age_ms"` // 旧版本Janus
+	Media       []struct {
+		Type  string `json:"type"`
+		Port  int    `json:"port"`
+		AgeMs *int64 `json:"age_ms"`
+	} `json:"media"`
+}
+
+func (i janusStreamInfo) mountpoint() models.JanusMountpoint {
+	mountpoint := models.JanusMountpoint{
+		ID:          i.ID,
+		Type:        i.Type,
+		Description: i.Description,
+		Enabled:     i.Enabled == nil || *i.Enabled,
+		VideoPort:   i.VideoPort,
+		AudioPort:   i.AudioPort,
+		Viewers:     i.Viewers,
+		AgeMs:       -1,
+	}
+	if i.VideoAgeMs != nil {
+		mountpoint.AgeMs = *i.VideoAgeMs
+	}
+	for _, media := range i.Media {
+		switch media.Type {
+		case "video":
+			if mountpoint.VideoPort == 0 {
+				mountpoint.VideoPort = media.Port
+			}
+			if media.AgeMs != nil {
+				mountpoint.AgeMs = *media.AgeMs
+			}
+		case "audio":
+			if mountpoint.AudioPort == 0 {
+				mountpoint.AudioPort = media.Port
+			}
+		}
 	}
+	return mountpoint
 }
 
-// 创建会话
-func (js *JanusService) CreateSession() (int, error) {
-	req := JanusRequest{
-		Janus: "create",
+// RTP端口池，按偶数端口分配
+type rtpPortPool struct {
+	mutex    sync.Mutex
+	min, max int
+	used     map[int]bool
+}
+
+func newRTPPortPool(portRange models.JanusPortRange) *rtpPortPool {
+	min, max := portRange.Min, portRange.Max
+	if min <= 0 || max <= min {
+		min, max = DefaultJanusRTPPortMin, DefaultJanusRTPPortMax
 	}
+	if min%2 != 0 {
+		min++
+	}
+	return &rtpPortPool{min: min, max: max, used: make(map[int]bool)}
+}
 
-	response, err := js.sendRequest(req)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create session: %w", err)
+// 分配n个端口
+func (p *rtpPortPool) allocate(n int) ([]int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ports := make([]int, 0, n)
+	for port := p.min; port <= p.max && len(ports) < n; port += 2 {
+		if !p.used[port] {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) < n {
+		return nil, models.Errorf(models.ErrorCodeUnavailable, "no free RTP ports in range %d-%d", p.min, p.max)
+	}
+	for _, port := range ports {
+		p.used[port] = true
+	}
+	return ports, nil
+}
+
+func (p *rtpPortPool) reserve(ports ...int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, port := range ports {
+		if port > 0 {
+			p.used[port] = true
+		}
+	}
+}
+
+func (p *rtpPortPool) release(ports ...int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, port := range ports {
+		delete(p.used, port)
+	}
+}
+
+// 挂载点ID池，从配置的起始ID向上分配
+type mountpointIDPool struct {
+	mutex sync.Mutex
+	first int
+	used  map[int]bool
+}
+
+func newMountpointIDPool(first int) *mountpointIDPool {
+	if first <= 0 {
+		first = 1
+	}
+	return &mountpointIDPool{first: first, used: make(map[int]bool)}
+}
+
+// 分配未使用的ID，跳过exclude中的ID
+func (p *mountpointIDPool) allocate(exclude map[int]bool) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	id := p.first
+	for p.used[id] || exclude[id] {
+		id++
+	}
+	p.used[id] = true
+	return id
+}
+
+func (p *mountpointIDPool) reserve(id int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.used[id] = true
+}
+
+func (p *mountpointIDPool) release(id int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.used, id)
+}
+
+// 进行中的注册，同一视频源的并发注册共享结果
+type registerCall struct {
+	done   chan struct{}
+	stream *models.WebRTCStream
+	err    error
+}
+
+func NewJanusService(config models.JanusConfig) *JanusService {
+	return NewJanusServiceWithTransport(config, nil)
+}
+
+// NewJanusServiceWithTransport 使用指定传输层创建服务，transport用于主实例，为nil时按配置创建
+func NewJanusServiceWithTransport(config models.JanusConfig, transport JanusTransport) *JanusService {
+	if config.BasePath == "" {
+		config.BasePath = DefaultJanusBasePath
+	}
+	if config.VideoCodec == "" {
+		config.VideoCodec = DefaultJanusVideoCodec
+	}
+	if config.KeepaliveInterval <= 0 {
+		config.KeepaliveInterval = DefaultJanusKeepaliveInterval
+	}
+	if config.BatchConcurrency <= 0 {
+		config.BatchConcurrency = DefaultJanusBatchConcurrency
+	}
+	if config.HealthInterval <= 0 {
+		config.HealthInterval = DefaultJanusHealthInterval
 	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = DefaultJanusFailureThreshold
+	}
+
+	instances := make([]*janusInstance, 0)
+	for i, instance := range janusInstanceConfigs(config) {
+		var instanceTransport JanusTransport
+		if i == 0 {
+			instanceTransport = transport
+		}
+		instances = append(instances, newJanusInstance(config, instance, instanceTransport))
+	}
+	primary := instances[0]
 
-	if response.Error != nil {
-		return 0, fmt.Errorf("janus error: %s", response.Error.Reason)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JanusService{
+		HTTPURL:      primary.httpURL,
+		WebSocketURL: primary.webSocketURL,
+		StreamID:     config.StreamID,
+		AutoRegister: config.AutoRegister,
+		streams:      make(map[string]map[string]*models.WebRTCStream),
+		config:       config,
+		instances:    instances,
+		registering:  make(map[string]*registerCall),
+		ctx:          ctx,
+		cancel:       cancel,
 	}
+}
 
-	if data, ok := response.Data.(map[string]interface{}); ok {
-		if id, ok := data["id"].(float64); ok {
-			return int(id), nil
+// 按名称查找实例
+func (js *JanusService) instance(name string) *janusInstance {
+	for _, inst := range js.instances {
+		if inst.name == name {
+			return inst
 		}
 	}
+	return nil
+}
 
-	return 0, fmt.Errorf("invalid session response")
+// 选择负载最低的健康实例，exclude为需要避开的实例
+func (js *JanusService) pickInstance(exclude *janusInstance) (*janusInstance, error) {
+	js.mutex.RLock()
+	counts := make(map[string]int)
+	js.eachStream(func(stream *models.WebRTCStream) {
+		counts[stream.Instance]++
+	})
+	js.mutex.RUnlock()
+
+	var best *janusInstance
+	for _, inst := range js.instances {
+		if inst == exclude || !inst.healthy() {
+			continue
+		}
+		if best == nil || inst.load(counts[inst.name]) < best.load(counts[best.name]) {
+			best = inst
+		}
+	}
+	if best == nil {
+		return nil, models.NewError(models.ErrorCodeUnavailable, "no healthy janus instance")
+	}
+	return best, nil
 }
 
-// 附加流插件
-func (js *JanusService) AttachStreamingPlugin(sessionID int) (int, error) {
-	req := JanusRequest{
-		Janus:     "attach",
-		SessionID: sessionID,
-		Body: map[string]interface{}{
-			"plugin": "janus.plugin.streaming",
-		},
+// 从create/attach响应中解析ID
+func responseID(response *JanusResponse) (int64, error) {
+	var data struct {
+		ID int64 `json:"id"`
 	}
+	if err := json.Unmarshal(response.Data, &data); err != nil || data.ID == 0 {
+		return 0, fmt.Errorf("invalid response: missing id")
+	}
+	return data.ID, nil
+}
 
-	response, err := js.sendRequest(req)
-	if err != nil {
-		return 0, fmt.Errorf("failed to attach streaming plugin: %w", err)
+// streaming插件错误
+type janusPluginError struct {
+	Code   int    `json:"error_code"`
+	Reason string `json:"error"`
+}
+
+func (e *janusPluginError) Error() string {
+	return fmt.Sprintf("streaming plugin error %d: %s", e.Code, e.Reason)
+}
+
+// 视频编码参数
+func videoCodecParams(codec string) (pt int, rtpmap, fmtp string) {
+	switch strings.ToLower(codec) {
+	case "vp8":
+		return 96, "VP8/90000", ""
+	case "vp9":
+		return 96, "VP9/90000", ""
+	default:
+		return 96, "H264/90000", "profile-level-id=42e01f;packetization-mode=1"
 	}
+}
+
+// 流标识，用于日志和对账结果
+func streamKey(ucode, source string) string {
+	return ucode + "/" + source
+}
 
-	if response.Error != nil {
-		return 0, fmt.Errorf("janus error: %s", response.Error.Reason)
+// 视频源名称，未指定时使用默认源
+func sourceName(source string) string {
+	if source == "" {
+		return models.DefaultVideoSource
 	}
+	return source
+}
+
+// 挂载点描述，格式为 robot:<ucode>/<source>
+func mountpointDescription(ucode, source string) string {
+	return janusDescriptionPrefix + streamKey(ucode, source)
+}
+
+// 从挂载点描述解析UCode和视频源，兼容不带视频源的旧格式
+func parseMountpointDescription(description string) (string, string, bool) {
+	if !strings.HasPrefix(description, janusDescriptionPrefix) {
+		return "", "", false
+	}
+	ucode, source, _ := strings.Cut(strings.TrimPrefix(description, janusDescriptionPrefix), "/")
+	if ucode == "" {
+		return "", "", false
+	}
+	return ucode, sourceName(source), true
+}
 
-	if data, ok := response.Data.(map[string]interface{}); ok {
-		if id, ok := data["id"].(float64); ok {
-			return int(id), nil
+// 遍历所有流，调用方持有 js.mutex
+func (js *JanusService) eachStream(fn func(stream *models.WebRTCStream)) {
+	for _, sources := range js.streams {
+		for _, stream := range sources {
+			fn(stream)
 		}
 	}
+}
 
-	return 0, fmt.Errorf("invalid handle response")
+// 获取流，调用方持有 js.mutex
+func (js *JanusService) lookupStream(ucode, source string) (*models.WebRTCStream, bool) {
+	stream, exists := js.streams[ucode][source]
+	return stream, exists
 }
 
-// 为UCode注册WebRTC流
-func (js *JanusService) RegisterWebRTCStream(ucode string) (*models.WebRTCStream, error) {
-	js.mutex.Lock()
-	defer js.mutex.Unlock()
+// 保存流并占用其所在实例的挂载点ID，调用方持有 js.mutex
+func (js *JanusService) storeStream(stream *models.WebRTCStream) {
+	if inst := js.instance(stream.Instance); inst != nil {
+		inst.ids.reserve(stream.StreamID)
+	}
+	sources, exists := js.streams[stream.UCode]
+	if !exists {
+		sources = make(map[string]*models.WebRTCStream)
+		js.streams[stream.UCode] = sources
+	}
+	sources[stream.Source] = stream
+}
 
-	// 检查是否已存在
-	if stream, exists := js.streams[ucode]; exists {
-		if stream.Status == "active" {
-			return stream, nil
+// 移除流并释放所在实例的端口和挂载点ID，调用方持有 js.mutex
+func (js *JanusService) removeStream(stream *models.WebRTCStream) {
+	js.detachStream(stream)
+	js.releaseStream(stream)
+}
+
+// 只从流表中移除，端口和挂载点ID保持占用直到挂载点销毁，调用方持有 js.mutex
+func (js *JanusService) detachStream(stream *models.WebRTCStream) {
+	delete(js.streams[stream.UCode], stream.Source)
+	if len(js.streams[stream.UCode]) == 0 {
+		delete(js.streams, stream.UCode)
+	}
+}
+
+// 释放流占用的端口和挂载点ID，不需要持有 js.mutex
+func (js *JanusService) releaseStream(stream *models.WebRTCStream) {
+	if inst := js.instance(stream.Instance); inst != nil {
+		inst.ports.release(stream.VideoPort, stream.AudioPort)
+		inst.ids.release(stream.StreamID)
+	}
+}
+
+// 销毁已移出流表的流的挂载点，失败时若视频源未被重新注册则放回流表
+func (js *JanusService) destroyDetached(stream *models.WebRTCStream) error {
+	if err := js.destroyStreamMountpoint(stream); err != nil {
+		js.mutex.Lock()
+		if _, exists := js.lookupStream(stream.UCode, stream.Source); !exists {
+			js.storeStream(stream)
 		}
+		js.mutex.Unlock()
+		return err
 	}
+	js.releaseStream(stream)
+	return nil
+}
 
-	// 创建新的Janus会话
-	sessionID, err := js.CreateSession()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %w", err)
+// 机器人的流按视频源名称排序，调用方持有 js.mutex
+func (js *JanusService) robotStreams(ucode string) []*models.WebRTCStream {
+	streams := make([]*models.WebRTCStream, 0, len(js.streams[ucode]))
+	for _, stream := range js.streams[ucode] {
+		streams = append(streams, stream)
 	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Source < streams[j].Source
+	})
+	return streams
+}
 
-	// 附加流插件
-	handleID, err := js.AttachStreamingPlugin(sessionID)
+// 构建流信息
+func (js *JanusService) newStream(inst *janusInstance, ucode, source, codec string, mountpoint models.JanusMountpoint) *models.WebRTCStream {
+	sessionID, handleID := inst.currentSession()
+
+	return &models.WebRTCStream{
+		UCode:       ucode,
+		Source:      source,
+		StreamID:    mountpoint.ID,
+		Instance:    inst.name,
+		SessionID:   sessionID,
+		HandleID:    handleID,
+		Description: mountpoint.Description,
+		RTPHost:     inst.rtpHost,
+		VideoPort:   mountpoint.VideoPort,
+		AudioPort:   mountpoint.AudioPort,
+		VideoCodec:  codec,
+		PlayURL:     fmt.Sprintf("%s?stream=%d", inst.webSocketURL, mountpoint.ID),
+		PushURL:     fmt.Sprintf("rtp://%s:%d", inst.rtpHost, mountpoint.VideoPort),
+		Status:      "active",
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+}
+
+// 在实例上为机器人的视频源分配端口和ID并创建挂载点，不需要持有 js.mutex
+func (js *JanusService) provisionMountpoint(inst *janusInstance, ucode, source, codec string) (models.JanusMountpoint, error) {
+	atomic.AddInt32(&inst.provisioning, 1)
+	defer atomic.AddInt32(&inst.provisioning, -1)
+
+	count := 1
+	if js.config.Audio {
+		count = 2
+	}
+	ports, err := inst.ports.allocate(count)
 	if err != nil {
-		return nil, fmt.Errorf("failed to attach streaming plugin: %w", err)
+		return models.JanusMountpoint{}, err
+	}
+	videoPort, audioPort := ports[0], 0
+	if len(ports) > 1 {
+		audioPort = ports[1]
 	}
 
-	// 生成流ID（使用递增的方式）
-	streamID := js.StreamID + len(js.streams)
+	description := mountpointDescription(ucode, source)
+	taken := make(map[int]bool)
+	for attempt := 0; attempt < 8; attempt++ {
+		id := inst.ids.allocate(taken)
+		mountpoint, err := inst.createMountpoint(id, description, codec, videoPort, audioPort)
+		if err == nil {
+			return mountpoint, nil
+		}
+		inst.ids.release(id)
 
-	// 构建URL
-	playURL := fmt.Sprintf("%s?session=%d&handle=%d&stream=%d",
-		js.WebSocketURL, sessionID, handleID, streamID)
+		// ID已被其他挂载点占用时换下一个
+		var pluginErr *janusPluginError
+		if errors.As(err, &pluginErr) && pluginErr.Code == janusStreamingErrorCantCreate {
+			taken[id] = true
+			continue
+		}
+		inst.ports.release(ports...)
+		return models.JanusMountpoint{}, err
+	}
+	inst.ports.release(ports...)
+	return models.JanusMountpoint{}, fmt.Errorf("failed to find a free mountpoint id")
+}
+
+// 为UCode的默认视频源注册WebRTC流
+func (js *JanusService) RegisterWebRTCStream(ucode string) (*models.WebRTCStream, error) {
+	return js.RegisterWebRTCSource(ucode, models.VideoSource{})
+}
+
+// RegisterWebRTCSource 为机器人的指定视频源注册WebRTC流，每个视频源独立挂载点；
+// 同一视频源的并发注册合并为一次
+func (js *JanusService) RegisterWebRTCSource(ucode string, source models.VideoSource) (*models.WebRTCStream, error) {
+	key := streamKey(ucode, sourceName(source.Name))
+
+	js.registerMutex.Lock()
+	if call, exists := js.registering[key]; exists {
+		js.registerMutex.Unlock()
+		<-call.done
+		return call.stream, call.err
+	}
+	call := &registerCall{done: make(chan struct{})}
+	js.registering[key] = call
+	js.registerMutex.Unlock()
 
-	pushURL := fmt.Sprintf("%s/push?session=%d&handle=%d&stream=%d",
-		js.HTTPURL, sessionID, handleID, streamID)
+	call.stream, call.err = js.registerSource(ucode, source)
 
-	// 创建流信息
-	stream := &models.WebRTCStream{
-		UCode:     ucode,
-		StreamID:  streamID,
-		SessionID: int64(sessionID),
-		HandleID:  int64(handleID),
-		PlayURL:   playURL,
-		PushURL:   pushURL,
-		Status:    "active",
-		CreatedAt: time.Now().UnixMilli(),
+	js.registerMutex.Lock()
+	delete(js.registering, key)
+	js.registerMutex.Unlock()
+	close(call.done)
+
+	return call.stream, call.err
+}
+
+// 注册视频源，Janus请求在 js.mutex 之外进行
+func (js *JanusService) registerSource(ucode string, source models.VideoSource) (*models.WebRTCStream, error) {
+	name := sourceName(source.Name)
+	codec := source.Codec
+	if codec == "" {
+		codec = js.config.VideoCodec
+	}
+
+	// 检查是否已存在
+	js.mutex.RLock()
+	stream, exists := js.lookupStream(ucode, name)
+	active := exists && stream.Status == "active"
+	js.mutex.RUnlock()
+	if active {
+		return stream, nil
 	}
 
-	// 保存流信息
-	js.streams[ucode] = stream
+	if exists {
+		// 复用已有挂载点，所在实例故障时改到其他实例创建
+		err := fmt.Errorf("janus instance %s unavailable", stream.Instance)
+		if inst := js.instance(stream.Instance); inst != nil && inst.healthy() {
+			_, err = inst.mountpointInfo(stream.StreamID)
+		}
+
+		js.mutex.Lock()
+		current, stillExists := js.lookupStream(ucode, name)
+		if stillExists && current != stream {
+			// 期间已被对账替换，重新检查
+			js.mutex.Unlock()
+			return js.registerSource(ucode, source)
+		}
+		if stillExists {
+			if err == nil {
+				stream.Status = "active"
+				stream.InactiveSince = 0
+				js.mutex.Unlock()
+				return stream, nil
+			}
+			js.removeStream(stream)
+		}
+		js.mutex.Unlock()
+	}
+
+	inst, err := js.pickInstance(nil)
+	if err != nil {
+		return nil, err
+	}
+	mountpoint, err := js.provisionMountpoint(inst, ucode, name, codec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mountpoint on %s: %w", inst.name, err)
+	}
+
+	stream = js.newStream(inst, ucode, name, codec, mountpoint)
+	js.mutex.Lock()
+	js.storeStream(stream)
+	js.mutex.Unlock()
 
 	log.Info().
 		Str("ucode", ucode).
-		Int("session_id", sessionID).
-		Int("handle_id", handleID).
-		Int("stream_id", streamID).
+		Str("source", name).
+		Str("instance", inst.name).
+		Int("stream_id", stream.StreamID).
+		Int("video_port", stream.VideoPort).
+		Int("audio_port", stream.AudioPort).
 		Msg("Registered WebRTC stream for UCode")
 
 	return stream, nil
 }
 
-// 获取UCode的WebRTC播放地址列表
+// RegisterWebRTCSources 注册机器人上报的全部视频源，返回成功的流和失败的视频源
+func (js *JanusService) RegisterWebRTCSources(ucode string, sources []models.VideoSource) ([]*models.WebRTCStream, map[string]error) {
+	if len(sources) == 0 {
+		sources = []models.VideoSource{{}}
+	}
+
+	streams := make([]*models.WebRTCStream, 0, len(sources))
+	failed := make(map[string]error)
+	for _, source := range sources {
+		stream, err := js.RegisterWebRTCSource(ucode, source)
+		if err != nil {
+			failed[sourceName(source.Name)] = err
+			continue
+		}
+		streams = append(streams, stream)
+	}
+	return streams, failed
+}
+
+// 获取UCode的WebRTC播放地址列表，每个活跃视频源一个
 func (js *JanusService) GetWebRTCPlayURLs(ucode string) ([]string, error) {
+	sources, err := js.GetWebRTCSources(ucode)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(sources))
+	for _, source := range sources {
+		urls = append(urls, source.PlayURL)
+	}
+	return urls, nil
+}
+
+// GetWebRTCSources 获取UCode的活跃视频源
+func (js *JanusService) GetWebRTCSources(ucode string) ([]models.WebRTCSourceInfo, error) {
 	js.mutex.RLock()
 	defer js.mutex.RUnlock()
 
-	stream, exists := js.streams[ucode]
-	if !exists {
+	if _, exists := js.streams[ucode]; !exists {
 		return nil, fmt.Errorf("no stream found for UCode: %s", ucode)
 	}
 
-	if stream.Status != "active" {
+	sources := make([]models.WebRTCSourceInfo, 0, len(js.streams[ucode]))
+	for _, stream := range js.robotStreams(ucode) {
+		if stream.Status == "active" {
+			sources = append(sources, stream.SourceInfo())
+		}
+	}
+	if len(sources) == 0 {
 		return nil, fmt.Errorf("stream is not active for UCode: %s", ucode)
 	}
-
-	return []string{stream.PlayURL}, nil
+	return sources, nil
 }
 
 // 获取所有活跃流的播放地址
@@ -190,20 +705,22 @@ func (js *JanusService) GetAllWebRTCPlayURLs() map[string][]string {
 	defer js.mutex.RUnlock()
 
 	result := make(map[string][]string)
-	for ucode, stream := range js.streams {
-		if stream.Status == "active" {
-			result[ucode] = []string{stream.PlayURL}
+	for ucode := range js.streams {
+		for _, stream := range js.robotStreams(ucode) {
+			if stream.Status == "active" {
+				result[ucode] = append(result[ucode], stream.PlayURL)
+			}
 		}
 	}
 	return result
 }
 
-// 获取UCode的推流地址
-func (js *JanusService) GetWebRTCPushURL(ucode string) (string, error) {
+// 获取UCode视频源的推流地址
+func (js *JanusService) GetWebRTCPushURL(ucode, source string) (string, error) {
 	js.mutex.RLock()
 	defer js.mutex.RUnlock()
 
-	stream, exists := js.streams[ucode]
+	stream, exists := js.lookupStream(ucode, sourceName(source))
 	if !exists {
 		return "", fmt.Errorf("no stream found for UCode: %s", ucode)
 	}
@@ -215,106 +732,316 @@ func (js *JanusService) GetWebRTCPushURL(ucode string) (string, error) {
 	return stream.PushURL, nil
 }
 
-// 停用UCode的流
+// 停用UCode的全部视频源
 func (js *JanusService) DeactivateStream(ucode string) error {
 	js.mutex.Lock()
 	defer js.mutex.Unlock()
 
-	stream, exists := js.streams[ucode]
+	sources, exists := js.streams[ucode]
 	if !exists {
 		return fmt.Errorf("no stream found for UCode: %s", ucode)
 	}
 
-	stream.Status = "inactive"
-	log.Info().Str("ucode", ucode).Msg("Deactivated WebRTC stream")
+	now := time.Now().UnixMilli()
+	for _, stream := range sources {
+		if stream.Status != "inactive" {
+			stream.Status = "inactive"
+			stream.InactiveSince = now
+		}
+	}
+	log.Info().Str("ucode", ucode).Int("sources", len(sources)).Msg("Deactivated WebRTC stream")
 	return nil
 }
 
-// 删除UCode的流
-func (js *JanusService) DeleteStream(ucode string) error {
+// 销毁流在所在实例上的挂载点，实例故障时跳过，恢复后对账会重新接管并按宽限期清理
+func (js *JanusService) destroyStreamMountpoint(stream *models.WebRTCStream) error {
+	inst := js.instance(stream.Instance)
+	if inst == nil || !inst.healthy() {
+		return nil
+	}
+	return inst.destroyMountpoint(stream.StreamID)
+}
+
+// 删除UCode的流并销毁挂载点，source为空时删除全部视频源
+func (js *JanusService) DeleteStream(ucode, source string) error {
+	// 先移出流表再在锁外销毁挂载点，期间的注册会创建新的挂载点
 	js.mutex.Lock()
-	defer js.mutex.Unlock()
+	streams := js.robotStreams(ucode)
+	if source != "" {
+		stream, exists := js.lookupStream(ucode, source)
+		if !exists {
+			js.mutex.Unlock()
+			return models.Errorf(models.ErrorCodeNotFound, "no stream found for UCode: %s source: %s", ucode, source)
+		}
+		streams = []*models.WebRTCStream{stream}
+	}
+	for _, stream := range streams {
+		js.detachStream(stream)
+	}
+	js.mutex.Unlock()
 
-	if _, exists := js.streams[ucode]; !exists {
-		return fmt.Errorf("no stream found for UCode: %s", ucode)
+	if len(streams) == 0 {
+		return models.Errorf(models.ErrorCodeNotFound, "no stream found for UCode: %s", ucode)
 	}
 
-	delete(js.streams, ucode)
-	log.Info().Str("ucode", ucode).Msg("Deleted WebRTC stream")
-	return nil
+	var errs []error
+	for _, stream := range streams {
+		if err := js.destroyDetached(stream); err != nil {
+			errs = append(errs, fmt.Errorf("failed to destroy mountpoint %d: %w", stream.StreamID, err))
+			continue
+		}
+		log.Info().Str("ucode", ucode).Str("source", stream.Source).Int("stream_id", stream.StreamID).Msg("Deleted WebRTC stream")
+	}
+	return errors.Join(errs...)
 }
 
-// 获取所有流信息
-func (js *JanusService) GetAllStreams() map[string]*models.WebRTCStream {
+// 获取所有流信息的副本，UCode -> 视频源 -> 流
+func (js *JanusService) GetAllStreams() map[string]map[string]models.WebRTCStream {
 	js.mutex.RLock()
 	defer js.mutex.RUnlock()
 
-	result := make(map[string]*models.WebRTCStream)
-	for ucode, stream := range js.streams {
-		result[ucode] = stream
+	result := make(map[string]map[string]models.WebRTCStream)
+	for ucode, sources := range js.streams {
+		result[ucode] = make(map[string]models.WebRTCStream, len(sources))
+		for name, stream := range sources {
+			result[ucode][name] = *stream
+		}
 	}
 	return result
 }
 
-// 检查Janus状态
-func (js *JanusService) CheckStatus() error {
-	req := JanusRequest{
-		Janus: "info",
+// Reconcile 与所有健康实例中的挂载点对账：恢复本服务创建的挂载点，重建缺失的挂载点
+func (js *JanusService) Reconcile() (models.JanusReconcileResult, error) {
+	result := models.JanusReconcileResult{
+		Adopted:   make([]string, 0),
+		Recreated: make([]string, 0),
+		Failed:    make([]string, 0),
+		Removed:   make([]string, 0),
 	}
 
-	response, err := js.sendRequest(req)
-	if err != nil {
-		return fmt.Errorf("janus not responding: %w", err)
+	var errs []error
+	for _, inst := range js.instances {
+		if !inst.healthy() {
+			continue
+		}
+		instanceResult, err := js.reconcileInstance(inst)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", inst.name, err))
+			continue
+		}
+		result.Mountpoints += instanceResult.Mountpoints
+		result.Adopted = append(result.Adopted, instanceResult.Adopted...)
+		result.Recreated = append(result.Recreated, instanceResult.Recreated...)
+		result.Failed = append(result.Failed, instanceResult.Failed...)
+		result.Removed = append(result.Removed, instanceResult.Removed...)
 	}
+	return result, errors.Join(errs...)
+}
 
-	if response.Error != nil {
-		return fmt.Errorf("janus error: %s", response.Error.Reason)
+// 与单个实例对账
+func (js *JanusService) reconcileInstance(inst *janusInstance) (models.JanusReconcileResult, error) {
+	result := models.JanusReconcileResult{
+		Adopted:   make([]string, 0),
+		Recreated: make([]string, 0),
+		Failed:    make([]string, 0),
+		Removed:   make([]string, 0),
 	}
 
-	log.Info().Msg("Janus server is healthy")
-	return nil
-}
-
-// 发送请求到Janus
-func (js *JanusService) sendRequest(req JanusRequest) (*JanusResponse, error) {
-	jsonData, err := json.Marshal(req)
+	mountpoints, err := inst.listMountpoints()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return result, fmt.Errorf("failed to list mountpoints: %w", err)
 	}
+	result.Mountpoints = len(mountpoints)
 
-	httpReq, err := http.NewRequest("POST", js.HTTPURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	existing := make(map[int]models.JanusMountpoint, len(mountpoints))
+	for _, mountpoint := range mountpoints {
+		existing[mountpoint.ID] = mountpoint
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
+	// 本地有但Janus中缺失的流，锁内取快照，锁外重建
+	missing := make([]*models.WebRTCStream, 0)
+	js.mutex.RLock()
+	js.eachStream(func(stream *models.WebRTCStream) {
+		if stream.Instance != inst.name {
+			return
+		}
+		if mountpoint, ok := existing[stream.StreamID]; !ok || mountpoint.Description != stream.Description {
+			missing = append(missing, stream)
+		}
+	})
+	js.mutex.RUnlock()
 
-	resp, err := js.HTTPClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	for _, stream := range missing {
+		key := streamKey(stream.UCode, stream.Source)
+		mountpoint, err := js.provisionMountpoint(inst, stream.UCode, stream.Source, stream.VideoCodec)
+		if err != nil {
+			log.Error().Err(err).Str("instance", inst.name).Str("stream", key).Msg("Failed to recreate missing mountpoint")
+			js.mutex.Lock()
+			if current, exists := js.lookupStream(stream.UCode, stream.Source); exists && current == stream {
+				js.removeStream(stream)
+			}
+			js.mutex.Unlock()
+			result.Failed = append(result.Failed, key)
+			continue
+		}
+
+		recreated := js.newStream(inst, stream.UCode, stream.Source, stream.VideoCodec, mountpoint)
+		js.mutex.Lock()
+		current, exists := js.lookupStream(stream.UCode, stream.Source)
+		if !exists || current != stream {
+			// 重建期间流已被删除、迁移或重新注册，新挂载点作废
+			js.mutex.Unlock()
+			inst.destroyMountpoint(mountpoint.ID)
+			js.releaseStream(recreated)
+			continue
+		}
+		recreated.Status = stream.Status
+		recreated.InactiveSince = stream.InactiveSince
+		js.removeStream(stream)
+		js.storeStream(recreated)
+		js.mutex.Unlock()
+
+		existing[mountpoint.ID] = mountpoint
+		result.Recreated = append(result.Recreated, key)
 	}
-	defer resp.Body.Close()
 
-	var response JanusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	// Janus中有但本地没有记录的挂载点（例如服务重启）
+	stale := make([]models.JanusMountpoint, 0)
+	orphans := make([]models.JanusMountpoint, 0)
+	js.mutex.RLock()
+	known := make(map[int]bool)
+	js.eachStream(func(stream *models.WebRTCStream) {
+		if stream.Instance == inst.name {
+			known[stream.StreamID] = true
+		}
+	})
+	for _, mountpoint := range mountpoints {
+		if known[mountpoint.ID] {
+			continue
+		}
+		ucode, source, ok := parseMountpointDescription(mountpoint.Description)
+		if !ok {
+			continue
+		}
+		if stream, exists := js.lookupStream(ucode, source); exists {
+			// 实例故障期间已迁移到其他实例，旧挂载点作废
+			if stream.Instance != inst.name {
+				stale = append(stale, mountpoint)
+			}
+			continue
+		}
+		orphans = append(orphans, mountpoint)
+	}
+	js.mutex.RUnlock()
+
+	for _, mountpoint := range stale {
+		if err := inst.destroyMountpoint(mountpoint.ID); err != nil {
+			log.Warn().Err(err).Str("instance", inst.name).Int("stream_id", mountpoint.ID).Msg("Failed to destroy stale mountpoint")
+			continue
+		}
+		ucode, source, _ := parseMountpointDescription(mountpoint.Description)
+		result.Removed = append(result.Removed, streamKey(ucode, source))
 	}
 
-	return &response, nil
+	for _, mountpoint := range orphans {
+		if mountpoint.VideoPort == 0 {
+			if info, err := inst.mountpointInfo(mountpoint.ID); err == nil {
+				mountpoint.VideoPort, mountpoint.AudioPort = info.VideoPort, info.AudioPort
+			}
+		}
+
+		ucode, source, _ := parseMountpointDescription(mountpoint.Description)
+		js.mutex.Lock()
+		if _, exists := js.lookupStream(ucode, source); exists {
+			// 期间已重新注册
+			js.mutex.Unlock()
+			continue
+		}
+		inst.ports.reserve(mountpoint.VideoPort, mountpoint.AudioPort)
+		stream := js.newStream(inst, ucode, source, js.config.VideoCodec, mountpoint)
+		stream.Status = "inactive"
+		stream.InactiveSince = time.Now().UnixMilli()
+		js.storeStream(stream)
+		js.mutex.Unlock()
+		result.Adopted = append(result.Adopted, streamKey(ucode, source))
+	}
+
+	if len(result.Adopted) > 0 || len(result.Recreated) > 0 || len(result.Failed) > 0 || len(result.Removed) > 0 {
+		log.Info().
+			Str("instance", inst.name).
+			Int("mountpoints", result.Mountpoints).
+			Strs("adopted", result.Adopted).
+			Strs("recreated", result.Recreated).
+			Strs("failed", result.Failed).
+			Strs("removed", result.Removed).
+			Msg("Janus mountpoints reconciled")
+	}
+	return result, nil
 }
 
-// 批量注册WebRTC流
-func (js *JanusService) BatchRegisterWebRTCStreams(ucodes []string) map[string]*models.WebRTCStream {
-	results := make(map[string]*models.WebRTCStream)
+// ListMountpoints 获取所有健康实例中的挂载点，instance非空时只查询该实例
+func (js *JanusService) ListMountpoints(instance string) ([]models.JanusMountpoint, error) {
+	if instance != "" && js.instance(instance) == nil {
+		return nil, models.Errorf(models.ErrorCodeNotFound, "unknown janus instance: %s", instance)
+	}
 
-	for _, ucode := range ucodes {
-		stream, err := js.RegisterWebRTCStream(ucode)
-		if err != nil {
-			log.Error().Err(err).Str("ucode", ucode).Msg("Failed to register WebRTC stream")
+	mountpoints := make([]models.JanusMountpoint, 0)
+	for _, inst := range js.instances {
+		if instance != "" && inst.name != instance {
+			continue
+		}
+		if instance == "" && !inst.healthy() {
 			continue
 		}
-		results[ucode] = stream
+		list, err := inst.listMountpoints()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", inst.name, err)
+		}
+		mountpoints = append(mountpoints, list...)
+	}
+	return mountpoints, nil
+}
+
+// MountpointInfo 获取挂载点详情，instance为空时查询主实例
+func (js *JanusService) MountpointInfo(instance string, id int) (models.JanusMountpoint, error) {
+	inst := js.instances[0]
+	if instance != "" {
+		if inst = js.instance(instance); inst == nil {
+			return models.JanusMountpoint{}, models.Errorf(models.ErrorCodeNotFound, "unknown janus instance: %s", instance)
+		}
 	}
+	return inst.mountpointInfo(id)
+}
+
+// 批量注册WebRTC流，并发数受 batch_concurrency 限制，结果与ucodes顺序一致
+func (js *JanusService) BatchRegisterWebRTCStreams(ucodes []string) []models.WebRTCBatchRegisterItem {
+	results := make([]models.WebRTCBatchRegisterItem, len(ucodes))
+	limit := make(chan struct{}, js.config.BatchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, ucode := range ucodes {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, ucode string) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+
+			stream, err := js.RegisterWebRTCStream(ucode)
+			if err != nil {
+				log.Error().Err(err).Str("ucode", ucode).Msg("Failed to register WebRTC stream")
+				results[i] = models.WebRTCBatchRegisterItem{
+					UCode: ucode,
+					Code:  models.ErrorCodeOf(err),
+					Error: err.Error(),
+				}
+				return
+			}
+			results[i] = models.WebRTCBatchRegisterItem{UCode: ucode, Success: true, Stream: stream}
+		}(i, ucode)
+	}
+	wg.Wait()
 
 	return results
 }
@@ -324,38 +1051,55 @@ func (js *JanusService) GetStreamStats() map[string]interface{} {
 	js.mutex.RLock()
 	defer js.mutex.RUnlock()
 
-	total := len(js.streams)
+	total := 0
 	active := 0
 	inactive := 0
+	instances := make(map[string]int)
 
-	for _, stream := range js.streams {
+	js.eachStream(func(stream *models.WebRTCStream) {
+		total++
+		instances[stream.Instance]++
 		switch stream.Status {
 		case "active":
 			active++
 		case "inactive":
 			inactive++
 		}
-	}
+	})
 
 	return map[string]interface{}{
+		"robots":           len(js.streams),
 		"total_streams":    total,
 		"active_streams":   active,
 		"inactive_streams": inactive,
+		"instances":        instances,
 	}
 }
 
-// 清理无效流
-func (js *JanusService) CleanupInactiveStreams() int {
+// 清理停用超过宽限期的流，同时销毁对应的挂载点
+func (js *JanusService) CleanupInactiveStreams(grace time.Duration) int {
+	// 锁内移出流表，避免销毁期间被重新激活
+	deadline := time.Now().Add(-grace).UnixMilli()
+	inactive := make([]*models.WebRTCStream, 0)
 	js.mutex.Lock()
-	defer js.mutex.Unlock()
+	js.eachStream(func(stream *models.WebRTCStream) {
+		if stream.Status == "inactive" && stream.InactiveSince <= deadline {
+			inactive = append(inactive, stream)
+		}
+	})
+	for _, stream := range inactive {
+		js.detachStream(stream)
+	}
+	js.mutex.Unlock()
 
 	cleaned := 0
-	for ucode, stream := range js.streams {
-		if stream.Status == "inactive" {
-			delete(js.streams, ucode)
-			cleaned++
-			log.Info().Str("ucode", ucode).Msg("Cleaned up inactive stream")
+	for _, stream := range inactive {
+		if err := js.destroyDetached(stream); err != nil {
+			log.Error().Err(err).Str("ucode", stream.UCode).Str("source", stream.Source).Int("stream_id", stream.StreamID).Msg("Failed to destroy mountpoint")
+			continue
 		}
+		cleaned++
+		log.Info().Str("ucode", stream.UCode).Str("source", stream.Source).Msg("Cleaned up inactive stream")
 	}
 
 	return cleaned
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"remote-ctrl-robot/internal/models"
+)
+
+func TestProvisionMountpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    models.JanusConfig
+		setup     func(js *JanusService, fake *fakeJanusTransport)
+		wantErr   models.ErrorCode
+		wantID    int
+		wantVideo int
+		wantAudio int
+	}{
+		{
+			name:      "allocates first free id and port",
+			wantID:    100,
+			wantVideo: 20000,
+		},
+		{
+			name:   "allocates audio port when enabled",
+			config: models.JanusConfig{Audio: true},
+			setup: func(js *JanusService, fake *fakeJanusTransport) {
+				js.instances[0].ports.reserve(20000)
+			},
+			wantID:    100,
+			wantVideo: 20002,
+			wantAudio: 20004,
+		},
+		{
+			name: "skips ids already taken in janus",
+			setup: func(js *JanusService, fake *fakeJanusTransport) {
+				fake.addMountpoint(100, "lobby camera", 30000)
+				fake.addMountpoint(101, "robot:other/default", 30002)
+			},
+			wantID:    102,
+			wantVideo: 20000,
+		},
+		{
+			name: "skips ids held by local streams",
+			setup: func(js *JanusService, fake *fakeJanusTransport) {
+				js.instances[0].ids.reserve(100)
+			},
+			wantID:    101,
+			wantVideo: 20000,
+		},
+		{
+			name:   "fails when port range is exhausted",
+			config: models.JanusConfig{RTPPorts: models.JanusPortRange{Min: 20000, Max: 20001}},
+			setup: func(js *JanusService, fake *fakeJanusTransport) {
+				js.instances[0].ports.reserve(20000)
+			},
+			wantErr: models.ErrorCodeUnavailable,
+		},
+		{
+			name: "releases ports and id when janus rejects the mountpoint",
+			setup: func(js *JanusService, fake *fakeJanusTransport) {
+				fake.fail = func(req JanusRequest, plugin string) error {
+					if plugin == "create" {
+						return &JanusError{Code: 490, Reason: "Internal error"}
+					}
+					return nil
+				}
+			},
+			wantErr: models.ErrorCodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, fake := newTestJanusService(t, tt.config)
+			inst := js.instances[0]
+			if tt.setup != nil {
+				tt.setup(js, fake)
+			}
+			before := fake.mountpointCount()
+			usedPorts, usedIDs := len(inst.ports.used), len(inst.ids.used)
+
+			mountpoint, err := js.provisionMountpoint(inst, "r1", "default", "h264")
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("expected error, got mountpoint %+v", mountpoint)
+				}
+				if code := models.ErrorCodeOf(err); code != tt.wantErr {
+					t.Errorf("error code = %s, want %s (%v)", code, tt.wantErr, err)
+				}
+				if n := fake.mountpointCount(); n != before {
+					t.Errorf("janus has %d mountpoints, want %d", n, before)
+				}
+				// 失败后端口和ID全部归还
+				if len(inst.ports.used) != usedPorts || len(inst.ids.used) != usedIDs {
+					t.Errorf("held ports/ids = %d/%d after failure, want %d/%d",
+						len(inst.ports.used), len(inst.ids.used), usedPorts, usedIDs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("provisionMountpoint: %v", err)
+			}
+
+			if mountpoint.ID != tt.wantID || mountpoint.VideoPort != tt.wantVideo || mountpoint.AudioPort != tt.wantAudio {
+				t.Errorf("mountpoint id=%d video=%d audio=%d, want id=%d video=%d audio=%d",
+					mountpoint.ID, mountpoint.VideoPort, mountpoint.AudioPort, tt.wantID, tt.wantVideo, tt.wantAudio)
+			}
+			if mountpoint.Description != "robot:r1/default" || mountpoint.Instance != inst.name {
+				t.Errorf("mountpoint description=%q instance=%q", mountpoint.Description, mountpoint.Instance)
+			}
+			info, exists := fake.mountpoint(tt.wantID)
+			if !exists || info.Description != mountpoint.Description || info.VideoPort != tt.wantVideo {
+				t.Errorf("janus mountpoint %d = %+v (exists %v)", tt.wantID, info, exists)
+			}
+			if !inst.ids.used[tt.wantID] || !inst.ports.used[tt.wantVideo] {
+				t.Errorf("id %d or port %d not held after provisioning", tt.wantID, tt.wantVideo)
+			}
+			// 被其他挂载点占用而跳过的ID不应保留
+			for id := 100; id < tt.wantID; id++ {
+				if _, taken := fake.mountpoint(id); taken && inst.ids.used[id] {
+					t.Errorf("skipped id %d still held", id)
+				}
+			}
+		})
+	}
+}
+
+func TestPluginRequestRecoversControlHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(fake *fakeJanusTransport)
+		persistent bool
+		wantCode   int
+		wantCreate int // 新建会话次数，含首次
+		wantAttach int
+	}{
+		{
+			name:       "session expired",
+			invalidate: (*fakeJanusTransport).expireSessions,
+			wantCreate: 2,
+			wantAttach: 2,
+		},
+		{
+			name:       "handle detached",
+			invalidate: (*fakeJanusTransport).detachHandles,
+			wantCreate: 2,
+			wantAttach: 2,
+		},
+		{
+			name:       "gives up after one retry",
+			invalidate: (*fakeJanusTransport).expireSessions,
+			persistent: true,
+			wantCode:   janusErrorSessionNotFound,
+			wantCreate: 2,
+			wantAttach: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, fake := newTestJanusService(t, models.JanusConfig{})
+			inst := js.instances[0]
+
+			if _, err := inst.listMountpoints(); err != nil {
+				t.Fatalf("listMountpoints: %v", err)
+			}
+			oldSession, oldHandle := inst.currentSession()
+
+			tt.invalidate(fake)
+			if tt.persistent {
+				fake.fail = func(req JanusRequest, plugin string) error {
+					if req.Janus == "message" {
+						return &JanusError{Code: janusErrorSessionNotFound, Reason: "No such session"}
+					}
+					return nil
+				}
+			}
+
+			_, err := inst.listMountpoints()
+			if tt.wantCode != 0 {
+				var janusErr *JanusError
+				if !errors.As(err, &janusErr) || janusErr.Code != tt.wantCode {
+					t.Fatalf("error = %v, want janus error %d", err, tt.wantCode)
+				}
+				if n := fake.count("message", "list"); n != 3 {
+					t.Errorf("list sent %d times, want 3", n)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("listMountpoints after %s: %v", tt.name, err)
+				}
+				sessionID, handleID := inst.currentSession()
+				if sessionID == oldSession || handleID == oldHandle || sessionID == 0 || handleID == 0 {
+					t.Errorf("control handle not recreated: %d/%d -> %d/%d", oldSession, oldHandle, sessionID, handleID)
+				}
+			}
+
+			if n := fake.count("create", ""); n != tt.wantCreate {
+				t.Errorf("sessions created %d, want %d", n, tt.wantCreate)
+			}
+			if n := fake.count("attach", ""); n != tt.wantAttach {
+				t.Errorf("handles attached %d, want %d", n, tt.wantAttach)
+			}
+		})
+	}
+}
+
+func TestReconcileInstance(t *testing.T) {
+	// 注册r1的默认视频源，挂载点ID为100
+	register := func(t *testing.T, js *JanusService) *models.WebRTCStream {
+		t.Helper()
+		stream, err := js.RegisterWebRTCStream("r1")
+		if err != nil {
+			t.Fatalf("RegisterWebRTCStream: %v", err)
+		}
+		if stream.StreamID != 100 {
+			t.Fatalf("stream id = %d, want 100", stream.StreamID)
+		}
+		return stream
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, js *JanusService, fake *fakeJanusTransport)
+		want   models.JanusReconcileResult
+		verify func(t *testing.T, js *JanusService, fake *fakeJanusTransport)
+	}{
+		{
+			name: "keeps matching mountpoints",
+			setup: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				register(t, js)
+			},
+			want: models.JanusReconcileResult{Mountpoints: 1},
+			verify: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				if n := fake.count("message", "create"); n != 1 {
+					t.Errorf("mountpoints created %d, want 1", n)
+				}
+			},
+		},
+		{
+			name: "ignores mountpoints not created by the service",
+			setup: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				fake.addMountpoint(1, "lobby camera", 5004)
+			},
+			want: models.JanusReconcileResult{Mountpoints: 1},
+			verify: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				if streams := js.GetAllStreams(); len(streams) != 0 {
+					t.Errorf("streams = %v, want none", streams)
+				}
+			},
+		},
+		{
+			name: "adopts orphan mountpoint after restart",
+			setup: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				fake.addMountpoint(100, "robot:r1/front", 20000)
+			},
+			want: models.JanusReconcileResult{Mountpoints: 1, Adopted: []string{"r1/front"}},
+			verify: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				stream, ok := js.GetAllStreams()["r1"]["front"]
+				if !ok {
+					t.Fatal("orphan not adopted")
+				}
+				if stream.StreamID != 100 || stream.VideoPort != 20000 || stream.Status != "inactive" {
+					t.Errorf("adopted stream = %+v", stream)
+				}
+				// 端口和ID被占用，新注册不会冲突
+				other, err := js.RegisterWebRTCStream("r2")
+				if err != nil {
+					t.Fatalf("RegisterWebRTCStream: %v", err)
+				}
+				if other.StreamID == 100 || other.VideoPort == 20000 {
+					t.Errorf("new stream reused adopted id/port: %d/%d", other.StreamID, other.VideoPort)
+				}
+			},
+		},
+		{
+			name: "recreates mountpoints lost in janus restart",
+			setup: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				register(t, js)
+				js.DeactivateStream("r1")
+				fake.restart()
+			},
+			want: models.JanusReconcileResult{Recreated: []string{"r1/default"}},
+			verify: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				stream := js.GetAllStreams()["r1"]["default"]
+				info, exists := fake.mountpoint(stream.StreamID)
+				if !exists || info.Description != "robot:r1/default" || info.VideoPort != stream.VideoPort {
+					t.Errorf("janus mountpoint for %+v = %+v (exists %v)", stream, info, exists)
+				}
+				if stream.Status != "inactive" || stream.InactiveSince == 0 {
+					t.Errorf("recreated stream lost inactive state: %+v", stream)
+				}
+			},
+		},
+		{
+			name: "recreates mountpoint whose id was taken by another",
+			setup: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				register(t, js)
+				fake.restart()
+				fake.addMountpoint(100, "lobby camera", 5004)
+			},
+			want: models.JanusReconcileResult{Mountpoints: 1, Recreated: []string{"r1/default"}},
+			verify: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				stream := js.GetAllStreams()["r1"]["default"]
+				if stream.StreamID == 100 || stream.Status != "active" {
+					t.Errorf("recreated stream = %+v", stream)
+				}
+				if info, _ := fake.mountpoint(100); info.Description != "lobby camera" {
+					t.Errorf("foreign mountpoint replaced: %+v", info)
+				}
+			},
+		},
+		{
+			name: "drops stream when mountpoint cannot be recreated",
+			setup: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				register(t, js)
+				fake.restart()
+				fake.fail = func(req JanusRequest, plugin string) error {
+					if plugin == "create" {
+						return &JanusError{Code: 490, Reason: "Internal error"}
+					}
+					return nil
+				}
+			},
+			want: models.JanusReconcileResult{Failed: []string{"r1/default"}},
+			verify: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				if streams := js.GetAllStreams(); len(streams) != 0 {
+					t.Errorf("streams = %v, want none", streams)
+				}
+				inst := js.instances[0]
+				if inst.ids.used[100] || inst.ports.used[20000] {
+					t.Error("id or port of dropped stream still held")
+				}
+			},
+		},
+		{
+			name: "removes stale mountpoint of a migrated stream",
+			setup: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				fake.addMountpoint(100, "robot:r1/default", 20000)
+				js.mutex.Lock()
+				js.storeStream(&models.WebRTCStream{UCode: "r1", Source: "default", StreamID: 7, Instance: "janus-2", Status: "active"})
+				js.mutex.Unlock()
+			},
+			want: models.JanusReconcileResult{Mountpoints: 1, Removed: []string{"r1/default"}},
+			verify: func(t *testing.T, js *JanusService, fake *fakeJanusTransport) {
+				if _, exists := fake.mountpoint(100); exists {
+					t.Error("stale mountpoint not destroyed")
+				}
+				if stream := js.GetAllStreams()["r1"]["default"]; stream.Instance != "janus-2" {
+					t.Errorf("migrated stream changed: %+v", stream)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, fake := newTestJanusService(t, models.JanusConfig{})
+			tt.setup(t, js, fake)
+
+			got, err := js.reconcileInstance(js.instances[0])
+			if err != nil {
+				t.Fatalf("reconcileInstance: %v", err)
+			}
+
+			want := tt.want
+			for _, list := range []*[]string{&want.Adopted, &want.Recreated, &want.Failed, &want.Removed} {
+				if *list == nil {
+					*list = []string{}
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("result = %+v, want %+v", got, want)
+			}
+			if tt.verify != nil {
+				tt.verify(t, js, fake)
+			}
+		})
+	}
+}
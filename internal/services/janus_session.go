@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Start 启动各实例的会话keepalive、事件处理和健康检查
+func (js *JanusService) Start() {
+	for _, inst := range js.instances {
+		go js.keepaliveLoop(inst)
+		go js.eventLoop(inst)
+	}
+	go js.healthLoop()
+
+	log.Info().
+		Int("instances", len(js.instances)).
+		Dur("keepalive_interval", js.config.KeepaliveInterval).
+		Dur("health_interval", js.config.HealthInterval).
+		Msg("Janus session monitor started")
+}
+
+// Shutdown 停止会话维护并关闭传输层
+func (js *JanusService) Shutdown() {
+	js.cancel()
+	for _, inst := range js.instances {
+		inst.transport.Close()
+	}
+}
+
+// 定期发送keepalive，防止会话超时
+func (js *JanusService) keepaliveLoop(inst *janusInstance) {
+	ticker := time.NewTicker(js.config.KeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-js.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sessionID, _ := inst.currentSession()
+		if sessionID == 0 {
+			// 之前恢复失败时继续重试
+			if js.hasStreams(inst) {
+				js.recoverSession(inst, "no active session")
+			}
+			continue
+		}
+
+		_, err := inst.transport.Send(JanusRequest{Janus: "keepalive", SessionID: sessionID})
+		if err == nil {
+			continue
+		}
+
+		var janusErr *JanusError
+		if errors.As(err, &janusErr) && janusErr.Code == janusErrorSessionNotFound {
+			js.recoverSession(inst, "session expired")
+			continue
+		}
+
+		// Janus不可达，流标记为异常，下个周期重建会话
+		log.Warn().Err(err).Str("instance", inst.name).Int64("session_id", sessionID).Msg("Janus keepalive failed")
+		inst.resetControlHandles()
+		js.markStreams(inst, "active", "error")
+	}
+}
+
+// 消费传输层的异步事件
+func (js *JanusService) eventLoop(inst *janusInstance) {
+	events := inst.transport.Events()
+	for {
+		select {
+		case <-js.ctx.Done():
+			return
+		case event := <-events:
+			sessionID, handleID := inst.currentSession()
+			js.handleEvent(inst, sessionID, handleID, event)
+		}
+	}
+}
+
+// 处理会话事件
+func (js *JanusService) handleEvent(inst *janusInstance, sessionID, handleID int64, event *JanusResponse) {
+	// 已废弃会话的事件
+	if event.SessionID != 0 && event.SessionID != sessionID {
+		return
+	}
+
+	// 观看者句柄的事件交给监听者
+	if event.Sender != 0 && event.Sender != handleID {
+		if listener := js.getListener(); listener != nil {
+			listener.HandleEvent(inst.name, event)
+		}
+		return
+	}
+
+	switch event.Janus {
+	case "keepalive", "ack":
+	case janusEventConnected:
+		js.recoverSession(inst, "transport connected")
+	case "timeout":
+		log.Warn().Str("instance", inst.name).Int64("session_id", sessionID).Msg("Janus session timed out")
+		js.recoverSession(inst, "session timeout")
+	case "detached":
+		if event.Sender == handleID {
+			log.Warn().Str("instance", inst.name).Int64("handle_id", handleID).Msg("Janus streaming handle detached")
+			js.recoverSession(inst, "handle detached")
+		}
+	case "hangup":
+		if event.Sender == handleID {
+			log.Warn().Str("instance", inst.name).Int64("handle_id", handleID).Msg("Janus streaming handle hung up")
+			js.recoverSession(inst, "handle hangup")
+		}
+	default:
+		log.Debug().Str("instance", inst.name).Str("event", event.Janus).Int64("sender", event.Sender).Msg("Janus event received")
+	}
+}
+
+// 重建实例的管理会话并与Janus对账，恢复失败时将流标记为异常
+func (js *JanusService) recoverSession(inst *janusInstance, reason string) {
+	if !inst.recovering.TryLock() {
+		return
+	}
+	defer inst.recovering.Unlock()
+
+	inst.resetControlHandles()
+	if listener := js.getListener(); listener != nil {
+		listener.SessionReset(inst.name)
+	}
+	sessionID, handleID, err := inst.controlHandles()
+	if err != nil {
+		log.Warn().Err(err).Str("instance", inst.name).Str("reason", reason).Msg("Failed to recreate Janus session")
+		js.markStreams(inst, "active", "error")
+		return
+	}
+
+	js.mutex.Lock()
+	js.eachStream(func(stream *models.WebRTCStream) {
+		if stream.Instance != inst.name {
+			return
+		}
+		stream.SessionID = sessionID
+		stream.HandleID = handleID
+		if stream.Status == "error" {
+			stream.Status = "active"
+		}
+	})
+	js.mutex.Unlock()
+
+	if _, err := js.reconcileInstance(inst); err != nil {
+		log.Error().Err(err).Str("instance", inst.name).Str("reason", reason).Msg("Failed to reconcile after Janus session recovery")
+		js.markStreams(inst, "active", "error")
+		return
+	}
+
+	log.Info().Str("instance", inst.name).Str("reason", reason).Int64("session_id", sessionID).Msg("Janus session recovered")
+}
+
+// 批量修改实例上的流状态
+func (js *JanusService) markStreams(inst *janusInstance, from, to string) {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+
+	js.eachStream(func(stream *models.WebRTCStream) {
+		if stream.Instance == inst.name && stream.Status == from {
+			stream.Status = to
+			log.Warn().Str("ucode", stream.UCode).Str("source", stream.Source).Str("status", to).Msg("WebRTC stream status changed")
+		}
+	})
+}
+
+// 实例上是否有流
+func (js *JanusService) hasStreams(inst *janusInstance) bool {
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+
+	found := false
+	js.eachStream(func(stream *models.WebRTCStream) {
+		if stream.Instance == inst.name {
+			found = true
+		}
+	})
+	return found
+}
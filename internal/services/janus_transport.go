@@ -0,0 +1,314 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Janus传输方式
+const (
+	JanusTransportHTTP      = "http"
+	JanusTransportWebSocket = "websocket"
+)
+
+// 传输层建立连接后发出的本地事件，之前的会话随旧连接失效，需重建并对账
+const janusEventConnected = "connected"
+
+// 事件通道缓冲
+const janusEventBuffer = 64
+
+// JanusTransport Janus API传输层
+//
+// Send 发送请求并等待同一事务的响应，SessionID/HandleID决定请求目标，
+// 异步插件请求跳过ack，返回携带插件结果的event；
+// Events 返回不属于任何请求的异步事件（timeout、hangup、detached、插件事件等）。
+type JanusTransport interface {
+	Send(req JanusRequest) (*JanusResponse, error)
+	Events() <-chan *JanusResponse
+	Close() error
+}
+
+// 根据配置创建传输层
+func NewJanusTransport(config models.JanusConfig) JanusTransport {
+	switch strings.ToLower(config.Transport) {
+	case JanusTransportWebSocket:
+		return NewJanusWebSocketTransport(config.WebSocketURL)
+	default:
+		return NewJanusHTTPTransport(config.HTTPURL, config.BasePath)
+	}
+}
+
+// 生成事务ID
+func newTransaction() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// JanusHTTPTransport REST API传输，会话事件通过长轮询获取
+type JanusHTTPTransport struct {
+	baseURL    string
+	client     *http.Client
+	pollClient *http.Client // 长轮询使用，超时需大于Janus的30秒等待
+	events     chan *JanusResponse
+
+	mutex   sync.Mutex
+	pollers map[int64]context.CancelFunc   // 会话ID -> 长轮询
+	pending map[string]chan *JanusResponse // 事务ID -> 等待长轮询返回结果的异步请求
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func NewJanusHTTPTransport(httpURL, basePath string) *JanusHTTPTransport {
+	if basePath == "" {
+		basePath = DefaultJanusBasePath
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JanusHTTPTransport{
+		baseURL: strings.TrimRight(httpURL, "/") + "/" + strings.Trim(basePath, "/"),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		pollClient: &http.Client{
+			Timeout: 45 * time.Second,
+		},
+		events:  make(chan *JanusResponse, janusEventBuffer),
+		pollers: make(map[int64]context.CancelFunc),
+		pending: make(map[string]chan *JanusResponse),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// REST API地址，参数依次为会话ID和句柄ID
+func (t *JanusHTTPTransport) endpoint(ids ...int64) string {
+	endpoint := t.baseURL
+	for _, id := range ids {
+		if id == 0 {
+			break
+		}
+		endpoint += "/" + strconv.FormatInt(id, 10)
+	}
+	return endpoint
+}
+
+func (t *JanusHTTPTransport) Events() <-chan *JanusResponse {
+	return t.events
+}
+
+func (t *JanusHTTPTransport) Close() error {
+	t.cancel()
+	return nil
+}
+
+func (t *JanusHTTPTransport) Send(req JanusRequest) (*JanusResponse, error) {
+	req.Transaction = newTransaction()
+
+	var httpReq *http.Request
+	var err error
+	if req.Janus == "info" {
+		httpReq, err = http.NewRequest("GET", t.endpoint()+"/info", nil)
+	} else {
+		var jsonData []byte
+		jsonData, err = json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		httpReq, err = http.NewRequest("POST", t.endpoint(req.SessionID, req.HandleID), bytes.NewBuffer(jsonData))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// 异步插件请求的结果通过长轮询返回，需在发送前登记
+	var pending chan *JanusResponse
+	if req.Janus == "message" {
+		pending = make(chan *JanusResponse, 1)
+		t.mutex.Lock()
+		t.pending[req.Transaction] = pending
+		t.mutex.Unlock()
+		defer func() {
+			t.mutex.Lock()
+			delete(t.pending, req.Transaction)
+			t.mutex.Unlock()
+		}()
+	}
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response JanusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if response.Error != nil {
+		if response.Error.Code == janusErrorSessionNotFound {
+			t.stopPolling(req.SessionID)
+		}
+		return nil, response.Error
+	}
+	if req.Janus != "info" && response.Transaction != "" && response.Transaction != req.Transaction {
+		return nil, fmt.Errorf("transaction mismatch: sent %s, got %s", req.Transaction, response.Transaction)
+	}
+
+	if response.Janus == "ack" && pending != nil {
+		select {
+		case event := <-pending:
+			if event.Error != nil {
+				return nil, event.Error
+			}
+			return event, nil
+		case <-time.After(janusRequestTimeout):
+			return nil, fmt.Errorf("janus request %s timed out", req.Janus)
+		case <-t.ctx.Done():
+			return nil, fmt.Errorf("janus transport closed")
+		}
+	}
+
+	// 新会话开始长轮询，会话销毁后停止
+	switch req.Janus {
+	case "create":
+		if req.SessionID == 0 {
+			if sessionID, err := responseID(&response); err == nil {
+				t.startPolling(sessionID)
+			}
+		}
+	case "destroy":
+		if req.HandleID == 0 {
+			t.stopPolling(req.SessionID)
+		}
+	}
+
+	return &response, nil
+}
+
+func (t *JanusHTTPTransport) startPolling(sessionID int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, exists := t.pollers[sessionID]; exists {
+		return
+	}
+	ctx, cancel := context.WithCancel(t.ctx)
+	t.pollers[sessionID] = cancel
+	go t.poll(ctx, sessionID)
+}
+
+func (t *JanusHTTPTransport) stopPolling(sessionID int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if cancel, exists := t.pollers[sessionID]; exists {
+		cancel()
+		delete(t.pollers, sessionID)
+	}
+}
+
+// 长轮询会话事件，会话不存在时发出timeout事件并停止
+func (t *JanusHTTPTransport) poll(ctx context.Context, sessionID int64) {
+	for ctx.Err() == nil {
+		event, err := t.pollEvent(ctx, sessionID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var janusErr *JanusError
+			if errors.As(err, &janusErr) && janusErr.Code == janusErrorSessionNotFound {
+				t.stopPolling(sessionID)
+				t.dispatch(&JanusResponse{Janus: "timeout", SessionID: sessionID})
+				return
+			}
+			log.Debug().Err(err).Int64("session_id", sessionID).Msg("Janus event poll failed")
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		if event.Janus == "keepalive" {
+			continue
+		}
+		if event.SessionID == 0 {
+			event.SessionID = sessionID
+		}
+		if t.deliver(event) {
+			continue
+		}
+		t.dispatch(event)
+		if event.Janus == "timeout" {
+			t.stopPolling(sessionID)
+			return
+		}
+	}
+}
+
+// 获取一个会话事件，Janus无事件时约30秒后返回keepalive
+func (t *JanusHTTPTransport) pollEvent(ctx context.Context, sessionID int64) (*JanusResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", t.endpoint(sessionID)+"?maxev=1", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := t.pollClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to poll events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var event JanusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	if event.Error != nil {
+		return nil, event.Error
+	}
+	return &event, nil
+}
+
+// 将异步请求的结果交给等待的Send
+func (t *JanusHTTPTransport) deliver(event *JanusResponse) bool {
+	if event.Transaction == "" {
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	pending, exists := t.pending[event.Transaction]
+	if !exists {
+		return false
+	}
+	delete(t.pending, event.Transaction)
+	pending <- event
+	return true
+}
+
+// 投递事件，消费者跟不上时丢弃
+func (t *JanusHTTPTransport) dispatch(event *JanusResponse) {
+	select {
+	case t.events <- event:
+	default:
+		log.Warn().Str("event", event.Janus).Msg("Janus event dropped, consumer too slow")
+	}
+}
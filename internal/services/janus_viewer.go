@@ -0,0 +1,241 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"remote-ctrl-robot/internal/models"
+)
+
+// JanusEventListener 接收观看者句柄上的事件，instance为事件所在的Janus实例
+type JanusEventListener interface {
+	// HandleEvent 非管理句柄的事件：trickle、webrtcup、media、hangup、detached、插件事件
+	HandleEvent(instance string, event *JanusResponse)
+	// SessionReset 实例会话失效，之前在该实例上附加的句柄全部不可用
+	SessionReset(instance string)
+	// StreamMigrated 实例故障，流已迁移到其他实例
+	StreamMigrated(from, to models.WebRTCStream)
+}
+
+// SetListener 设置观看者事件监听
+func (js *JanusService) SetListener(listener JanusEventListener) {
+	js.listenerMutex.Lock()
+	defer js.listenerMutex.Unlock()
+	js.listener = listener
+}
+
+func (js *JanusService) getListener() JanusEventListener {
+	js.listenerMutex.Lock()
+	defer js.listenerMutex.Unlock()
+	return js.listener
+}
+
+// 观看者句柄所在的实例
+func (js *JanusService) viewerInstance(instance string) (*janusInstance, error) {
+	inst := js.instance(instance)
+	if inst == nil {
+		return nil, models.Errorf(models.ErrorCodeNotFound, "unknown janus instance: %s", instance)
+	}
+	return inst, nil
+}
+
+// GetStream 获取UCode指定视频源的流信息，source为空时取默认视频源，默认源不存在时取第一个
+func (js *JanusService) GetStream(ucode, source string) (*models.WebRTCStream, bool) {
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+
+	stream, exists := js.lookupStream(ucode, sourceName(source))
+	if !exists && source == "" {
+		if streams := js.robotStreams(ucode); len(streams) > 0 {
+			stream, exists = streams[0], true
+		}
+	}
+	if !exists {
+		return nil, false
+	}
+	copied := *stream
+	return &copied, true
+}
+
+// AttachViewer 在流所在实例上为观看者附加独立的streaming句柄
+func (js *JanusService) AttachViewer(instance string) (int64, error) {
+	inst, err := js.viewerInstance(instance)
+	if err != nil {
+		return 0, err
+	}
+	sessionID, _, err := inst.controlHandles()
+	if err != nil {
+		return 0, err
+	}
+	return inst.attachStreamingPlugin(sessionID)
+}
+
+// 向观看者句柄发送插件请求，返回插件数据和JSEP
+func (js *JanusService) viewerRequest(instance string, handleID int64, body map[string]interface{}, jsep interface{}) (json.RawMessage, json.RawMessage, error) {
+	inst, err := js.viewerInstance(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessionID, _ := inst.currentSession()
+	if sessionID == 0 {
+		return nil, nil, models.NewError(models.ErrorCodeUnavailable, "janus session not available")
+	}
+
+	response, err := inst.transport.Send(JanusRequest{
+		Janus:     "message",
+		SessionID: sessionID,
+		HandleID:  handleID,
+		Body:      body,
+		JSEP:      jsep,
+	})
+	if err != nil {
+		var janusErr *JanusError
+		if errors.As(err, &janusErr) && janusErr.Code == janusErrorHandleNotFound {
+			return nil, nil, models.NewError(models.ErrorCodeNotFound, "viewer session expired")
+		}
+		return nil, nil, models.NewError(models.ErrorCodeUnavailable, err.Error())
+	}
+	if response.PluginData == nil {
+		return nil, nil, fmt.Errorf("invalid plugin response: %s", response.Janus)
+	}
+
+	var pluginErr janusPluginError
+	if err := json.Unmarshal(response.PluginData.Data, &pluginErr); err == nil && pluginErr.Code != 0 {
+		if pluginErr.Code == janusStreamingErrorNoSuchMountpoint {
+			return nil, nil, models.NewError(models.ErrorCodeNotFound, pluginErr.Error())
+		}
+		return nil, nil, models.NewError(models.ErrorCodeUnavailable, pluginErr.Error())
+	}
+	return response.PluginData.Data, response.JSEP, nil
+}
+
+// WatchMountpoint 观看挂载点，返回Janus生成的SDP offer
+func (js *JanusService) WatchMountpoint(instance string, handleID int64, streamID int) (*models.JSEP, error) {
+	_, raw, err := js.viewerRequest(instance, handleID, map[string]interface{}{
+		"request": "watch",
+		"id":      streamID,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var offer models.JSEP
+	if len(raw) == 0 || json.Unmarshal(raw, &offer) != nil || offer.SDP == "" {
+		return nil, models.NewError(models.ErrorCodeUnavailable, "janus did not return an SDP offer")
+	}
+	return &offer, nil
+}
+
+// StartViewer 提交观看者的SDP answer并开始推流
+func (js *JanusService) StartViewer(instance string, handleID int64, answer models.JSEP) error {
+	_, _, err := js.viewerRequest(instance, handleID, map[string]interface{}{
+		"request": "start",
+	}, answer)
+	return err
+}
+
+// TrickleViewer 转发观看者的ICE候选，candidate为nil表示收集完成
+func (js *JanusService) TrickleViewer(instance string, handleID int64, candidate *models.ICECandidate) error {
+	inst, err := js.viewerInstance(instance)
+	if err != nil {
+		return err
+	}
+	sessionID, _ := inst.currentSession()
+	if sessionID == 0 {
+		return models.NewError(models.ErrorCodeUnavailable, "janus session not available")
+	}
+
+	var payload interface{} = map[string]bool{"completed": true}
+	if candidate != nil && !candidate.Completed {
+		payload = candidate
+	}
+	_, err = inst.transport.Send(JanusRequest{
+		Janus:     "trickle",
+		SessionID: sessionID,
+		HandleID:  handleID,
+		Candidate: payload,
+	})
+	if err != nil {
+		return models.NewError(models.ErrorCodeUnavailable, err.Error())
+	}
+	return nil
+}
+
+// DetachViewer 停止观看并释放句柄
+func (js *JanusService) DetachViewer(instance string, handleID int64) error {
+	inst := js.instance(instance)
+	if inst == nil {
+		return nil
+	}
+	sessionID, _ := inst.currentSession()
+	if sessionID == 0 {
+		return nil
+	}
+
+	js.viewerRequest(instance, handleID, map[string]interface{}{"request": "stop"}, nil)
+	_, err := inst.transport.Send(JanusRequest{
+		Janus:     "detach",
+		SessionID: sessionID,
+		HandleID:  handleID,
+	})
+	return err
+}
+
+// Admin API handle_info中的媒体统计
+type janusHandleInfo struct {
+	WebRTC struct {
+		Media map[string]struct {
+			Type string `json:"type"`
+			RTCP struct {
+				Main struct {
+					RTT          int64   `json:"rtt"`
+					LostByRemote int64   `json:"lost-by-remote"`
+					JitterRemote float64 `json:"jitter-remote"`
+				} `json:"main"`
+			} `json:"rtcp"`
+			OutStats struct {
+				Packets      int64 `json:"packets"`
+				BytesLastSec int64 `json:"bytes_lastsec"`
+			} `json:"out_stats"`
+		} `json:"media"`
+	} `json:"webrtc"`
+}
+
+// ViewerStats 通过Admin API获取观看者句柄的视频发送统计，未配置Admin API时返回错误
+func (js *JanusService) ViewerStats(instance string, handleID int64) (models.ViewerMediaStats, error) {
+	inst, err := js.viewerInstance(instance)
+	if err != nil {
+		return models.ViewerMediaStats{}, err
+	}
+	sessionID, _ := inst.currentSession()
+	if sessionID == 0 {
+		return models.ViewerMediaStats{}, models.NewError(models.ErrorCodeUnavailable, "janus session not available")
+	}
+
+	raw, err := inst.adminRequest("handle_info", sessionID, handleID)
+	if err != nil {
+		return models.ViewerMediaStats{}, err
+	}
+	var info janusHandleInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return models.ViewerMediaStats{}, fmt.Errorf("invalid handle_info response: %w", err)
+	}
+
+	var stats models.ViewerMediaStats
+	for _, media := range info.WebRTC.Media {
+		if media.Type != "video" {
+			continue
+		}
+		stats.Packets += media.OutStats.Packets
+		stats.BytesLastSec += media.OutStats.BytesLastSec
+		stats.Lost += media.RTCP.Main.LostByRemote
+		if media.RTCP.Main.JitterRemote > stats.JitterMs {
+			stats.JitterMs = media.RTCP.Main.JitterRemote
+		}
+		if media.RTCP.Main.RTT > stats.RTTMs {
+			stats.RTTMs = media.RTCP.Main.RTT
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// Janus websocket传输参数
+const (
+	janusSubprotocol         = "janus-protocol"
+	janusRequestTimeout      = 10 * time.Second
+	janusReconnectMinBackoff = time.Second
+	janusReconnectMaxBackoff = 30 * time.Second
+)
+
+// 等待响应的请求
+type janusPending struct {
+	janus string
+	ch    chan *JanusResponse
+}
+
+// JanusWebSocketTransport websocket API传输，断线后自动重连
+type JanusWebSocketTransport struct {
+	url    string
+	dialer *websocket.Dialer
+	events chan *JanusResponse
+
+	mutex   sync.Mutex
+	conn    *websocket.Conn
+	pending map[string]*janusPending // 事务ID -> 请求
+
+	writeMutex sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func NewJanusWebSocketTransport(url string) *JanusWebSocketTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &JanusWebSocketTransport{
+		url: url,
+		dialer: &websocket.Dialer{
+			Subprotocols:     []string{janusSubprotocol},
+			HandshakeTimeout: janusRequestTimeout,
+		},
+		events:  make(chan *JanusResponse, janusEventBuffer),
+		pending: make(map[string]*janusPending),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go t.run()
+	return t
+}
+
+func (t *JanusWebSocketTransport) Events() <-chan *JanusResponse {
+	return t.events
+}
+
+func (t *JanusWebSocketTransport) Close() error {
+	t.cancel()
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (t *JanusWebSocketTransport) Send(req JanusRequest) (*JanusResponse, error) {
+	req.Transaction = newTransaction()
+	pending := &janusPending{janus: req.Janus, ch: make(chan *JanusResponse, 1)}
+
+	t.mutex.Lock()
+	conn := t.conn
+	if conn == nil {
+		t.mutex.Unlock()
+		return nil, fmt.Errorf("janus websocket not connected")
+	}
+	t.pending[req.Transaction] = pending
+	t.mutex.Unlock()
+
+	defer func() {
+		t.mutex.Lock()
+		delete(t.pending, req.Transaction)
+		t.mutex.Unlock()
+	}()
+
+	t.writeMutex.Lock()
+	conn.SetWriteDeadline(time.Now().Add(janusRequestTimeout))
+	err := conn.WriteJSON(req)
+	t.writeMutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	select {
+	case response, ok := <-pending.ch:
+		if !ok {
+			return nil, fmt.Errorf("janus websocket disconnected")
+		}
+		if response.Error != nil {
+			return nil, response.Error
+		}
+		return response, nil
+	case <-time.After(janusRequestTimeout):
+		return nil, fmt.Errorf("janus request %s timed out", req.Janus)
+	case <-t.ctx.Done():
+		return nil, fmt.Errorf("janus transport closed")
+	}
+}
+
+// 连接并读取消息，断线后指数退避重连
+func (t *JanusWebSocketTransport) run() {
+	backoff := janusReconnectMinBackoff
+
+	for t.ctx.Err() == nil {
+		conn, _, err := t.dialer.DialContext(t.ctx, t.url, nil)
+		if err != nil {
+			log.Warn().Err(err).Str("url", t.url).Dur("retry_in", backoff).Msg("Failed to connect to Janus websocket")
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > janusReconnectMaxBackoff {
+				backoff = janusReconnectMaxBackoff
+			}
+			continue
+		}
+
+		backoff = janusReconnectMinBackoff
+		t.mutex.Lock()
+		t.conn = conn
+		t.mutex.Unlock()
+		log.Info().Str("url", t.url).Msg("Connected to Janus websocket")
+
+		t.dispatch(&JanusResponse{Janus: janusEventConnected})
+
+		t.readLoop(conn)
+
+		t.mutex.Lock()
+		t.conn = nil
+		for transaction, pending := range t.pending {
+			close(pending.ch)
+			delete(t.pending, transaction)
+		}
+		t.mutex.Unlock()
+		conn.Close()
+
+		if t.ctx.Err() == nil {
+			log.Warn().Str("url", t.url).Msg("Janus websocket disconnected")
+		}
+	}
+}
+
+func (t *JanusWebSocketTransport) readLoop(conn *websocket.Conn) {
+	for {
+		var response JanusResponse
+		if err := conn.ReadJSON(&response); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				log.Warn().Err(err).Msg("Invalid message from Janus websocket")
+				continue
+			}
+			return
+		}
+
+		if response.Transaction != "" {
+			t.mutex.Lock()
+			pending, exists := t.pending[response.Transaction]
+			// 异步插件请求先返回ack，继续等待事件
+			if exists && !(response.Janus == "ack" && pending.janus == "message") {
+				delete(t.pending, response.Transaction)
+				pending.ch <- &response
+				t.mutex.Unlock()
+				continue
+			}
+			t.mutex.Unlock()
+			if exists {
+				continue
+			}
+		}
+
+		t.dispatch(&response)
+	}
+}
+
+// 投递事件，消费者跟不上时丢弃
+func (t *JanusWebSocketTransport) dispatch(event *JanusResponse) {
+	select {
+	case t.events <- event:
+	default:
+		log.Warn().Str("event", event.Janus).Msg("Janus event dropped, consumer too slow")
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 模拟Janus websocket API，reply处理收到的每个请求，返回false时断开连接
+type janusWebSocketServer struct {
+	server      *httptest.Server
+	connections int32
+}
+
+func newJanusWebSocketServer(t *testing.T, reply func(conn *websocket.Conn, req JanusRequest) bool) *janusWebSocketServer {
+	t.Helper()
+	s := &janusWebSocketServer{}
+	upgrader := websocket.Upgrader{Subprotocols: []string{janusSubprotocol}}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&s.connections, 1)
+
+		for {
+			var req JanusRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if !reply(conn, req) {
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *janusWebSocketServer) url() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http")
+}
+
+// 连接到模拟服务并等待连接事件
+func newTestJanusWebSocketTransport(t *testing.T, s *janusWebSocketServer) *JanusWebSocketTransport {
+	t.Helper()
+	transport := NewJanusWebSocketTransport(s.url())
+	t.Cleanup(func() { transport.Close() })
+	waitJanusEvent(t, transport.Events(), janusEventConnected)
+	return transport
+}
+
+func waitJanusEvent(t *testing.T, events <-chan *JanusResponse, janus string) *JanusResponse {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.Janus != janus {
+			t.Fatalf("event = %q, want %q", event.Janus, janus)
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %q event", janus)
+		return nil
+	}
+}
+
+func TestJanusWebSocketSendCorrelation(t *testing.T) {
+	pluginData := json.RawMessage(`{"streaming":"list","list":[]}`)
+
+	tests := []struct {
+		name       string
+		req        JanusRequest
+		reply      func(req JanusRequest) []interface{}
+		want       string
+		wantCode   int
+		wantEvents []string // 不属于请求的消息按顺序成为异步事件
+	}{
+		{
+			name: "returns response with matching transaction",
+			req:  JanusRequest{Janus: "create"},
+			reply: func(req JanusRequest) []interface{} {
+				return []interface{}{
+					JanusResponse{Janus: "success", Transaction: req.Transaction, Data: json.RawMessage(`{"id":7}`)},
+				}
+			},
+			want: "success",
+		},
+		{
+			name: "ack completes non-plugin request",
+			req:  JanusRequest{Janus: "keepalive", SessionID: 7},
+			reply: func(req JanusRequest) []interface{} {
+				return []interface{}{
+					JanusResponse{Janus: "ack", Transaction: req.Transaction, SessionID: 7},
+				}
+			},
+			want: "ack",
+		},
+		{
+			name: "plugin request waits for event after ack",
+			req:  JanusRequest{Janus: "message", SessionID: 7, HandleID: 8, Body: map[string]interface{}{"request": "list"}},
+			reply: func(req JanusRequest) []interface{} {
+				return []interface{}{
+					JanusResponse{Janus: "ack", Transaction: req.Transaction, SessionID: 7},
+					JanusResponse{Janus: "event", Transaction: req.Transaction, SessionID: 7, Sender: 8,
+						PluginData: &JanusPluginData{Plugin: "janus.plugin.streaming", Data: pluginData}},
+				}
+			},
+			want: "event",
+		},
+		{
+			name: "error response is returned as janus error",
+			req:  JanusRequest{Janus: "message", SessionID: 7, HandleID: 8},
+			reply: func(req JanusRequest) []interface{} {
+				return []interface{}{
+					JanusResponse{Janus: "error", Transaction: req.Transaction,
+						Error: &JanusError{Code: janusErrorSessionNotFound, Reason: "No such session 7"}},
+				}
+			},
+			wantCode: janusErrorSessionNotFound,
+		},
+		{
+			name: "unrelated messages become events",
+			req:  JanusRequest{Janus: "create"},
+			reply: func(req JanusRequest) []interface{} {
+				return []interface{}{
+					JanusResponse{Janus: "timeout", SessionID: 3},
+					JanusResponse{Janus: "success", Transaction: "other"},
+					JanusResponse{Janus: "webrtcup", SessionID: 7, Sender: 9},
+					JanusResponse{Janus: "success", Transaction: req.Transaction, Data: json.RawMessage(`{"id":7}`)},
+				}
+			},
+			want:       "success",
+			wantEvents: []string{"timeout", "success", "webrtcup"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received atomic.Value
+			server := newJanusWebSocketServer(t, func(conn *websocket.Conn, req JanusRequest) bool {
+				received.Store(req)
+				for _, message := range tt.reply(req) {
+					if err := conn.WriteJSON(message); err != nil {
+						return false
+					}
+				}
+				return true
+			})
+			transport := newTestJanusWebSocketTransport(t, server)
+
+			response, err := transport.Send(tt.req)
+			if tt.wantCode != 0 {
+				var janusErr *JanusError
+				if !errors.As(err, &janusErr) || janusErr.Code != tt.wantCode {
+					t.Fatalf("error = %v, want janus error %d", err, tt.wantCode)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+				if response.Janus != tt.want {
+					t.Errorf("response = %q, want %q", response.Janus, tt.want)
+				}
+			}
+
+			sent, _ := received.Load().(JanusRequest)
+			if sent.Transaction == "" || sent.Janus != tt.req.Janus || sent.SessionID != tt.req.SessionID || sent.HandleID != tt.req.HandleID {
+				t.Errorf("server received %+v", sent)
+			}
+			if response != nil && response.Transaction != sent.Transaction {
+				t.Errorf("response transaction = %q, want %q", response.Transaction, sent.Transaction)
+			}
+
+			for _, want := range tt.wantEvents {
+				waitJanusEvent(t, transport.Events(), want)
+			}
+			select {
+			case event := <-transport.Events():
+				t.Errorf("unexpected event %+v", event)
+			default:
+			}
+		})
+	}
+}
+
+func TestJanusWebSocketReconnect(t *testing.T) {
+	// 第一次连接收到请求时断开，之后正常应答
+	var dropped int32
+	server := newJanusWebSocketServer(t, func(conn *websocket.Conn, req JanusRequest) bool {
+		if atomic.CompareAndSwapInt32(&dropped, 0, 1) {
+			return false
+		}
+		conn.WriteJSON(JanusResponse{Janus: "success", Transaction: req.Transaction, Data: json.RawMessage(`{"id":1}`)})
+		return true
+	})
+	transport := newTestJanusWebSocketTransport(t, server)
+
+	// 断开时等待中的请求立即失败，不等到超时
+	started := time.Now()
+	if _, err := transport.Send(JanusRequest{Janus: "create"}); err == nil {
+		t.Fatal("expected error when connection drops")
+	}
+	if elapsed := time.Since(started); elapsed > janusRequestTimeout/2 {
+		t.Errorf("pending request failed after %s", elapsed)
+	}
+
+	// 重连后发出连接事件，会话由上层重建
+	waitJanusEvent(t, transport.Events(), janusEventConnected)
+	response, err := transport.Send(JanusRequest{Janus: "create"})
+	if err != nil {
+		t.Fatalf("Send after reconnect: %v", err)
+	}
+	if id, err := responseID(response); err != nil || id != 1 {
+		t.Errorf("session id = %d (%v), want 1", id, err)
+	}
+	if n := atomic.LoadInt32(&server.connections); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 链路延迟测量默认配置
+const (
+	DefaultLatencyPingInterval   = 5 * time.Second
+	DefaultLatencySmoothing      = 0.2
+	DefaultLatencyLaggyRTT       = 150 * time.Millisecond
+	DefaultLatencyMaxControlRate = 20.0
+	DefaultLatencyMinControlRate = 5.0
+)
+
+// 一个RTT内允许在途的控制命令数，超过后命令只会在链路上排队
+const latencyInFlightCommands = 2
+
+// LatencyMonitor 记录每个客户端的RTT，给出推荐控制频率并判断链路是否卡顿。
+// ping帧测量与客户端上报的RTT分开估计，有ping帧测量结果时忽略客户端上报
+type LatencyMonitor struct {
+	mutex     sync.RWMutex
+	interval  time.Duration
+	smoothing float64
+	laggyRTT  int64
+	maxRate   float64
+	minRate   float64
+	links     map[string]*models.LinkLatency // ping帧测量
+	reported  map[string]*models.LinkLatency // 客户端上报
+}
+
+// NewLatencyMonitor 创建链路延迟监测
+func NewLatencyMonitor(config models.LatencyConfig) *LatencyMonitor {
+	if config.PingInterval <= 0 {
+		config.PingInterval = DefaultLatencyPingInterval
+	}
+	if config.Smoothing <= 0 || config.Smoothing > 1 {
+		config.Smoothing = DefaultLatencySmoothing
+	}
+	if config.LaggyRTT <= 0 {
+		config.LaggyRTT = DefaultLatencyLaggyRTT
+	}
+	if config.MaxControlRate <= 0 {
+		config.MaxControlRate = DefaultLatencyMaxControlRate
+	}
+	if config.MinControlRate <= 0 || config.MinControlRate > config.MaxControlRate {
+		config.MinControlRate = math.Min(DefaultLatencyMinControlRate, config.MaxControlRate)
+	}
+
+	return &LatencyMonitor{
+		interval:  config.PingInterval,
+		smoothing: config.Smoothing,
+		laggyRTT:  config.LaggyRTT.Milliseconds(),
+		maxRate:   config.MaxControlRate,
+		minRate:   config.MinControlRate,
+		links:     make(map[string]*models.LinkLatency),
+		reported:  make(map[string]*models.LinkLatency),
+	}
+}
+
+// PingInterval 服务端ping间隔
+func (m *LatencyMonitor) PingInterval() time.Duration {
+	return m.interval
+}
+
+// Record 记录一次ping帧RTT采样，返回最新延迟以及卡顿状态或推荐频率是否变化
+func (m *LatencyMonitor) Record(ucode string, rtt time.Duration) (models.LinkLatency, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// 有ping帧测量结果后不再使用客户端上报
+	delete(m.reported, ucode)
+	return m.update(m.links, models.LatencySourcePing, ucode, rtt)
+}
+
+// Report 记录客户端上报的RTT，已有ping帧测量结果时忽略，返回生效的延迟以及是否变化
+func (m *LatencyMonitor) Report(ucode string, rtt time.Duration) (models.LinkLatency, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if link, exists := m.links[ucode]; exists {
+		return *link, false
+	}
+	return m.update(m.reported, models.LatencySourceClient, ucode, rtt)
+}
+
+// 更新一个估计器，调用方持有锁
+func (m *LatencyMonitor) update(links map[string]*models.LinkLatency, source, ucode string, rtt time.Duration) (models.LinkLatency, bool) {
+	sample := rtt.Milliseconds()
+	if sample < 0 {
+		sample = 0
+	}
+
+	link, exists := links[ucode]
+	if !exists {
+		link = &models.LinkLatency{
+			Source:      source,
+			SmoothedRTT: sample,
+			MinRTT:      sample,
+			MaxRTT:      sample,
+		}
+		links[ucode] = link
+	} else {
+		// 抖动按相邻采样差值平滑，算法同RFC 3550
+		delta := math.Abs(float64(sample - link.RTT))
+		link.Jitter += int64(math.Round((delta - float64(link.Jitter)) / 16))
+		link.SmoothedRTT = int64(math.Round(m.smoothing*float64(sample) + (1-m.smoothing)*float64(link.SmoothedRTT)))
+		if sample < link.MinRTT {
+			link.MinRTT = sample
+		}
+		if sample > link.MaxRTT {
+			link.MaxRTT = sample
+		}
+	}
+
+	previousRate, previousLaggy := link.RecommendedRate, link.Laggy
+	link.RTT = sample
+	link.Samples++
+	link.UpdatedAt = time.Now()
+	link.RecommendedRate = m.recommendedRate(link.SmoothedRTT + 2*link.Jitter)
+	link.Laggy = link.SmoothedRTT > m.laggyRTT
+
+	changed := !exists || link.Laggy != previousLaggy || link.RecommendedRate != previousRate
+	if changed && link.Laggy != previousLaggy {
+		log.Info().
+			Str("ucode", ucode).
+			Str("source", source).
+			Int64("smoothed_rtt_ms", link.SmoothedRTT).
+			Int64("jitter_ms", link.Jitter).
+			Bool("laggy", link.Laggy).
+			Msg("Client link quality changed")
+	}
+	return *link, changed
+}
+
+// 按RTT计算推荐控制频率，取整到1Hz
+func (m *LatencyMonitor) recommendedRate(rtt int64) float64 {
+	if rtt <= 0 {
+		return m.maxRate
+	}
+	rate := math.Floor(latencyInFlightCommands * 1000 / float64(rtt))
+	return math.Max(m.minRate, math.Min(m.maxRate, rate))
+}
+
+// Get 获取客户端的链路延迟，优先使用ping帧测量结果
+func (m *LatencyMonitor) Get(ucode string) (models.LinkLatency, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if link, exists := m.links[ucode]; exists {
+		return *link, true
+	}
+	if link, exists := m.reported[ucode]; exists {
+		return *link, true
+	}
+	return models.LinkLatency{}, false
+}
+
+// Remove 客户端断开后清除记录
+func (m *LatencyMonitor) Remove(ucode string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.links, ucode)
+	delete(m.reported, ucode)
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 媒体统计默认配置
+const (
+	DefaultJanusStatsInterval = 5 * time.Second
+	DefaultJanusStatsHistory  = 60 // 默认5秒一次，保留5分钟
+)
+
+// 链路质量阈值
+const (
+	mediaNoSignalAgeMs    = 3000 // 超过该时间未收到机器人RTP包视为未推流
+	mediaPoorPacketLoss   = 5.0
+	mediaPoorJitterMs     = 50.0
+	mediaPoorRTTMs        = 400
+	mediaFairPacketLoss   = 1.0
+	mediaFairJitterMs     = 30.0
+	mediaFairRTTMs        = 200
+	mediaFairMinBitrateKb = 300.0 // 有观看者时码率低于该值视为一般
+)
+
+// RobotBindings 查询机器人绑定的操作者
+type RobotBindings interface {
+	BoundOperator(robotUcode string) string
+}
+
+// 观看者句柄上一次的累计计数，用于计算采样周期内的丢包率
+type viewerCounters struct {
+	packets int64
+	lost    int64
+}
+
+// MediaStatsService 定期采集各流的媒体统计，保留短时序列并推送给绑定的操作者
+type MediaStatsService struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	janus     *JanusService
+	signaling *SignalingService
+	hub       *EventHub
+	interval  time.Duration
+	history   int
+
+	mutex    sync.RWMutex
+	stats    map[string]*models.StreamMediaStats // UCode/视频源 -> 统计
+	counters map[string]viewerCounters           // 实例/句柄ID -> 累计计数
+	bindings RobotBindings
+	sequence int64
+}
+
+// NewMediaStatsService 创建媒体统计服务
+func NewMediaStatsService(config models.JanusConfig, janus *JanusService, signaling *SignalingService, hub *EventHub) *MediaStatsService {
+	if config.StatsInterval <= 0 {
+		config.StatsInterval = DefaultJanusStatsInterval
+	}
+	if config.StatsHistory <= 0 {
+		config.StatsHistory = DefaultJanusStatsHistory
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MediaStatsService{
+		ctx:       ctx,
+		cancel:    cancel,
+		janus:     janus,
+		signaling: signaling,
+		hub:       hub,
+		interval:  config.StatsInterval,
+		history:   config.StatsHistory,
+		stats:     make(map[string]*models.StreamMediaStats),
+		counters:  make(map[string]viewerCounters),
+	}
+}
+
+// SetBindings 设置绑定关系查询，用于推送给绑定的操作者
+func (s *MediaStatsService) SetBindings(bindings RobotBindings) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bindings = bindings
+}
+
+// Start 启动采集
+func (s *MediaStatsService) Start() {
+	go s.run()
+	log.Info().Dur("interval", s.interval).Int("history", s.history).Msg("Media stats collector started")
+}
+
+// Shutdown 停止采集
+func (s *MediaStatsService) Shutdown() {
+	s.cancel()
+}
+
+func (s *MediaStatsService) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.collect()
+		}
+	}
+}
+
+// 采集一轮统计
+func (s *MediaStatsService) collect() {
+	viewers := make(map[string][]models.ViewerSession)
+	for _, session := range s.signaling.GetViewers() {
+		key := streamKey(session.RobotUCode, session.Source)
+		viewers[key] = append(viewers[key], session)
+	}
+
+	now := time.Now().UnixMilli()
+	seen := make(map[string]bool)
+	seenHandles := make(map[string]bool)
+	updated := make(map[string][]models.StreamMediaStats)
+
+	for ucode, sources := range s.janus.GetAllStreams() {
+		for source, stream := range sources {
+			if stream.Status != "active" {
+				continue
+			}
+			key := streamKey(ucode, source)
+			seen[key] = true
+
+			sample := s.sample(stream.Instance, stream.StreamID, viewers[key], seenHandles)
+			sample.Timestamp = now
+			latest := s.record(key, stream, sample)
+			updated[ucode] = append(updated[ucode], latest)
+		}
+	}
+
+	s.mutex.Lock()
+	for key := range s.stats {
+		if !seen[key] {
+			delete(s.stats, key)
+		}
+	}
+	for key := range s.counters {
+		if !seenHandles[key] {
+			delete(s.counters, key)
+		}
+	}
+	bindings := s.bindings
+	s.mutex.Unlock()
+
+	if bindings == nil {
+		return
+	}
+	for ucode, sources := range updated {
+		operator := bindings.BoundOperator(ucode)
+		if operator == "" {
+			continue
+		}
+		sort.Slice(sources, func(i, j int) bool {
+			return sources[i].Source < sources[j].Source
+		})
+		s.push(operator, ucode, models.CMD_WEBRTC_STATS{UCode: ucode, Sources: sources})
+	}
+}
+
+// 采集单个流：挂载点信息提供观看者数量和收流情况，观看者句柄提供码率、丢包、抖动
+func (s *MediaStatsService) sample(instance string, streamID int, sessions []models.ViewerSession, seenHandles map[string]bool) models.MediaStatsSample {
+	sample := models.MediaStatsSample{Viewers: len(sessions), AgeMs: -1}
+
+	if mountpoint, err := s.janus.MountpointInfo(instance, streamID); err == nil {
+		sample.AgeMs = mountpoint.AgeMs
+		if mountpoint.Viewers > sample.Viewers {
+			sample.Viewers = mountpoint.Viewers
+		}
+	} else {
+		log.Debug().Err(err).Str("instance", instance).Int("stream_id", streamID).Msg("Failed to get mountpoint info for stats")
+	}
+
+	var packets, lost int64
+	for _, session := range sessions {
+		stats, err := s.janus.ViewerStats(session.Instance, session.HandleID)
+		if err != nil {
+			if !errors.Is(err, errJanusAdminDisabled) {
+				log.Debug().Err(err).Int64("handle_id", session.HandleID).Msg("Failed to get viewer stats")
+			}
+			continue
+		}
+
+		key := handleKey(session.Instance, session.HandleID)
+		seenHandles[key] = true
+		s.mutex.Lock()
+		previous, exists := s.counters[key]
+		s.counters[key] = viewerCounters{packets: stats.Packets, lost: stats.Lost}
+		s.mutex.Unlock()
+		if exists && stats.Packets >= previous.packets && stats.Lost >= previous.lost {
+			packets += stats.Packets - previous.packets
+			lost += stats.Lost - previous.lost
+		}
+
+		if bitrate := float64(stats.BytesLastSec*8) / 1000; bitrate > sample.BitrateKbps {
+			sample.BitrateKbps = bitrate
+		}
+		if stats.JitterMs > sample.JitterMs {
+			sample.JitterMs = stats.JitterMs
+		}
+		if stats.RTTMs > sample.RTTMs {
+			sample.RTTMs = stats.RTTMs
+		}
+	}
+	if packets+lost > 0 {
+		sample.PacketLoss = float64(lost) * 100 / float64(packets+lost)
+	}
+
+	sample.Quality = mediaQuality(sample)
+	return sample
+}
+
+// 根据采样评估链路质量
+func mediaQuality(sample models.MediaStatsSample) string {
+	switch {
+	case sample.AgeMs > mediaNoSignalAgeMs:
+		return models.MediaQualityNoSignal
+	case sample.PacketLoss >= mediaPoorPacketLoss || sample.JitterMs >= mediaPoorJitterMs || sample.RTTMs >= mediaPoorRTTMs:
+		return models.MediaQualityPoor
+	case sample.PacketLoss >= mediaFairPacketLoss || sample.JitterMs >= mediaFairJitterMs || sample.RTTMs >= mediaFairRTTMs:
+		return models.MediaQualityFair
+	case sample.Viewers > 0 && sample.BitrateKbps > 0 && sample.BitrateKbps < mediaFairMinBitrateKb:
+		return models.MediaQualityFair
+	default:
+		return models.MediaQualityGood
+	}
+}
+
+// 保存采样，返回不含历史的最新统计
+func (s *MediaStatsService) record(key string, stream models.WebRTCStream, sample models.MediaStatsSample) models.StreamMediaStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats, exists := s.stats[key]
+	if !exists || stats.StreamID != stream.StreamID || stats.Instance != stream.Instance {
+		// 新流或流已迁移，重新开始序列
+		stats = &models.StreamMediaStats{
+			UCode:    stream.UCode,
+			Source:   stream.Source,
+			Instance: stream.Instance,
+			StreamID: stream.StreamID,
+		}
+		s.stats[key] = stats
+	}
+	stats.Latest = sample
+	stats.History = append(stats.History, sample)
+	if len(stats.History) > s.history {
+		stats.History = stats.History[len(stats.History)-s.history:]
+	}
+
+	latest := *stats
+	latest.History = nil
+	return latest
+}
+
+// GetStats 获取媒体统计，ucode为空时返回全部流
+func (s *MediaStatsService) GetStats(ucode string) []models.StreamMediaStats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]models.StreamMediaStats, 0, len(s.stats))
+	for _, stats := range s.stats {
+		if ucode != "" && stats.UCode != ucode {
+			continue
+		}
+		copied := *stats
+		copied.History = append([]models.MediaStatsSample(nil), stats.History...)
+		result = append(result, copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].UCode != result[j].UCode {
+			return result[i].UCode < result[j].UCode
+		}
+		return result[i].Source < result[j].Source
+	})
+	return result
+}
+
+// 推送统计给操作者
+func (s *MediaStatsService) push(operatorUcode, robotUcode string, data models.CMD_WEBRTC_STATS) {
+	s.hub.Publish(ClientTopic(operatorUcode), models.Event{
+		Type:  models.EventTypeMessage,
+		UCode: operatorUcode,
+		Data: models.WebSocketMessage{
+			Type:       models.WSMessageTypeRequest,
+			Command:    models.CMD_TYPE_WEBRTC_STATS,
+			Sequence:   atomic.AddInt64(&s.sequence, 1),
+			UCode:      robotUcode,
+			ClientType: models.ClientTypeRobot,
+			Version:    models.ProtocolVersionCurrent,
+			Data:       data,
+		},
+	})
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 消息策略默认值
+const (
+	DefaultMaxViolations   = 10
+	DefaultViolationWindow = time.Minute
+)
+
+// 令牌桶
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit models.RateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// 补充令牌后尝试取出一个
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 单个客户端的限速和违规状态
+type clientPolicy struct {
+	bucket     *tokenBucket
+	commands   map[models.CommandType]*tokenBucket
+	recent     []time.Time
+	violations models.ClientViolations
+}
+
+// MessagePolicy WebSocket消息违规处理和限速
+type MessagePolicy struct {
+	mutex         sync.Mutex
+	onViolation   string
+	maxViolations int
+	window        time.Duration
+	clientLimit   models.RateLimit
+	commandLimits map[models.CommandType]models.RateLimit
+	exempt        map[models.CommandType]bool
+	clients       map[string]*clientPolicy
+
+	total       int64
+	byKind      map[string]int64
+	byCommand   map[string]int64
+	disconnects int64
+}
+
+// NewMessagePolicy 创建消息策略
+func NewMessagePolicy(config models.MessagePolicyConfig) *MessagePolicy {
+	onViolation := config.OnViolation
+	switch onViolation {
+	case models.ViolationActionReply, models.ViolationActionDisconnect:
+	case "":
+		onViolation = models.ViolationActionReply
+	default:
+		log.Warn().Str("on_violation", onViolation).Msg("Unknown violation action, using reply")
+		onViolation = models.ViolationActionReply
+	}
+
+	maxViolations := config.MaxViolations
+	if maxViolations <= 0 {
+		maxViolations = DefaultMaxViolations
+	}
+	window := config.ViolationWindow
+	if window <= 0 {
+		window = DefaultViolationWindow
+	}
+
+	commandLimits := make(map[models.CommandType]models.RateLimit)
+	for _, limit := range config.CommandLimits {
+		if limit.Command == "" || limit.Rate <= 0 {
+			continue
+		}
+		commandLimits[limit.Command] = limit.RateLimit
+	}
+
+	// 急停永远不限速
+	exempt := map[models.CommandType]bool{
+		models.CMD_TYPE_EMERGENCY_STOP: true,
+	}
+	for _, command := range config.Exempt {
+		exempt[command] = true
+	}
+
+	return &MessagePolicy{
+		onViolation:   onViolation,
+		maxViolations: maxViolations,
+		window:        window,
+		clientLimit:   config.ClientLimit,
+		commandLimits: commandLimits,
+		exempt:        exempt,
+		clients:       make(map[string]*clientPolicy),
+		byKind:        make(map[string]int64),
+		byCommand:     make(map[string]int64),
+	}
+}
+
+func (p *MessagePolicy) client(ucode string) *clientPolicy {
+	client, exists := p.clients[ucode]
+	if !exists {
+		client = &clientPolicy{
+			commands: make(map[models.CommandType]*tokenBucket),
+			violations: models.ClientViolations{
+				UCode:  ucode,
+				ByKind: make(map[string]int),
+			},
+		}
+		p.clients[ucode] = client
+	}
+	return client
+}
+
+// Allow 检查客户端的总速率和单命令速率
+func (p *MessagePolicy) Allow(ucode string, command models.CommandType) error {
+	if p.exempt[command] {
+		return nil
+	}
+
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	client := p.client(ucode)
+
+	if limit, exists := p.commandLimits[command]; exists {
+		bucket, ok := client.commands[command]
+		if !ok {
+			bucket = newTokenBucket(limit, now)
+			client.commands[command] = bucket
+		}
+		if !bucket.allow(now) {
+			return models.Errorf(models.ErrorCodeRateLimited, "rate limit exceeded for %s (%.1f/s)", command, limit.Rate)
+		}
+	}
+
+	if p.clientLimit.Rate > 0 {
+		if client.bucket == nil {
+			client.bucket = newTokenBucket(p.clientLimit, now)
+		}
+		if !client.bucket.allow(now) {
+			return models.Errorf(models.ErrorCodeRateLimited, "rate limit exceeded (%.1f messages/s)", p.clientLimit.Rate)
+		}
+	}
+	return nil
+}
+
+// RecordViolation 记录违规，返回是否需要断开连接
+func (p *MessagePolicy) RecordViolation(ucode string, command models.CommandType, kind, reason string) bool {
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.total++
+	p.byKind[kind]++
+	if command != "" {
+		p.byCommand[string(command)]++
+	}
+
+	client := p.client(ucode)
+
+	// 只保留窗口内的违规
+	recent := client.recent[:0]
+	for _, at := range client.recent {
+		if now.Sub(at) < p.window {
+			recent = append(recent, at)
+		}
+	}
+	client.recent = append(recent, now)
+
+	client.violations.Total++
+	client.violations.Recent = len(client.recent)
+	client.violations.ByKind[kind]++
+	client.violations.LastKind = kind
+	client.violations.LastReason = reason
+	client.violations.LastAt = now
+
+	if p.onViolation == models.ViolationActionDisconnect && len(client.recent) >= p.maxViolations {
+		p.disconnects++
+		return true
+	}
+	return false
+}
+
+// Remove 客户端断开后清除限速状态，违规统计保留
+func (p *MessagePolicy) Remove(ucode string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	client, exists := p.clients[ucode]
+	if !exists {
+		return
+	}
+	if client.violations.Total == 0 {
+		delete(p.clients, ucode)
+		return
+	}
+	client.bucket = nil
+	client.commands = make(map[models.CommandType]*tokenBucket)
+}
+
+// Metrics 获取违规指标
+func (p *MessagePolicy) Metrics() models.ViolationMetrics {
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	metrics := models.ViolationMetrics{
+		Total:       p.total,
+		ByKind:      make(map[string]int64, len(p.byKind)),
+		ByCommand:   make(map[string]int64, len(p.byCommand)),
+		Disconnects: p.disconnects,
+		Clients:     make([]models.ClientViolations, 0, len(p.clients)),
+	}
+	for kind, count := range p.byKind {
+		metrics.ByKind[kind] = count
+	}
+	for command, count := range p.byCommand {
+		metrics.ByCommand[command] = count
+	}
+
+	for _, client := range p.clients {
+		if client.violations.Total == 0 {
+			continue
+		}
+		violations := client.violations
+		violations.Recent = 0
+		for _, at := range client.recent {
+			if now.Sub(at) < p.window {
+				violations.Recent++
+			}
+		}
+		violations.ByKind = make(map[string]int, len(client.violations.ByKind))
+		for kind, count := range client.violations.ByKind {
+			violations.ByKind[kind] = count
+		}
+		metrics.Clients = append(metrics.Clients, violations)
+	}
+	sort.Slice(metrics.Clients, func(i, j int) bool {
+		return metrics.Clients[i].Total > metrics.Clients[j].Total
+	})
+	return metrics
+}
@@ -0,0 +1,641 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 任务限制
+const (
+	MaxMissionSteps = 256 // 展开前的步骤总数上限
+	MaxMissionDepth = 4   // 循环嵌套层数上限
+)
+
+// 执行节拍：Move重发间隔、状态条件检查间隔
+const missionTick = 100 * time.Millisecond
+
+// MissionExecutor 任务命令的实际执行方
+type MissionExecutor interface {
+	ValidateControl(robotUcode string, command *models.CMD_CONTROL_ROBOT) error
+	ExecuteControl(robotUcode string, from *models.Client, command models.CMD_CONTROL_ROBOT) (*models.SafetyLimits, error)
+	GetRobotState(robotUcode string) (models.RobotState, bool)
+}
+
+// 校验后的任务步骤
+type missionStep struct {
+	path      string
+	name      string
+	kind      string
+	command   models.CMD_CONTROL_ROBOT
+	duration  time.Duration
+	condition *models.MissionCondition
+	timeout   time.Duration
+	count     int
+	steps     []missionStep
+}
+
+// 单个机器人的任务执行
+type missionRun struct {
+	status      models.MissionStatus
+	steps       []missionStep
+	ctx         context.Context
+	cancel      context.CancelFunc
+	paused      bool
+	resume      chan struct{}
+	abortReason string
+
+	// 下发与暂停互斥，暂停后不会有任务命令排在停止命令之后
+	control sync.Mutex
+}
+
+func (r *missionRun) active() bool {
+	return r.status.State == models.MissionStateRunning || r.status.State == models.MissionStatePaused
+}
+
+// MissionService 脚本任务执行
+type MissionService struct {
+	mutex    sync.Mutex
+	executor MissionExecutor
+	hub      *EventHub
+	runs     map[string]*missionRun // 每个机器人最近一次任务
+}
+
+// NewMissionService 创建任务服务
+func NewMissionService(hub *EventHub) *MissionService {
+	return &MissionService{
+		hub:  hub,
+		runs: make(map[string]*missionRun),
+	}
+}
+
+// SetExecutor 设置命令执行方
+func (s *MissionService) SetExecutor(executor MissionExecutor) {
+	s.executor = executor
+}
+
+// 解析持续时间，空字符串为0
+func parseMissionDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// 条件字段是否为数值
+func numericConditionField(field string) bool {
+	switch field {
+	case "battery_level", "temperature", "error_code", "x", "y", "z":
+		return true
+	}
+	return false
+}
+
+// 校验并编译任务步骤
+func (s *MissionService) compile(ucode string, steps []models.MissionStep, prefix string, depth int, total *int) ([]missionStep, error) {
+	if depth > MaxMissionDepth {
+		return nil, models.Errorf(models.ErrorCodeInvalidMission, "step %s: loops nested deeper than %d", strings.TrimSuffix(prefix, "."), MaxMissionDepth)
+	}
+
+	compiled := make([]missionStep, 0, len(steps))
+	for i, step := range steps {
+		*total++
+		if *total > MaxMissionSteps {
+			return nil, models.Errorf(models.ErrorCodeInvalidMission, "mission has more than %d steps", MaxMissionSteps)
+		}
+
+		path := prefix + strconv.Itoa(i+1)
+		invalid := func(format string, args ...interface{}) error {
+			return models.Errorf(models.ErrorCodeInvalidMission, "step %s: %s", path, fmt.Sprintf(format, args...))
+		}
+
+		duration, err := parseMissionDuration(step.Duration)
+		if err != nil {
+			return nil, invalid("%v", err)
+		}
+
+		item := missionStep{
+			path:     path,
+			name:     step.Name,
+			kind:     step.Type,
+			duration: duration,
+		}
+
+		switch step.Type {
+		case models.MissionStepCommand:
+			if step.Action == "" {
+				return nil, invalid("action is required")
+			}
+			item.command = models.CMD_CONTROL_ROBOT{
+				Action:    step.Action,
+				ParamMaps: step.Params,
+			}
+			if item.command.ParamMaps == nil {
+				item.command.ParamMaps = map[string]string{}
+			}
+			if s.executor != nil {
+				if err := s.executor.ValidateControl(ucode, &item.command); err != nil {
+					return nil, invalid("%v", err)
+				}
+			}
+			if item.name == "" {
+				item.name = step.Action
+			}
+
+		case models.MissionStepWait:
+			if step.Condition == nil && duration == 0 {
+				return nil, invalid("wait needs a duration or a condition")
+			}
+			if step.Condition != nil {
+				if err := validateCondition(step.Condition); err != nil {
+					return nil, invalid("%v", err)
+				}
+				item.condition = step.Condition
+			}
+			if item.timeout, err = parseMissionDuration(step.Timeout); err != nil {
+				return nil, invalid("%v", err)
+			}
+			if item.name == "" {
+				item.name = "wait"
+			}
+
+		case models.MissionStepLoop:
+			if step.Count < 1 {
+				return nil, invalid("loop count must be at least 1")
+			}
+			if len(step.Steps) == 0 {
+				return nil, invalid("loop has no steps")
+			}
+			item.count = step.Count
+			if item.steps, err = s.compile(ucode, step.Steps, path+".", depth+1, total); err != nil {
+				return nil, err
+			}
+			if item.name == "" {
+				item.name = "loop"
+			}
+
+		default:
+			return nil, invalid("unknown step type %q", step.Type)
+		}
+
+		compiled = append(compiled, item)
+	}
+	return compiled, nil
+}
+
+// 校验等待条件
+func validateCondition(condition *models.MissionCondition) error {
+	switch condition.Op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return fmt.Errorf("unknown condition operator %q", condition.Op)
+	}
+
+	if condition.Field == "status" {
+		if condition.Op != "==" && condition.Op != "!=" {
+			return fmt.Errorf("status only supports == and !=")
+		}
+		return nil
+	}
+	if !numericConditionField(condition.Field) {
+		return fmt.Errorf("unknown condition field %q", condition.Field)
+	}
+	if _, err := strconv.ParseFloat(fmt.Sprint(condition.Value), 64); err != nil {
+		return fmt.Errorf("condition value for %s must be a number", condition.Field)
+	}
+	return nil
+}
+
+// 判断状态是否满足条件
+func evaluateCondition(condition *models.MissionCondition, state models.RobotState) bool {
+	if condition.Field == "status" {
+		equal := state.Status == fmt.Sprint(condition.Value)
+		return equal == (condition.Op == "==")
+	}
+
+	var actual float64
+	switch condition.Field {
+	case "battery_level":
+		actual = state.BatteryLevel
+	case "temperature":
+		actual = state.Temperature
+	case "error_code":
+		actual = float64(state.ErrorCode)
+	case "x":
+		actual = state.BasePosition[0]
+	case "y":
+		actual = state.BasePosition[1]
+	case "z":
+		actual = state.BasePosition[2]
+	}
+	expected, _ := strconv.ParseFloat(fmt.Sprint(condition.Value), 64)
+
+	switch condition.Op {
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	case "==":
+		return actual == expected
+	default:
+		return actual != expected
+	}
+}
+
+// Start 开始执行任务
+func (s *MissionService) Start(ucode string, mission models.Mission, startedBy string) (models.MissionStatus, error) {
+	if len(mission.Steps) == 0 {
+		return models.MissionStatus{}, models.NewError(models.ErrorCodeInvalidMission, "mission has no steps")
+	}
+	total := 0
+	steps, err := s.compile(ucode, mission.Steps, "", 1, &total)
+	if err != nil {
+		return models.MissionStatus{}, err
+	}
+
+	s.mutex.Lock()
+	if run, exists := s.runs[ucode]; exists && run.active() {
+		s.mutex.Unlock()
+		return models.MissionStatus{}, models.Errorf(models.ErrorCodeMissionState, "robot %s is already running mission %q", ucode, run.status.Mission)
+	}
+
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &missionRun{
+		status: models.MissionStatus{
+			UCode:     ucode,
+			Mission:   mission.Name,
+			State:     models.MissionStateRunning,
+			StartedBy: startedBy,
+			StartedAt: now,
+			UpdatedAt: now,
+		},
+		steps:  steps,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	s.runs[ucode] = run
+	status := run.status
+	s.mutex.Unlock()
+
+	log.Info().Str("ucode", ucode).Str("mission", mission.Name).Str("started_by", startedBy).Int("steps", total).Msg("Mission started")
+	s.publish(status)
+
+	go s.execute(ucode, run)
+	return status, nil
+}
+
+// 执行任务并记录最终状态
+func (s *MissionService) execute(ucode string, run *missionRun) {
+	err := s.runSteps(ucode, run, run.steps)
+
+	s.mutex.Lock()
+	now := time.Now()
+	switch {
+	case run.ctx.Err() != nil:
+		run.status.State = models.MissionStateAborted
+		run.status.Error = run.abortReason
+	case err != nil:
+		run.status.State = models.MissionStateFailed
+		run.status.Error = err.Error()
+	default:
+		run.status.State = models.MissionStateCompleted
+	}
+	run.status.UpdatedAt = now
+	run.status.FinishedAt = &now
+	run.cancel()
+	status := run.status
+	s.mutex.Unlock()
+
+	// 中止或失败时停止机器人
+	if status.State != models.MissionStateCompleted {
+		s.stopRobot(ucode)
+	}
+
+	log.Info().Str("ucode", ucode).Str("mission", status.Mission).Str("state", status.State).Str("error", status.Error).Msg("Mission finished")
+	s.publish(status)
+}
+
+// 顺序执行步骤
+func (s *MissionService) runSteps(ucode string, run *missionRun, steps []missionStep) error {
+	for i := range steps {
+		step := &steps[i]
+		if err := s.checkpoint(run); err != nil {
+			return err
+		}
+		s.update(run, func(status *models.MissionStatus) {
+			status.Step = step.path
+			status.StepName = step.name
+		})
+
+		var err error
+		switch step.kind {
+		case models.MissionStepCommand:
+			err = s.runCommand(ucode, run, step)
+		case models.MissionStepWait:
+			err = s.runWait(ucode, run, step)
+		case models.MissionStepLoop:
+			for n := 1; n <= step.count && err == nil; n++ {
+				s.update(run, func(status *models.MissionStatus) {
+					status.Iteration = n
+				})
+				err = s.runSteps(ucode, run, step.steps)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 下发命令步骤，Move在持续时间内重复下发，结束后停止
+func (s *MissionService) runCommand(ucode string, run *missionRun, step *missionStep) error {
+	send := func() error {
+		if err := s.sendCommand(ucode, run, step.command); err != nil {
+			return fmt.Errorf("step %s (%s): %w", step.path, step.command.Action, err)
+		}
+		s.update(run, func(status *models.MissionStatus) {
+			status.CommandSent++
+		})
+		return nil
+	}
+
+	if err := send(); err != nil {
+		return err
+	}
+	if step.duration == 0 {
+		return nil
+	}
+
+	if step.command.Action != models.ControlActionMove {
+		return s.sleep(run, step.duration, nil)
+	}
+	if err := s.sleep(run, step.duration, send); err != nil {
+		return err
+	}
+	_, err := s.executor.ExecuteControl(ucode, nil, models.CMD_CONTROL_ROBOT{
+		Action:    models.ControlActionStop,
+		ParamMaps: map[string]string{"reason": "mission step finished"},
+		Timestamp: time.Now().UnixMilli(),
+	})
+	return err
+}
+
+// 等待步骤
+func (s *MissionService) runWait(ucode string, run *missionRun, step *missionStep) error {
+	if step.condition == nil {
+		return s.sleep(run, step.duration, nil)
+	}
+
+	started := time.Now()
+	for {
+		if err := s.checkpoint(run); err != nil {
+			return err
+		}
+		if state, ok := s.executor.GetRobotState(ucode); ok && evaluateCondition(step.condition, state) {
+			return nil
+		}
+		if step.timeout > 0 && time.Since(started) > step.timeout {
+			return fmt.Errorf("step %s: timed out after %s waiting for %s %s %v",
+				step.path, step.timeout, step.condition.Field, step.condition.Op, step.condition.Value)
+		}
+		select {
+		case <-time.After(missionTick):
+		case <-run.ctx.Done():
+			return run.ctx.Err()
+		}
+	}
+}
+
+// 等待指定时间，暂停期间不计时，每个节拍调用onTick
+func (s *MissionService) sleep(run *missionRun, d time.Duration, onTick func() error) error {
+	remaining := d
+	for remaining > 0 {
+		if err := s.checkpoint(run); err != nil {
+			return err
+		}
+		tick := missionTick
+		if remaining < tick {
+			tick = remaining
+		}
+		select {
+		case <-time.After(tick):
+			remaining -= tick
+		case <-run.ctx.Done():
+			return run.ctx.Err()
+		}
+		if onTick != nil && remaining > 0 {
+			if err := onTick(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 下发任务命令，暂停时等待恢复后再下发
+func (s *MissionService) sendCommand(ucode string, run *missionRun, command models.CMD_CONTROL_ROBOT) error {
+	for {
+		if err := s.checkpoint(run); err != nil {
+			return err
+		}
+
+		// 持有control时重新检查，Pause在检查之后才能暂停并下发停止命令
+		run.control.Lock()
+		s.mutex.Lock()
+		paused := run.paused
+		s.mutex.Unlock()
+		if paused {
+			run.control.Unlock()
+			continue
+		}
+
+		command.Timestamp = time.Now().UnixMilli()
+		_, err := s.executor.ExecuteControl(ucode, nil, command)
+		run.control.Unlock()
+		return err
+	}
+}
+
+// 暂停时阻塞，中止时返回错误
+func (s *MissionService) checkpoint(run *missionRun) error {
+	for {
+		s.mutex.Lock()
+		paused, resume := run.paused, run.resume
+		s.mutex.Unlock()
+
+		if !paused {
+			return run.ctx.Err()
+		}
+		select {
+		case <-resume:
+		case <-run.ctx.Done():
+			return run.ctx.Err()
+		}
+	}
+}
+
+// 更新执行状态
+func (s *MissionService) update(run *missionRun, change func(status *models.MissionStatus)) {
+	s.mutex.Lock()
+	change(&run.status)
+	run.status.UpdatedAt = time.Now()
+	status := run.status
+	s.mutex.Unlock()
+	s.publish(status)
+}
+
+// 下发停止命令
+func (s *MissionService) stopRobot(ucode string) {
+	_, err := s.executor.ExecuteControl(ucode, nil, models.CMD_CONTROL_ROBOT{
+		Action:    models.ControlActionStop,
+		ParamMaps: map[string]string{"reason": "mission interrupted"},
+		Timestamp: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("ucode", ucode).Msg("Failed to stop robot after mission")
+	}
+}
+
+// 获取正在执行的任务
+func (s *MissionService) activeRun(ucode string) (*missionRun, error) {
+	run, exists := s.runs[ucode]
+	if !exists || !run.active() {
+		return nil, models.Errorf(models.ErrorCodeMissionNotFound, "no active mission for robot %s", ucode)
+	}
+	return run, nil
+}
+
+// Pause 暂停任务并停止机器人
+func (s *MissionService) Pause(ucode string) (models.MissionStatus, error) {
+	s.mutex.Lock()
+	run, err := s.activeRun(ucode)
+	s.mutex.Unlock()
+	if err != nil {
+		return models.MissionStatus{}, err
+	}
+
+	// 等待正在进行的下发完成，停止命令下发前执行协程不能再下发
+	run.control.Lock()
+	defer run.control.Unlock()
+
+	s.mutex.Lock()
+	if !run.active() {
+		s.mutex.Unlock()
+		return models.MissionStatus{}, models.Errorf(models.ErrorCodeMissionNotFound, "no active mission for robot %s", ucode)
+	}
+	if run.paused {
+		status := run.status
+		s.mutex.Unlock()
+		return status, nil
+	}
+	run.paused = true
+	run.resume = make(chan struct{})
+	run.status.State = models.MissionStatePaused
+	run.status.UpdatedAt = time.Now()
+	status := run.status
+	s.mutex.Unlock()
+
+	s.stopRobot(ucode)
+	log.Info().Str("ucode", ucode).Str("mission", status.Mission).Str("step", status.Step).Msg("Mission paused")
+	s.publish(status)
+	return status, nil
+}
+
+// Resume 继续任务
+func (s *MissionService) Resume(ucode string) (models.MissionStatus, error) {
+	s.mutex.Lock()
+	run, err := s.activeRun(ucode)
+	if err != nil {
+		s.mutex.Unlock()
+		return models.MissionStatus{}, err
+	}
+	if run.paused {
+		run.paused = false
+		close(run.resume)
+		run.status.State = models.MissionStateRunning
+		run.status.UpdatedAt = time.Now()
+	}
+	status := run.status
+	s.mutex.Unlock()
+
+	log.Info().Str("ucode", ucode).Str("mission", status.Mission).Msg("Mission resumed")
+	s.publish(status)
+	return status, nil
+}
+
+// Abort 中止任务，最终状态由执行协程更新
+func (s *MissionService) Abort(ucode, reason string) (models.MissionStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, err := s.activeRun(ucode)
+	if err != nil {
+		return models.MissionStatus{}, err
+	}
+	if reason == "" {
+		reason = "aborted"
+	}
+	if run.abortReason == "" {
+		run.abortReason = reason
+	}
+	run.cancel()
+	return run.status, nil
+}
+
+// IsRunning 任务是否正在执行（暂停不算）
+func (s *MissionService) IsRunning(ucode string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, exists := s.runs[ucode]
+	return exists && run.status.State == models.MissionStateRunning
+}
+
+// GetStatus 获取机器人最近一次任务的状态
+func (s *MissionService) GetStatus(ucode string) (models.MissionStatus, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, exists := s.runs[ucode]
+	if !exists {
+		return models.MissionStatus{}, false
+	}
+	return run.status, true
+}
+
+// GetStatuses 获取所有机器人的任务状态
+func (s *MissionService) GetStatuses() []models.MissionStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	statuses := make([]models.MissionStatus, 0, len(s.runs))
+	for _, run := range s.runs {
+		statuses = append(statuses, run.status)
+	}
+	return statuses
+}
+
+// 发布任务状态事件
+func (s *MissionService) publish(status models.MissionStatus) {
+	if s.hub == nil {
+		return
+	}
+	s.hub.Publish(TopicFleet, models.Event{
+		Type:  models.EventTypeMission,
+		UCode: status.UCode,
+		Data:  status,
+	})
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SemVer 语义化版本，忽略预发布和构建信息
+type SemVer struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseSemVer 解析 major.minor.patch 格式的版本号，允许 v 前缀和省略 patch
+func ParseSemVer(version string) (SemVer, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+
+	parts := strings.Split(trimmed, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return SemVer{}, fmt.Errorf("invalid version %q, expected major.minor.patch", version)
+	}
+
+	numbers := [3]int{}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return SemVer{}, fmt.Errorf("invalid version %q, expected major.minor.patch", version)
+		}
+		numbers[i] = n
+	}
+	return SemVer{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// Compare 比较版本，返回 -1, 0, 1
+func (v SemVer) Compare(other SemVer) int {
+	switch {
+	case v.Major != other.Major:
+		return compareInt(v.Major, other.Major)
+	case v.Minor != other.Minor:
+		return compareInt(v.Minor, other.Minor)
+	default:
+		return compareInt(v.Patch, other.Patch)
+	}
+}
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func (v SemVer) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// MessageAdapter 在当前协议格式与旧版本格式之间转换消息
+type MessageAdapter interface {
+	// Inbound 将客户端消息升级为当前版本格式
+	Inbound(msg *models.WebSocketMessage)
+	// Outbound 将服务端消息降级为客户端版本格式，返回false表示该版本不支持此消息
+	Outbound(msg *models.WebSocketMessage) bool
+}
+
+// 版本适配器，用于协商版本低于 until 的客户端
+type versionAdapter struct {
+	until   SemVer
+	adapter MessageAdapter
+}
+
+// 适配器链：入站从旧到新依次升级，出站从新到旧依次降级
+type adapterChain struct {
+	version  string
+	adapters []MessageAdapter
+}
+
+func (c *adapterChain) Inbound(msg *models.WebSocketMessage) {
+	for _, adapter := range c.adapters {
+		adapter.Inbound(msg)
+	}
+}
+
+func (c *adapterChain) Outbound(msg *models.WebSocketMessage) bool {
+	for i := len(c.adapters) - 1; i >= 0; i-- {
+		if !c.adapters[i].Outbound(msg) {
+			return false
+		}
+	}
+	msg.Version = c.version
+	return true
+}
+
+// ProtocolNegotiator WebSocket协议版本协商
+type ProtocolNegotiator struct {
+	min      SemVer
+	max      SemVer
+	adapters []versionAdapter // 按版本从旧到新排列
+}
+
+// NewProtocolNegotiator 创建协议协商器，minVersion为空时使用内置最低版本
+func NewProtocolNegotiator(minVersion string) *ProtocolNegotiator {
+	max, _ := ParseSemVer(models.ProtocolVersionCurrent)
+	builtinMin, _ := ParseSemVer(models.ProtocolVersionMin)
+
+	min := builtinMin
+	if minVersion != "" {
+		parsed, err := ParseSemVer(minVersion)
+		switch {
+		case err != nil:
+			log.Warn().Err(err).Msg("Invalid protocol.min_version, using built-in minimum")
+		case parsed.Compare(builtinMin) < 0 || parsed.Compare(max) > 0:
+			log.Warn().Str("min_version", minVersion).Msg("protocol.min_version out of range, using built-in minimum")
+		default:
+			min = parsed
+		}
+	}
+
+	return &ProtocolNegotiator{
+		min: min,
+		max: max,
+		adapters: []versionAdapter{
+			{until: SemVer{Major: 1, Minor: 1}, adapter: v1_0Adapter{}},
+		},
+	}
+}
+
+// Range 获取支持的版本范围
+func (p *ProtocolNegotiator) Range() models.ProtocolRange {
+	return models.ProtocolRange{
+		Min: p.min.String(),
+		Max: p.max.String(),
+	}
+}
+
+// Negotiate 协商协议版本。同一主版本下客户端版本高于服务端时降级到服务端版本
+func (p *ProtocolNegotiator) Negotiate(clientVersion string) (string, *models.ProtocolError) {
+	version, err := ParseSemVer(clientVersion)
+	if err != nil {
+		return "", &models.ProtocolError{
+			Code:          models.ErrorCodeInvalidProtocolVersion,
+			Message:       err.Error(),
+			ClientVersion: clientVersion,
+			Supported:     p.Range(),
+		}
+	}
+
+	if version.Compare(p.min) < 0 || version.Major != p.max.Major {
+		return "", &models.ProtocolError{
+			Code: models.ErrorCodeUnsupportedProtocolVersion,
+			Message: fmt.Sprintf("protocol version %s is not supported, server supports %s - %s",
+				clientVersion, p.min, p.max),
+			ClientVersion: clientVersion,
+			Supported:     p.Range(),
+		}
+	}
+
+	if version.Compare(p.max) > 0 {
+		version = p.max
+	}
+	return version.String(), nil
+}
+
+// Adapter 获取协商版本对应的消息适配器
+func (p *ProtocolNegotiator) Adapter(version string) MessageAdapter {
+	chain := &adapterChain{version: version}
+	parsed, err := ParseSemVer(version)
+	if err != nil {
+		return chain
+	}
+	for _, adapter := range p.adapters {
+		if parsed.Compare(adapter.until) < 0 {
+			chain.adapters = append(chain.adapters, adapter.adapter)
+		}
+	}
+	return chain
+}
+
+// 1.0.x 客户端适配：
+//   - 注册时 capabilities 为动作列表、型号放在 robot_type 中
+//   - 不支持 1.1 新增的推送命令
+type v1_0Adapter struct{}
+
+// 1.1 新增的服务端推送命令，1.0 客户端无法识别
+var v1_1PushCommands = map[models.CommandType]bool{
+	models.CMD_TYPE_GEOFENCE_WARNING: true,
+	models.CMD_TYPE_LINK_QUALITY:     true,
+	models.CMD_TYPE_WEBRTC_ICE:       true,
+	models.CMD_TYPE_WEBRTC_STATE:     true,
+	models.CMD_TYPE_WEBRTC_PUSH:      true,
+	models.CMD_TYPE_WEBRTC_STATS:     true,
+	models.CMD_TYPE_MISSION_STATUS:   true,
+}
+
+func (v1_0Adapter) Inbound(msg *models.WebSocketMessage) {
+	if msg.Command != models.CMD_TYPE_REGISTER {
+		return
+	}
+	payload, ok := msg.Data.(models.Payload)
+	if !ok || payload.Empty() {
+		return
+	}
+	var data map[string]interface{}
+	if err := payload.Decode(&data); err != nil {
+		return
+	}
+	if _, legacy := data["robot_type"]; !legacy {
+		if _, isList := data["capabilities"].([]interface{}); !isList {
+			return
+		}
+	}
+
+	capabilities := map[string]interface{}{}
+	switch legacy := data["capabilities"].(type) {
+	case []interface{}:
+		capabilities["actions"] = legacy
+	case map[string]interface{}:
+		capabilities = legacy
+	}
+	if robotType, ok := data["robot_type"].(string); ok && robotType != "" {
+		if _, exists := capabilities["model"]; !exists {
+			capabilities["model"] = robotType
+		}
+	}
+	delete(data, "robot_type")
+	if len(capabilities) > 0 {
+		data["capabilities"] = capabilities
+	}
+	msg.Data = models.ValuePayload{Value: data}
+}
+
+func (v1_0Adapter) Outbound(msg *models.WebSocketMessage) bool {
+	// 响应对应客户端自己发出的命令，照常返回
+	return msg.Type == models.WSMessageTypeResponse || !v1_1PushCommands[msg.Command]
+}
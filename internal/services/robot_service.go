@@ -1,7 +1,11 @@
 package services
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	mathrand "math/rand"
 	"sync"
 	"time"
 
@@ -11,128 +15,375 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// 上游机器人桥接默认配置
+const (
+	DefaultRobotControlTimeout    = 100 * time.Millisecond
+	DefaultRobotMaxRetries        = 3
+	DefaultRobotHeartbeatInterval = 5 * time.Second
+	DefaultRobotInitialBackoff    = time.Second
+	DefaultRobotMaxBackoff        = 30 * time.Second
+	DefaultRobotDialTimeout       = 5 * time.Second
+)
+
+// 上游连接读超时，需大于心跳间隔
+const robotReadTimeout = 30 * time.Second
+
+// 已注册的上游连接正常结束
+var errRobotConnectionClosed = errors.New("robot connection closed")
+
+// RobotGateway 把上游机器人连接作为机器人客户端接入控制服务
+type RobotGateway interface {
+	// ServeRobot 以配置的UCode注册上游连接并处理其消息，注册成功后调用registered，连接断开后返回；
+	// 注册失败时返回错误
+	ServeRobot(conn *websocket.Conn, config models.RobotConfig, registered func()) error
+}
+
+// RobotService 上游机器人桥接：主动连接自身提供WebSocket服务的机器人，断线后指数退避重连
 type RobotService struct {
-	websocketURL    string
+	config  models.RobotConfig
+	gateway RobotGateway
+	hub     *EventHub
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running bool
+
 	conn            *websocket.Conn
 	mutex           sync.RWMutex
+	state           string
 	connected       bool
 	lastHeartbeat   time.Time
 	latency         int64
 	totalCommands   int64
 	failedCommands  int64
 	lastCommandTime time.Time
-	clients         map[*websocket.Conn]bool
-	clientMutex     sync.RWMutex
+	connections     int64
+	reconnects      int64
+	retries         int
+	lastError       string
 }
 
-func NewRobotService(websocketURL string) *RobotService {
+// NewRobotService 创建上游机器人桥接
+func NewRobotService(config models.RobotConfig, hub *EventHub) *RobotService {
+	if config.ControlTimeout <= 0 {
+		config.ControlTimeout = DefaultRobotControlTimeout
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = DefaultRobotMaxRetries
+	}
+	if config.HeartbeatInterval <= 0 || config.HeartbeatInterval >= robotReadTimeout {
+		config.HeartbeatInterval = DefaultRobotHeartbeatInterval
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = DefaultRobotInitialBackoff
+	}
+	if config.MaxBackoff < config.InitialBackoff {
+		config.MaxBackoff = DefaultRobotMaxBackoff
+	}
+
+	state := models.RobotLinkDisconnected
+	if !robotBridgeEnabled(config) {
+		state = models.RobotLinkDisabled
+	}
+
 	return &RobotService{
-		websocketURL: websocketURL,
-		clients:      make(map[*websocket.Conn]bool),
+		config: config,
+		hub:    hub,
+		state:  state,
 	}
 }
 
-// 连接到机器人
-func (s *RobotService) Connect() error {
+func robotBridgeEnabled(config models.RobotConfig) bool {
+	return config.WebSocketURL != "" && config.UCode != ""
+}
+
+// SetGateway 设置上游连接的接入方
+func (s *RobotService) SetGateway(gateway RobotGateway) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	s.gateway = gateway
+}
 
-	if s.connected {
-		return nil
+// Start 启动桥接，未配置地址或UCode时不启用
+func (s *RobotService) Start() {
+	if !robotBridgeEnabled(s.config) {
+		log.Info().Msg("Robot bridge disabled, robot.websocket_url and robot.ucode are required")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running {
+		return
 	}
+	s.running = true
+	s.retries = 0
+	s.state = models.RobotLinkConnecting
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run(s.ctx)
+
+	log.Info().
+		Str("url", s.config.WebSocketURL).
+		Str("ucode", s.config.UCode).
+		Int("max_retries", s.config.MaxRetries).
+		Msg("Robot bridge started")
+}
 
-	// 连接到机器人WebSocket
-	conn, _, err := websocket.DefaultDialer.Dial(s.websocketURL, nil)
+// Reconnect 重连次数用尽后重新启动桥接
+func (s *RobotService) Reconnect() error {
+	if !robotBridgeEnabled(s.config) {
+		return models.NewError(models.ErrorCodeUnavailable, "robot bridge is not configured")
+	}
+	s.Start()
+	return nil
+}
+
+// Shutdown 停止桥接并断开上游连接
+func (s *RobotService) Shutdown() {
+	s.mutex.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mutex.Unlock()
+	s.Disconnect()
+}
+
+// 连接循环
+func (s *RobotService) run(ctx context.Context) {
+	defer func() {
+		s.mutex.Lock()
+		s.running = false
+		if s.state != models.RobotLinkConnected {
+			s.state = models.RobotLinkDisconnected
+		}
+		s.mutex.Unlock()
+	}()
+
+	for {
+		conn, err := s.connect(ctx)
+		if err == nil {
+			err = s.serve(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// 每次断开都退避，注册成功时重试次数已清零
+		s.mutex.Lock()
+		s.retries++
+		retries := s.retries
+		s.lastError = err.Error()
+		s.mutex.Unlock()
+
+		if s.config.MaxRetries > 0 && retries > s.config.MaxRetries {
+			log.Error().
+				Err(err).
+				Str("url", s.config.WebSocketURL).
+				Int("attempts", retries).
+				Msg("Robot bridge giving up after max retries")
+			return
+		}
+
+		delay := s.backoff(retries)
+		log.Warn().
+			Err(err).
+			Str("url", s.config.WebSocketURL).
+			Int("attempt", retries).
+			Dur("retry_in", delay).
+			Msg("Robot bridge disconnected, retrying")
+		if !sleepContext(ctx, delay) {
+			return
+		}
+	}
+}
+
+// 拨号并初始化上游连接
+func (s *RobotService) connect(ctx context.Context) (*websocket.Conn, error) {
+	s.mutex.Lock()
+	s.state = models.RobotLinkConnecting
+	s.mutex.Unlock()
+
+	dialer := websocket.Dialer{
+		Proxy:            websocket.DefaultDialer.Proxy,
+		HandshakeTimeout: DefaultRobotDialTimeout,
+	}
+	conn, _, err := dialer.DialContext(ctx, s.config.WebSocketURL, nil)
 	if err != nil {
-		log.Error().Err(err).Str("url", s.websocketURL).Msg("Failed to connect to robot")
-		return fmt.Errorf("failed to connect to robot: %w", err)
+		return nil, fmt.Errorf("failed to connect to robot: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
+	// pong回显ping中的发送时间，用于更新心跳和延迟
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(robotReadTimeout))
+		now := time.Now()
+		s.mutex.Lock()
+		s.lastHeartbeat = now
+		if len(appData) == 8 {
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64([]byte(appData))))
+			s.latency = now.Sub(sent).Milliseconds()
+		}
+		s.mutex.Unlock()
+		return nil
+	})
+	return conn, nil
+}
+
+// 接入上游连接，阻塞直到断开，返回断开原因
+func (s *RobotService) serve(ctx context.Context, conn *websocket.Conn) error {
+	s.mutex.Lock()
+	if s.connections > 0 {
+		s.reconnects++
+	}
+	s.connections++
 	s.conn = conn
-	s.connected = true
 	s.lastHeartbeat = time.Now()
+	gateway := s.gateway
+	s.mutex.Unlock()
 
-	log.Info().Str("url", s.websocketURL).Msg("Connected to robot")
-	return nil
+	log.Info().Str("url", s.config.WebSocketURL).Str("ucode", s.config.UCode).Msg("Connected to robot")
+
+	done := make(chan struct{})
+	go s.heartbeat(conn, done)
+
+	var err error
+	if gateway != nil {
+		err = gateway.ServeRobot(conn, s.config, s.registered)
+	} else {
+		s.registered()
+		err = s.discard(conn)
+	}
+	close(done)
+	conn.Close()
+
+	s.mutex.Lock()
+	if s.conn == conn {
+		s.conn = nil
+	}
+	s.connected = false
+	s.state = models.RobotLinkConnecting
+	s.mutex.Unlock()
+
+	if err == nil {
+		err = errRobotConnectionClosed
+	}
+	return err
+}
+
+// 上游连接注册成功，清零重试次数
+func (s *RobotService) registered() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected = true
+	s.state = models.RobotLinkConnected
+	s.retries = 0
+	s.lastError = ""
+}
+
+// 未设置接入方时只维持连接
+func (s *RobotService) discard(conn *websocket.Conn) error {
+	for {
+		conn.SetReadDeadline(time.Now().Add(robotReadTimeout))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
+
+// 定期发送带时间戳的ping
+func (s *RobotService) heartbeat(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(s.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	timestamp := make([]byte, 8)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			binary.BigEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()))
+			if err := conn.WriteControl(websocket.PingMessage, timestamp, time.Now().Add(s.config.HeartbeatInterval)); err != nil {
+				log.Warn().Err(err).Str("url", s.config.WebSocketURL).Msg("Failed to send heartbeat to robot")
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// 指数退避，带少量抖动
+func (s *RobotService) backoff(attempts int) time.Duration {
+	delay := s.config.InitialBackoff
+	for i := 1; i < attempts && delay < s.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.config.MaxBackoff {
+		delay = s.config.MaxBackoff
+	}
+	jitter := time.Duration(mathrand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
 }
 
-// 断开连接
+// 等待指定时间，ctx取消时返回false
+func sleepContext(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// 断开连接，桥接运行中会自动重连
 func (s *RobotService) Disconnect() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if !s.connected {
+	if s.conn == nil {
 		return nil
 	}
 
-	if s.conn != nil {
-		s.conn.Close()
-	}
+	s.conn.Close()
 
 	s.connected = false
 	log.Info().Msg("Disconnected from robot")
 	return nil
 }
 
-// 发送命令到机器人
-func (s *RobotService) SendCommand(command models.CMD_CONTROL_ROBOT) error {
-	// s.mutex.Lock()
-	// s.totalCommands++
-	// s.lastCommandTime = time.Now()
-	// s.mutex.Unlock()
-
-	// // 检查连接状态
-	// s.mutex.RLock()
-	// if !s.connected || s.conn == nil {
-	// 	s.mutex.RUnlock()
-	// 	s.mutex.Lock()
-	// 	s.failedCommands++
-	// 	s.mutex.Unlock()
-
-	// 	log.Error().
-	// 		Str("command_id", command.Action).
-	// 		Msg("Cannot send command: robot not connected")
-
-	// 	return fmt.Errorf("robot not connected")
-	// }
-	// s.mutex.RUnlock()
-
-	// // 创建命令消息
-	// message := models.WebSocketMessage{
-	// 	Type:       models.WSMessageTypeRequest,
-	// 	Command:    models.CMD_TYPE_CONTROL_ROBOT,
-	// 	Sequence:   0,
-	// 	UCode:      client.UCode,
-	// 	ClientType: client.ClientType,
-	// 	Version:    client.Version,
-	// 	Data:       command,
-	// }
-
-	// // 发送命令到真实机器人
-	// if err := s.conn.WriteJSON(message); err != nil {
-	// 	s.mutex.Lock()
-	// 	s.failedCommands++
-	// 	s.mutex.Unlock()
-
-	// 	log.Error().
-	// 		Err(err).
-	// 		Str("command_id", command.CommandID).
-	// 		Msg("Failed to send command to robot")
-
-	// 	return fmt.Errorf("failed to send command to robot: %w", err)
-	// }
-
-	// // 记录成功发送的命令
-	// log.Info().
-	// 	Str("command_id", command.CommandID).
-	// 	Int("priority", command.Priority).
-	// 	Int64("timestamp", command.Timestamp).
-	// 	Msg("Command sent to robot successfully")
-
-	// // 广播给所有客户端
-	// s.broadcastToClients(message)
+// UCode 上游机器人UCode，未启用桥接时为空
+func (s *RobotService) UCode() string {
+	if !robotBridgeEnabled(s.config) {
+		return ""
+	}
+	return s.config.UCode
+}
 
-	return nil
+// RecordCommand 记录一次下发到上游机器人的命令，其他机器人忽略
+func (s *RobotService) RecordCommand(robotUcode string, err error) {
+	if robotUcode == "" || robotUcode != s.UCode() {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.totalCommands++
+	s.lastCommandTime = time.Now()
+	if err != nil {
+		s.failedCommands++
+	}
+}
+
+// ControlTimeout 上游机器人的控制命令写超时，其他机器人返回0，使用下发队列的写超时
+func (s *RobotService) ControlTimeout(robotUcode string) time.Duration {
+	if robotUcode == "" || robotUcode != s.UCode() {
+		return 0
+	}
+	return s.config.ControlTimeout
 }
 
 // 获取连接状态
@@ -141,48 +392,18 @@ func (s *RobotService) GetConnectionStatus() models.ConnectionStatus {
 	defer s.mutex.RUnlock()
 
 	return models.ConnectionStatus{
+		UCode:           s.UCode(),
+		URL:             s.config.WebSocketURL,
+		State:           s.state,
 		Connected:       s.connected,
 		LastHeartbeat:   s.lastHeartbeat,
 		Latency:         s.latency,
-		ActiveClients:   s.GetActiveClientsCount(),
+		ActiveClients:   s.hub.SubscriberCount(TopicFleet),
 		TotalCommands:   s.totalCommands,
 		FailedCommands:  s.failedCommands,
 		LastCommandTime: s.lastCommandTime,
-	}
-}
-
-// 添加客户端
-func (s *RobotService) AddClient(conn *websocket.Conn) {
-	s.clientMutex.Lock()
-	defer s.clientMutex.Unlock()
-	s.clients[conn] = true
-}
-
-// 移除客户端
-func (s *RobotService) RemoveClient(conn *websocket.Conn) {
-	s.clientMutex.Lock()
-	defer s.clientMutex.Unlock()
-	delete(s.clients, conn)
-}
-
-// 获取活跃客户端数量
-func (s *RobotService) GetActiveClientsCount() int {
-	s.clientMutex.RLock()
-	defer s.clientMutex.RUnlock()
-	return len(s.clients)
-}
-
-// 清理断开的客户端
-func (s *RobotService) CleanupDisconnectedClients() {
-	s.clientMutex.Lock()
-	defer s.clientMutex.Unlock()
-
-	for conn := range s.clients {
-		// 根据最后心跳时间来检查连接状态
-		if time.Since(s.lastHeartbeat) > 10*time.Second {
-			log.Debug().Msg("Removing disconnected client")
-			conn.Close()
-			delete(s.clients, conn)
-		}
+		Reconnects:      s.reconnects,
+		Retries:         s.retries,
+		LastError:       s.lastError,
 	}
 }
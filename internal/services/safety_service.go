@@ -0,0 +1,246 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+)
+
+// 安全包络默认值
+const (
+	DefaultMaxLinearVelocity        = 1.0
+	DefaultMaxAngularVelocity       = 1.5
+	DefaultMaxLinearAcceleration    = 2.0
+	DefaultMaxAngularAcceleration   = 4.0
+	DefaultLowBatteryThreshold      = 20.0
+	DefaultLowBatteryScale          = 0.5
+	DefaultHighTemperatureThreshold = 65.0
+	DefaultHighTemperatureScale     = 0.5
+)
+
+// 上一次实际下发的速度，加速度按距该次下发的实际时间计算
+type lastVelocity struct {
+	velocity models.VelocityCommand
+	sentAt   time.Time
+}
+
+// SafetyService Move命令安全包络
+type SafetyService struct {
+	mutex     sync.Mutex
+	defaults  models.SafetyEnvelope
+	overrides map[string]models.SafetyEnvelope
+	last      map[string]lastVelocity
+}
+
+// NewSafetyService 创建安全包络服务
+func NewSafetyService(config models.SafetyConfig) *SafetyService {
+	defaults := mergeEnvelope(models.SafetyEnvelope{
+		MaxLinearVelocity:        DefaultMaxLinearVelocity,
+		MaxAngularVelocity:       DefaultMaxAngularVelocity,
+		MaxLinearAcceleration:    DefaultMaxLinearAcceleration,
+		MaxAngularAcceleration:   DefaultMaxAngularAcceleration,
+		LowBatteryThreshold:      DefaultLowBatteryThreshold,
+		LowBatteryScale:          DefaultLowBatteryScale,
+		HighTemperatureThreshold: DefaultHighTemperatureThreshold,
+		HighTemperatureScale:     DefaultHighTemperatureScale,
+		Mode:                     models.SafetyModeClamp,
+	}, config.Default)
+
+	overrides := make(map[string]models.SafetyEnvelope)
+	for _, envelope := range config.Robots {
+		if envelope.UCode == "" {
+			continue
+		}
+		overrides[envelope.UCode] = mergeEnvelope(defaults, envelope)
+	}
+
+	return &SafetyService{
+		defaults:  defaults,
+		overrides: overrides,
+		last:      make(map[string]lastVelocity),
+	}
+}
+
+// 用非零字段覆盖基础配置
+func mergeEnvelope(base, override models.SafetyEnvelope) models.SafetyEnvelope {
+	result := base
+	result.UCode = override.UCode
+	if override.MaxLinearVelocity > 0 {
+		result.MaxLinearVelocity = override.MaxLinearVelocity
+	}
+	if override.MaxAngularVelocity > 0 {
+		result.MaxAngularVelocity = override.MaxAngularVelocity
+	}
+	if override.MaxLinearAcceleration > 0 {
+		result.MaxLinearAcceleration = override.MaxLinearAcceleration
+	}
+	if override.MaxAngularAcceleration > 0 {
+		result.MaxAngularAcceleration = override.MaxAngularAcceleration
+	}
+	if override.LowBatteryThreshold > 0 {
+		result.LowBatteryThreshold = override.LowBatteryThreshold
+	}
+	if override.LowBatteryScale > 0 {
+		result.LowBatteryScale = override.LowBatteryScale
+	}
+	if override.HighTemperatureThreshold > 0 {
+		result.HighTemperatureThreshold = override.HighTemperatureThreshold
+	}
+	if override.HighTemperatureScale > 0 {
+		result.HighTemperatureScale = override.HighTemperatureScale
+	}
+	if override.Mode != "" {
+		result.Mode = override.Mode
+	}
+	return result
+}
+
+// GetEnvelope 获取机器人的安全包络
+func (s *SafetyService) GetEnvelope(ucode string) models.SafetyEnvelope {
+	if envelope, exists := s.overrides[ucode]; exists {
+		return envelope
+	}
+	envelope := s.defaults
+	envelope.UCode = ucode
+	return envelope
+}
+
+// 根据机器人状态计算生效的限制
+func (s *SafetyService) effectiveLimits(envelope models.SafetyEnvelope, state *models.RobotState) models.SafetyLimits {
+	limits := models.SafetyLimits{
+		Scale: 1.0,
+		Mode:  envelope.Mode,
+	}
+
+	if state != nil {
+		if state.BatteryLevel > 0 && state.BatteryLevel < envelope.LowBatteryThreshold {
+			limits.Scale = math.Min(limits.Scale, envelope.LowBatteryScale)
+			limits.Reasons = append(limits.Reasons, "low_battery")
+		}
+		if state.Temperature > envelope.HighTemperatureThreshold {
+			limits.Scale = math.Min(limits.Scale, envelope.HighTemperatureScale)
+			limits.Reasons = append(limits.Reasons, "high_temperature")
+		}
+	}
+
+	limits.MaxLinearVelocity = envelope.MaxLinearVelocity * limits.Scale
+	limits.MaxAngularVelocity = envelope.MaxAngularVelocity * limits.Scale
+	limits.MaxLinearAcceleration = envelope.MaxLinearAcceleration * limits.Scale
+	limits.MaxAngularAcceleration = envelope.MaxAngularAcceleration * limits.Scale
+	return limits
+}
+
+// 读取速度参数，缺省为0
+func parseVelocityParam(params map[string]string, name string) (float64, error) {
+	value, exists := params[name]
+	if !exists || value == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, models.Errorf(models.ErrorCodeInvalidCommand, "invalid %s: %q", name, value)
+	}
+	return v, nil
+}
+
+// 限制单轴加速度，减速不受限制
+func limitAxis(last, target, maxDelta float64) float64 {
+	if last*target >= 0 && math.Abs(target) <= math.Abs(last) {
+		return target
+	}
+	if last*target < 0 {
+		// 反向时先减速到0再加速
+		last = 0
+	}
+	return math.Max(last-maxDelta, math.Min(last+maxDelta, target))
+}
+
+// ApplyMove 对Move命令应用安全包络，裁剪模式下直接修改命令参数；停止命令将速度记录归零
+func (s *SafetyService) ApplyMove(ucode string, state *models.RobotState, command *models.CMD_CONTROL_ROBOT) (*models.SafetyLimits, error) {
+	if command.Action == models.ControlActionStop {
+		s.Reset(ucode)
+		return nil, nil
+	}
+	if command.Action != models.ControlActionMove {
+		return nil, nil
+	}
+
+	var requested models.VelocityCommand
+	var err error
+	if requested.VX, err = parseVelocityParam(command.ParamMaps, "vx"); err != nil {
+		return nil, err
+	}
+	if requested.VY, err = parseVelocityParam(command.ParamMaps, "vy"); err != nil {
+		return nil, err
+	}
+	if requested.VYaw, err = parseVelocityParam(command.ParamMaps, "vyaw"); err != nil {
+		return nil, err
+	}
+
+	envelope := s.GetEnvelope(ucode)
+	limits := s.effectiveLimits(envelope, state)
+	limits.Requested = requested
+
+	applied := requested
+
+	// 速度限制：线速度按合速度等比缩放
+	linear := math.Hypot(applied.VX, applied.VY)
+	if linear > limits.MaxLinearVelocity {
+		ratio := limits.MaxLinearVelocity / linear
+		applied.VX *= ratio
+		applied.VY *= ratio
+	}
+	if math.Abs(applied.VYaw) > limits.MaxAngularVelocity {
+		applied.VYaw = math.Copysign(limits.MaxAngularVelocity, applied.VYaw)
+	}
+
+	// 加速度限制：相对上一次实际下发的速度，按实际经过的时间计算允许的变化量；
+	// 从未控制过的机器人没有可参照的速度，只受速度限制
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if previous, exists := s.last[ucode]; exists {
+		seconds := now.Sub(previous.sentAt).Seconds()
+		applied.VX = limitAxis(previous.velocity.VX, applied.VX, limits.MaxLinearAcceleration*seconds)
+		applied.VY = limitAxis(previous.velocity.VY, applied.VY, limits.MaxLinearAcceleration*seconds)
+		applied.VYaw = limitAxis(previous.velocity.VYaw, applied.VYaw, limits.MaxAngularAcceleration*seconds)
+	}
+
+	limits.Clamped = applied != requested
+	limits.Applied = applied
+
+	// 拒绝的命令不会下发，保留上一次下发的速度和时间作为下次计算的基准
+	if limits.Clamped && limits.Mode == models.SafetyModeReject {
+		return &limits, models.Errorf(models.ErrorCodeSafetyLimit, "move command exceeds safety envelope (max linear %.2f m/s, max angular %.2f rad/s, scale %.2f)",
+			limits.MaxLinearVelocity, limits.MaxAngularVelocity, limits.Scale)
+	}
+
+	if limits.Clamped {
+		params := make(map[string]string, len(command.ParamMaps)+3)
+		for k, v := range command.ParamMaps {
+			params[k] = v
+		}
+		params["vx"] = strconv.FormatFloat(applied.VX, 'f', -1, 64)
+		params["vy"] = strconv.FormatFloat(applied.VY, 'f', -1, 64)
+		params["vyaw"] = strconv.FormatFloat(applied.VYaw, 'f', -1, 64)
+		command.ParamMaps = params
+	}
+
+	s.last[ucode] = lastVelocity{
+		velocity: applied,
+		sentAt:   now,
+	}
+
+	return &limits, nil
+}
+
+// Reset 将机器人的速度记录归零，例如停止、急停或断开连接后，之后的Move从静止开始加速
+func (s *SafetyService) Reset(ucode string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.last[ucode] = lastVelocity{sentAt: time.Now()}
+}
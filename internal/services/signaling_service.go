@@ -0,0 +1,320 @@
+package services
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SignalingService 代理观看者与Janus之间的WebRTC信令
+type SignalingService struct {
+	janus *JanusService
+	hub   *EventHub
+
+	mutex    sync.Mutex
+	viewers  map[string]*models.ViewerSession // 观看者UCode/机器人UCode/视频源 -> 会话
+	byHandle map[string]*models.ViewerSession // Janus实例/句柄ID -> 会话
+	sequence int64
+}
+
+func NewSignalingService(janus *JanusService, hub *EventHub) *SignalingService {
+	s := &SignalingService{
+		janus:    janus,
+		hub:      hub,
+		viewers:  make(map[string]*models.ViewerSession),
+		byHandle: make(map[string]*models.ViewerSession),
+	}
+	janus.SetListener(s)
+	return s
+}
+
+func viewerKey(viewerUcode, robotUcode, source string) string {
+	return viewerUcode + "/" + robotUcode + "/" + source
+}
+
+// 句柄ID只在实例内唯一
+func handleKey(instance string, handleID int64) string {
+	return instance + "/" + strconv.FormatInt(handleID, 10)
+}
+
+// Watch 开始观看机器人的视频源，返回SDP offer；重复请求会替换之前的会话
+func (s *SignalingService) Watch(viewerUcode, robotUcode, source string) (*models.CMD_WEBRTC_WATCH_RESPONSE, error) {
+	stream, exists := s.janus.GetStream(robotUcode, source)
+	if !exists {
+		if source != "" {
+			return nil, models.Errorf(models.ErrorCodeNotFound, "no video source %s for robot %s", source, robotUcode)
+		}
+		return nil, models.Errorf(models.ErrorCodeNotFound, "no stream found for robot %s", robotUcode)
+	}
+	if stream.Status != "active" {
+		return nil, models.Errorf(models.ErrorCodeUnavailable, "stream for robot %s is %s", robotUcode, stream.Status)
+	}
+
+	s.Stop(viewerUcode, robotUcode, stream.Source)
+
+	handleID, err := s.janus.AttachViewer(stream.Instance)
+	if err != nil {
+		return nil, models.NewError(models.ErrorCodeUnavailable, "failed to attach viewer: "+err.Error())
+	}
+
+	offer, err := s.janus.WatchMountpoint(stream.Instance, handleID, stream.StreamID)
+	if err != nil {
+		s.janus.DetachViewer(stream.Instance, handleID)
+		return nil, err
+	}
+
+	session := &models.ViewerSession{
+		ViewerUCode: viewerUcode,
+		RobotUCode:  robotUcode,
+		Source:      stream.Source,
+		StreamID:    stream.StreamID,
+		Instance:    stream.Instance,
+		HandleID:    handleID,
+		State:       models.ViewerStateOffered,
+		StartedAt:   time.Now(),
+	}
+	s.mutex.Lock()
+	s.viewers[viewerKey(viewerUcode, robotUcode, stream.Source)] = session
+	s.byHandle[handleKey(stream.Instance, handleID)] = session
+	s.mutex.Unlock()
+
+	log.Info().
+		Str("viewer", viewerUcode).
+		Str("robot", robotUcode).
+		Str("source", stream.Source).
+		Str("instance", stream.Instance).
+		Int("stream_id", stream.StreamID).
+		Int64("handle_id", handleID).
+		Msg("Viewer watch started")
+
+	return &models.CMD_WEBRTC_WATCH_RESPONSE{
+		UCode:    robotUcode,
+		Source:   stream.Source,
+		StreamID: stream.StreamID,
+		Offer:    *offer,
+	}, nil
+}
+
+// 查找观看会话，source为空时要求该机器人只有一个观看中的视频源
+func (s *SignalingService) session(viewerUcode, robotUcode, source string) (*models.ViewerSession, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if source != "" {
+		session, exists := s.viewers[viewerKey(viewerUcode, robotUcode, source)]
+		if !exists {
+			return nil, models.Errorf(models.ErrorCodeNotFound, "not watching source %s of robot %s", source, robotUcode)
+		}
+		return session, nil
+	}
+
+	var found *models.ViewerSession
+	for _, session := range s.viewers {
+		if session.ViewerUCode != viewerUcode || session.RobotUCode != robotUcode {
+			continue
+		}
+		if found != nil {
+			return nil, models.Errorf(models.ErrorCodeInvalidPayload, "watching several sources of robot %s, source is required", robotUcode)
+		}
+		found = session
+	}
+	if found == nil {
+		return nil, models.Errorf(models.ErrorCodeNotFound, "not watching robot %s", robotUcode)
+	}
+	return found, nil
+}
+
+// Answer 提交观看者的SDP answer
+func (s *SignalingService) Answer(viewerUcode, robotUcode, source string, answer models.JSEP) error {
+	if answer.Type != "answer" || answer.SDP == "" {
+		return models.NewError(models.ErrorCodeInvalidPayload, "answer must be a JSEP of type answer")
+	}
+
+	session, err := s.session(viewerUcode, robotUcode, source)
+	if err != nil {
+		return err
+	}
+
+	if err := s.janus.StartViewer(session.Instance, session.HandleID, answer); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	session.State = models.ViewerStateStarted
+	s.mutex.Unlock()
+	return nil
+}
+
+// Trickle 转发观看者的ICE候选
+func (s *SignalingService) Trickle(viewerUcode, robotUcode, source string, candidate *models.ICECandidate) error {
+	session, err := s.session(viewerUcode, robotUcode, source)
+	if err != nil {
+		return err
+	}
+	return s.janus.TrickleViewer(session.Instance, session.HandleID, candidate)
+}
+
+// Stop 停止观看
+func (s *SignalingService) Stop(viewerUcode, robotUcode, source string) error {
+	session, err := s.session(viewerUcode, robotUcode, source)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	_, exists := s.byHandle[handleKey(session.Instance, session.HandleID)]
+	s.remove(session)
+	s.mutex.Unlock()
+	if !exists {
+		return models.Errorf(models.ErrorCodeNotFound, "not watching robot %s", robotUcode)
+	}
+
+	if err := s.janus.DetachViewer(session.Instance, session.HandleID); err != nil {
+		log.Debug().Err(err).Int64("handle_id", session.HandleID).Msg("Failed to detach viewer handle")
+	}
+	log.Info().Str("viewer", viewerUcode).Str("robot", robotUcode).Str("source", session.Source).Msg("Viewer watch stopped")
+	return nil
+}
+
+// StopAll 停止观看者的全部会话，观看者断开时调用
+func (s *SignalingService) StopAll(viewerUcode string) {
+	for _, session := range s.GetViewers() {
+		if session.ViewerUCode == viewerUcode {
+			s.Stop(viewerUcode, session.RobotUCode, session.Source)
+		}
+	}
+}
+
+// GetViewers 获取所有观看会话
+func (s *SignalingService) GetViewers() []models.ViewerSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := make([]models.ViewerSession, 0, len(s.viewers))
+	for _, session := range s.viewers {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
+
+// 调用方持有 s.mutex
+func (s *SignalingService) remove(session *models.ViewerSession) {
+	delete(s.viewers, viewerKey(session.ViewerUCode, session.RobotUCode, session.Source))
+	delete(s.byHandle, handleKey(session.Instance, session.HandleID))
+}
+
+// HandleEvent 将Janus句柄事件转发给对应观看者
+func (s *SignalingService) HandleEvent(instance string, event *JanusResponse) {
+	s.mutex.Lock()
+	session, exists := s.byHandle[handleKey(instance, event.Sender)]
+	if !exists {
+		s.mutex.Unlock()
+		return
+	}
+	viewer := *session
+
+	state := models.CMD_WEBRTC_STATE{UCode: viewer.RobotUCode, Source: viewer.Source}
+	switch event.Janus {
+	case "trickle":
+		s.mutex.Unlock()
+		var candidate models.ICECandidate
+		if err := json.Unmarshal(event.Candidate, &candidate); err != nil {
+			return
+		}
+		s.push(viewer.ViewerUCode, models.CMD_TYPE_WEBRTC_ICE, viewer.RobotUCode, models.CMD_WEBRTC_ICE{
+			UCode:     viewer.RobotUCode,
+			Source:    viewer.Source,
+			Candidate: &candidate,
+		})
+		return
+	case "webrtcup":
+		session.State = models.ViewerStateUp
+		state.State = models.ViewerStateUp
+	case "media":
+		state.State = models.ViewerStateMedia
+		state.Media = event.Type
+		state.Receiving = event.Receiving
+	case "hangup":
+		s.remove(session)
+		state.State = models.ViewerStateHangup
+		state.Reason = event.Reason
+	case "detached":
+		s.remove(session)
+		state.State = models.ViewerStateStopped
+	default:
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+
+	s.push(viewer.ViewerUCode, models.CMD_TYPE_WEBRTC_STATE, viewer.RobotUCode, state)
+}
+
+// SessionReset Janus实例会话失效，通知该实例上的观看者重新发起观看
+func (s *SignalingService) SessionReset(instance string) {
+	s.hangup("janus session reset", func(session *models.ViewerSession) bool {
+		return session.Instance == instance
+	})
+}
+
+// StreamMigrated 流迁移到其他实例，观看者需重新观看，机器人需改向新地址推流
+func (s *SignalingService) StreamMigrated(from, to models.WebRTCStream) {
+	s.hangup("stream migrated", func(session *models.ViewerSession) bool {
+		return session.RobotUCode == from.UCode && session.Source == from.Source
+	})
+
+	s.push(to.UCode, models.CMD_TYPE_WEBRTC_PUSH, to.UCode, models.CMD_WEBRTC_PUSH{
+		Source:    to.Source,
+		PushURL:   to.PushURL,
+		RTPHost:   to.RTPHost,
+		VideoPort: to.VideoPort,
+		AudioPort: to.AudioPort,
+		Codec:     to.VideoCodec,
+		Reason:    "janus instance " + from.Instance + " unavailable",
+	})
+}
+
+// 移除匹配的观看会话并通知观看者
+func (s *SignalingService) hangup(reason string, match func(session *models.ViewerSession) bool) {
+	s.mutex.Lock()
+	sessions := make([]models.ViewerSession, 0)
+	for _, session := range s.viewers {
+		if match(session) {
+			sessions = append(sessions, *session)
+			s.remove(session)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, session := range sessions {
+		s.push(session.ViewerUCode, models.CMD_TYPE_WEBRTC_STATE, session.RobotUCode, models.CMD_WEBRTC_STATE{
+			UCode:  session.RobotUCode,
+			Source: session.Source,
+			State:  models.ViewerStateHangup,
+			Reason: reason,
+		})
+	}
+}
+
+// 向客户端推送信令消息
+func (s *SignalingService) push(ucode string, command models.CommandType, robotUcode string, data interface{}) {
+	s.hub.Publish(ClientTopic(ucode), models.Event{
+		Type:  models.EventTypeMessage,
+		UCode: ucode,
+		Data: models.WebSocketMessage{
+			Type:       models.WSMessageTypeRequest,
+			Command:    command,
+			Sequence:   atomic.AddInt64(&s.sequence, 1),
+			UCode:      robotUcode,
+			ClientType: models.ClientTypeRobot,
+			Version:    models.ProtocolVersionCurrent,
+			Data:       data,
+		},
+	})
+}
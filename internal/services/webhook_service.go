@@ -0,0 +1,585 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"remote-ctrl-robot/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Webhook默认配置
+const (
+	DefaultWebhookMaxRetries       = 8
+	DefaultWebhookInitialBackoff   = time.Second
+	DefaultWebhookMaxBackoff       = 5 * time.Minute
+	DefaultWebhookTimeout          = 5 * time.Second
+	DefaultWebhookBatteryThreshold = 20.0
+)
+
+// 队列日志条目数超过 当前记录数*倍数+余量 时压缩日志
+const (
+	webhookCompactFactor = 4
+	webhookCompactSlack  = 100
+)
+
+// 机器人上次上报的状态，用于检测状态变化
+type webhookRobotState struct {
+	status  string
+	battery float64
+}
+
+// WebhookService 将车队事件推送到外部Webhook
+type WebhookService struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	mutex  sync.Mutex
+
+	config     models.WebhookConfig
+	hub        *EventHub
+	httpClient *http.Client
+
+	// 观察到的事件，由事件循环在发布者之外处理，不丢弃
+	eventMutex  sync.Mutex
+	events      []models.Event
+	eventSignal chan struct{}
+	eventLoopWG sync.WaitGroup
+
+	// 待发送队列，持久化为按行追加的日志
+	outbox         []*models.WebhookDelivery
+	wake           chan struct{}
+	journal        *os.File
+	journalEntries int
+
+	// 机器人状态跟踪
+	robotStates map[string]webhookRobotState
+}
+
+// NewWebhookService 创建Webhook服务
+func NewWebhookService(config models.WebhookConfig, hub *EventHub) *WebhookService {
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = DefaultWebhookMaxRetries
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = DefaultWebhookInitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultWebhookMaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWebhookTimeout
+	}
+	if config.BatteryThreshold <= 0 {
+		config.BatteryThreshold = DefaultWebhookBatteryThreshold
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookService{
+		ctx:    ctx,
+		cancel: cancel,
+		config: config,
+		hub:    hub,
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+		},
+		eventSignal: make(chan struct{}, 1),
+		outbox:      make([]*models.WebhookDelivery, 0),
+		wake:        make(chan struct{}, 1),
+		robotStates: make(map[string]webhookRobotState),
+	}
+}
+
+// Start 加载持久化队列并开始处理事件
+func (s *WebhookService) Start() {
+	if len(s.config.Endpoints) == 0 {
+		log.Info().Msg("No webhook endpoints configured")
+		return
+	}
+
+	if err := s.loadOutbox(); err != nil {
+		log.Error().Err(err).Str("path", s.config.OutboxPath).Msg("Failed to load webhook outbox")
+	}
+
+	// 同步观察，不经过可能丢弃事件的订阅缓冲区
+	s.hub.Observe(s.observeEvent, TopicFleet, TopicGame)
+	s.eventLoopWG.Add(1)
+	go s.eventLoop()
+	go s.deliveryLoop()
+
+	log.Info().
+		Int("endpoints", len(s.config.Endpoints)).
+		Int("pending", len(s.outbox)).
+		Msg("Webhook service started")
+}
+
+// Shutdown 停止服务，已观察到的事件先写入队列
+func (s *WebhookService) Shutdown() {
+	s.cancel()
+	s.eventLoopWG.Wait()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+}
+
+// 在发布者goroutine中同步调用，只记录事件，序列化和文件写入交给事件循环，
+// 发布者可能持有连接锁
+func (s *WebhookService) observeEvent(event models.Event) {
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	s.eventMutex.Lock()
+	s.events = append(s.events, event)
+	s.eventMutex.Unlock()
+
+	select {
+	case s.eventSignal <- struct{}{}:
+	default:
+	}
+}
+
+// 事件循环，按观察顺序处理事件，退出前处理完剩余事件
+func (s *WebhookService) eventLoop() {
+	defer s.eventLoopWG.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.drainEvents()
+			return
+		case <-s.eventSignal:
+		}
+		s.drainEvents()
+	}
+}
+
+// 取出并处理所有已观察到的事件
+func (s *WebhookService) drainEvents() {
+	for {
+		s.eventMutex.Lock()
+		events := s.events
+		s.events = nil
+		s.eventMutex.Unlock()
+
+		if len(events) == 0 {
+			return
+		}
+		for _, event := range events {
+			s.handleEvent(event)
+		}
+	}
+}
+
+// 将内部事件转换为Webhook事件
+func (s *WebhookService) handleEvent(event models.Event) {
+	switch event.Type {
+	case models.EventTypeRobotConnected:
+		s.enqueue(models.WebhookEventRobotConnected, event.UCode, event.Timestamp, event.Data)
+
+	case models.EventTypeRobotDisconnected:
+		s.mutex.Lock()
+		delete(s.robotStates, event.UCode)
+		s.mutex.Unlock()
+		s.enqueue(models.WebhookEventRobotDisconnected, event.UCode, event.Timestamp, event.Data)
+
+	case models.EventTypeRobotState:
+		state, ok := event.Data.(models.RobotState)
+		if !ok {
+			return
+		}
+		s.handleRobotState(event, state)
+
+	case models.EventTypeGameEvent:
+		gameEvent, ok := event.Data.(models.CMD_GAME_EVENT)
+		if !ok || gameEvent.Event == nil || gameEvent.Event.Type != "game_end" {
+			return
+		}
+		s.enqueue(models.WebhookEventGameEnd, "", event.Timestamp, gameEvent)
+	}
+}
+
+// 检查机器人状态变化
+func (s *WebhookService) handleRobotState(event models.Event, state models.RobotState) {
+	s.mutex.Lock()
+	previous, known := s.robotStates[event.UCode]
+	s.robotStates[event.UCode] = webhookRobotState{
+		status:  state.Status,
+		battery: state.BatteryLevel,
+	}
+	s.mutex.Unlock()
+
+	if !known || previous.status != state.Status {
+		switch state.Status {
+		case "error":
+			s.enqueue(models.WebhookEventRobotError, event.UCode, event.Timestamp, state)
+		case "emergency_stop":
+			s.enqueue(models.WebhookEventRobotEmergencyStop, event.UCode, event.Timestamp, state)
+		}
+	}
+
+	threshold := s.config.BatteryThreshold
+	if state.BatteryLevel < threshold && (!known || previous.battery >= threshold) {
+		s.enqueue(models.WebhookEventBatteryLow, event.UCode, event.Timestamp, state)
+	} else if known && state.BatteryLevel >= threshold && previous.battery < threshold {
+		s.enqueue(models.WebhookEventBatteryRecovered, event.UCode, event.Timestamp, state)
+	}
+}
+
+// 检查目标是否订阅了该事件
+func endpointAccepts(endpoint models.WebhookEndpoint, event string) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+	for _, e := range endpoint.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// 为订阅该事件的每个目标生成待发送记录
+func (s *WebhookService) enqueue(event, ucode string, timestamp time.Time, data interface{}) {
+	payload := models.WebhookPayload{
+		ID:        generateID(),
+		Event:     event,
+		UCode:     ucode,
+		Timestamp: timestamp,
+		Data:      data,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("Failed to serialize webhook payload")
+		return
+	}
+
+	now := time.Now()
+	s.mutex.Lock()
+	queued := 0
+	for _, endpoint := range s.config.Endpoints {
+		if !endpointAccepts(endpoint, event) {
+			continue
+		}
+		delivery := &models.WebhookDelivery{
+			ID:          payload.ID,
+			URL:         endpoint.URL,
+			Event:       event,
+			Body:        body,
+			NextAttempt: now,
+			CreatedAt:   now,
+		}
+		s.outbox = append(s.outbox, delivery)
+		s.appendJournalLocked(models.WebhookJournalAdd, *delivery)
+		queued++
+	}
+	s.mutex.Unlock()
+
+	if queued > 0 {
+		log.Debug().Str("event", event).Str("ucode", ucode).Int("endpoints", queued).Msg("Webhook queued")
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 发送循环
+func (s *WebhookService) deliveryLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		s.deliverDue()
+	}
+}
+
+// 发送所有到期的记录
+func (s *WebhookService) deliverDue() {
+	now := time.Now()
+
+	s.mutex.Lock()
+	due := make([]*models.WebhookDelivery, 0)
+	for _, delivery := range s.outbox {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, delivery := range due {
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		err := s.send(delivery)
+
+		s.mutex.Lock()
+		delivery.Attempts++
+		switch {
+		case err == nil:
+			s.removeLocked(delivery)
+			s.appendJournalLocked(models.WebhookJournalRemove, models.WebhookDelivery{ID: delivery.ID, URL: delivery.URL})
+			log.Info().
+				Str("id", delivery.ID).
+				Str("event", delivery.Event).
+				Str("url", delivery.URL).
+				Int("attempts", delivery.Attempts).
+				Msg("Webhook delivered")
+		case delivery.Attempts >= s.config.MaxRetries:
+			s.removeLocked(delivery)
+			s.appendJournalLocked(models.WebhookJournalRemove, models.WebhookDelivery{ID: delivery.ID, URL: delivery.URL})
+			log.Error().Err(err).
+				Str("id", delivery.ID).
+				Str("event", delivery.Event).
+				Str("url", delivery.URL).
+				Int("attempts", delivery.Attempts).
+				Msg("Webhook delivery abandoned")
+		default:
+			delivery.LastError = err.Error()
+			delivery.NextAttempt = time.Now().Add(s.backoff(delivery.Attempts))
+			update := *delivery
+			update.Body = nil
+			s.appendJournalLocked(models.WebhookJournalUpdate, update)
+			log.Warn().Err(err).
+				Str("id", delivery.ID).
+				Str("event", delivery.Event).
+				Str("url", delivery.URL).
+				Int("attempts", delivery.Attempts).
+				Time("next_attempt", delivery.NextAttempt).
+				Msg("Webhook delivery failed, will retry")
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// 指数退避，带少量抖动
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	delay := s.config.InitialBackoff
+	for i := 1; i < attempts && delay < s.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.config.MaxBackoff {
+		delay = s.config.MaxBackoff
+	}
+	jitter := time.Duration(mathrand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
+
+// 查找目标的签名密钥
+func (s *WebhookService) secretFor(url string) string {
+	for _, endpoint := range s.config.Endpoints {
+		if endpoint.URL == url {
+			return endpoint.Secret
+		}
+	}
+	return ""
+}
+
+// 签名：HMAC-SHA256(secret, timestamp + "." + body)
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 发送单条记录
+func (s *WebhookService) send(delivery *models.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(s.ctx, "POST", delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if secret := s.secretFor(delivery.URL); secret != "" {
+		req.Header.Set("X-Webhook-Signature", signWebhook(secret, timestamp, delivery.Body))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// 从队列中移除记录，调用方需持有锁
+func (s *WebhookService) removeLocked(delivery *models.WebhookDelivery) {
+	for i, d := range s.outbox {
+		if d == delivery {
+			s.outbox = append(s.outbox[:i], s.outbox[i+1:]...)
+			return
+		}
+	}
+}
+
+// 追加一条队列日志，条目过多时压缩，调用方需持有锁
+func (s *WebhookService) appendJournalLocked(op string, delivery models.WebhookDelivery) {
+	if s.journal == nil {
+		return
+	}
+
+	data, err := json.Marshal(models.WebhookJournalEntry{Op: op, Delivery: delivery})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to serialize webhook journal entry")
+		return
+	}
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		log.Error().Err(err).Str("path", s.config.OutboxPath).Msg("Failed to append webhook journal")
+		return
+	}
+
+	s.journalEntries++
+	if s.journalEntries > webhookCompactFactor*len(s.outbox)+webhookCompactSlack {
+		s.compactLocked()
+	}
+}
+
+// 用当前队列重写日志并重新打开用于追加，调用方需持有锁
+func (s *WebhookService) compactLocked() {
+	if s.config.OutboxPath == "" {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, delivery := range s.outbox {
+		data, err := json.Marshal(models.WebhookJournalEntry{Op: models.WebhookJournalAdd, Delivery: *delivery})
+		if err != nil {
+			log.Error().Err(err).Str("id", delivery.ID).Msg("Failed to serialize webhook delivery")
+			continue
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.config.OutboxPath), 0755); err != nil {
+		log.Error().Err(err).Str("path", s.config.OutboxPath).Msg("Failed to create webhook outbox directory")
+		return
+	}
+
+	// 先写临时文件再重命名，避免写入中断导致文件损坏
+	tmpPath := s.config.OutboxPath + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		log.Error().Err(err).Str("path", tmpPath).Msg("Failed to write webhook outbox")
+		return
+	}
+	if err := os.Rename(tmpPath, s.config.OutboxPath); err != nil {
+		log.Error().Err(err).Str("path", s.config.OutboxPath).Msg("Failed to replace webhook outbox")
+		return
+	}
+
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	journal, err := os.OpenFile(s.config.OutboxPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Error().Err(err).Str("path", s.config.OutboxPath).Msg("Failed to open webhook journal")
+		return
+	}
+	s.journal = journal
+	s.journalEntries = len(s.outbox)
+}
+
+// 加载持久化队列，兼容旧版JSON数组格式，加载后压缩日志
+func (s *WebhookService) loadOutbox() error {
+	if s.config.OutboxPath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.config.OutboxPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var outbox []*models.WebhookDelivery
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &outbox); err != nil {
+			return fmt.Errorf("failed to parse outbox: %w", err)
+		}
+	} else {
+		outbox = replayWebhookJournal(data)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.outbox = append(s.outbox, outbox...)
+	s.compactLocked()
+	return nil
+}
+
+// 重放队列日志，末尾不完整的条目(写入中断)被忽略
+func replayWebhookJournal(data []byte) []*models.WebhookDelivery {
+	outbox := make([]*models.WebhookDelivery, 0)
+	index := make(map[string]*models.WebhookDelivery)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var entry models.WebhookJournalEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if err != io.EOF {
+				log.Warn().Err(err).Msg("Webhook journal truncated, ignoring remaining entries")
+			}
+			break
+		}
+
+		key := entry.Delivery.ID + " " + entry.Delivery.URL
+		switch entry.Op {
+		case models.WebhookJournalAdd:
+			delivery := entry.Delivery
+			index[key] = &delivery
+			outbox = append(outbox, &delivery)
+		case models.WebhookJournalUpdate:
+			if delivery, exists := index[key]; exists {
+				delivery.Attempts = entry.Delivery.Attempts
+				delivery.NextAttempt = entry.Delivery.NextAttempt
+				delivery.LastError = entry.Delivery.LastError
+			}
+		case models.WebhookJournalRemove:
+			delete(index, key)
+		}
+	}
+
+	// 只保留未被移除的记录，保持加入顺序
+	result := make([]*models.WebhookDelivery, 0, len(index))
+	for _, delivery := range outbox {
+		if index[delivery.ID+" "+delivery.URL] == delivery {
+			result = append(result, delivery)
+		}
+	}
+	return result
+}
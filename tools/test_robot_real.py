@@ -112,6 +112,9 @@ class RobotClient:
             
             if action == "Move":
                 self.handle_move_command(params)
+            elif action == "stop":
+                # 急停：立即停止移动
+                self.handle_move_command({"vx": 0, "vy": 0, "vyaw": 0})
             elif action == "shoot":
                 self.handle_shoot_command()
             elif action == "raise":
@@ -220,11 +223,16 @@ class RobotClient:
             "sequence": self.sequence,
             "ucode": self.ucode,
             "client_type": "robot",
-            "version": "1.0.0",
+            "version": "1.1.0",
             "data": {
                 "name": f"Go2机器人_{self.ucode}",
-                "robot_type": "go2",
-                "capabilities": ["move", "shoot", "raise", "lower"]
+                "capabilities": {
+                    "model": "go2",
+                    "firmware": "1.0.0",
+                    "actions": ["Move", "stop", "shoot", "raise", "lower"],
+                    "sensors": ["armor", "imu"],
+                    "video_sources": [{"name": "front", "codec": "h264"}]
+                }
             }
         }
         ws.send(json.dumps(register_msg))
@@ -283,7 +291,7 @@ class RobotClient:
                 "sequence": self.sequence,
                 "ucode": self.ucode,
                 "client_type": "robot",
-                "version": "1.0.0",
+                "version": "1.1.0",
                 "data": {
                     "timestamp": int(time.time() * 1000)
                 }
@@ -304,7 +312,7 @@ class RobotClient:
                 "sequence": self.sequence,
                 "ucode": self.ucode,
                 "client_type": "robot",
-                "version": "1.0.0",
+                "version": "1.1.0",
                 "data": {
                     "status": self.robot_state.status,
                     "battery_level": self.robot_state.battery_level,